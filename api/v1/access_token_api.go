@@ -0,0 +1,78 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"redbook/api/v1/request"
+	"redbook/internal/audit"
+	"redbook/model"
+	"redbook/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessTokenAPI exposes personal access token management.
+type AccessTokenAPI struct {
+	service *service.AccessTokenService
+}
+
+// NewAccessTokenAPI wires the access token service into the HTTP handlers.
+func NewAccessTokenAPI(s *service.AccessTokenService) *AccessTokenAPI {
+	return &AccessTokenAPI{service: s}
+}
+
+// Create 创建个人访问 token；明文只在本次响应中返回。
+func (a *AccessTokenAPI) Create(c *gin.Context) {
+	var req request.CreateAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	token, plain, err := a.service.Create(c.GetUint("user_id"), req.Name, req.Scopes, req.ExpiresIn)
+	switch {
+	case err == nil:
+		service.RecordAudit(model.AuditEvent{Event: audit.EventTokenCreate, UserID: token.UserID, Reason: "token " + token.Prefix}, sessionClient(c), nil)
+		c.JSON(http.StatusCreated, gin.H{"token": plain, "access_token": token})
+	case errors.Is(err, service.ErrAccessTokenScope), errors.Is(err, service.ErrAccessTokenTTL):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAccessTokenLimit):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "create access token failed"})
+	}
+}
+
+// List 列出当前用户的个人访问 token（不含明文）。
+func (a *AccessTokenAPI) List(c *gin.Context) {
+	tokens, err := a.service.List(c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "list access tokens failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"access_tokens": tokens})
+}
+
+// Revoke 吊销当前用户的某个个人访问 token。
+func (a *AccessTokenAPI) Revoke(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token id"})
+		return
+	}
+	revoked, err := a.service.Revoke(c.GetUint("user_id"), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "revoke access token failed"})
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "access token not found"})
+		return
+	}
+	service.RecordAudit(model.AuditEvent{
+		Event:  audit.EventTokenRevoke,
+		UserID: uint64(c.GetUint("user_id")),
+		Reason: "token id " + strconv.FormatUint(id, 10),
+	}, sessionClient(c), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "access token revoked"})
+}
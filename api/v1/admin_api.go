@@ -0,0 +1,31 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"redbook/internal/audit"
+	"redbook/model"
+	"redbook/service"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// UnlockUser 按路径中的用户 ID 解除该用户的登录失败锁定，用户名与手机号两种登录标识一并清除。
+func (u *UserAPI) UnlockUser(c *gin.Context) {
+	id, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	err := u.service.UnlockAccount(id)
+	service.RecordAudit(model.AuditEvent{Event: audit.EventUnlock, UserID: uint64(id), ActorID: uint64(c.GetUint("user_id"))}, sessionClient(c), err)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unlock failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "account unlocked"})
+}
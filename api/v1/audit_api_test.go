@@ -0,0 +1,195 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"redbook/config"
+	"redbook/dao"
+	"redbook/internal/audit"
+	"redbook/internal/auth"
+	"redbook/internal/sms"
+	"redbook/model"
+	"redbook/service"
+	"redbook/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// auditRecorder 是内存中的审计 Sink，测试据此检查 handler 实际写出的事件。
+type auditRecorder struct {
+	mu     sync.Mutex
+	events []model.AuditEvent
+}
+
+func (r *auditRecorder) WriteAuditEvents(events []model.AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, events...)
+	return nil
+}
+
+// find 返回指定类型的全部事件。
+func (r *auditRecorder) find(event string) []model.AuditEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []model.AuditEvent
+	for _, e := range r.events {
+		if e.Event == event {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// only 返回唯一一条指定类型的事件。
+func (r *auditRecorder) only(t *testing.T, event string) model.AuditEvent {
+	t.Helper()
+	found := r.find(event)
+	if len(found) != 1 {
+		t.Fatalf("recorded %d %s events, want 1: %+v", len(found), event, r.events)
+	}
+	return found[0]
+}
+
+// recordAudits 在 fn 执行期间收集审计事件；返回时 defer 的 stop 已写完队列。
+func recordAudits(t *testing.T, fn func()) *auditRecorder {
+	t.Helper()
+	rec := &auditRecorder{}
+	stop := audit.Start(rec, 0)
+	defer stop()
+	fn()
+	return rec
+}
+
+// newTestUserService 在 SQLite 与内存会话存储上建立 UserService。
+func newTestUserService(t *testing.T) (*service.UserService, *gorm.DB) {
+	t.Helper()
+	config.GlobalConfig = &config.Config{JWT: config.JWTConfig{
+		Secret:        "test-secret",
+		AccessExpire:  60,
+		RefreshExpire: 600,
+	}}
+	if _, err := auth.InitKeys(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	if err := db.AutoMigrate(&model.User{}, &model.Role{}, &model.Permission{}, &model.UserRole{}, &model.KnownDevice{}); err != nil {
+		t.Fatal(err)
+	}
+	return service.NewUserService(dao.NewUserDAO(db), auth.NewMemoryStore(), sms.NewLogProvider("")), db
+}
+
+func createTestUser(t *testing.T, db *gorm.DB, username, mobile, password string) *model.User {
+	t.Helper()
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &model.User{Username: username, Mobile: mobile, Nickname: username, Password: hash}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// serve 以 actorID 身份（0 表示未登录）调用 handler。
+func serve(method, route, target, body string, actorID uint, header http.Header, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Handle(method, route, func(c *gin.Context) {
+		if actorID != 0 {
+			c.Set("user_id", actorID)
+			c.Set("device", "admin-console")
+		}
+	}, handler)
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestLoginAndLogoutRecordAuditEvents(t *testing.T) {
+	users, db := newTestUserService(t)
+	user := createTestUser(t, db, "quinn", "13800000070", "Secret#2024")
+	api := NewUserAPI(users)
+
+	var access string
+	rec := recordAudits(t, func() {
+		w := serve(http.MethodPost, "/login", "/login", `{"username":"quinn","password":"Secret#2024"}`, 0,
+			http.Header{"X-Device": {"phone"}}, api.Login)
+		if w.Code != http.StatusOK {
+			t.Fatalf("login: %d %s", w.Code, w.Body.String())
+		}
+		var err error
+		if access, _, err = users.Login("quinn", "Secret#2024", service.ClientInfo{Device: "tablet"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	login := rec.find(audit.EventLoginPassword)
+	if len(login) != 2 || login[0].Result != audit.ResultSuccess || login[0].UserID != user.ID || login[0].Device != "phone" {
+		t.Fatalf("login events = %+v", login)
+	}
+
+	rec = recordAudits(t, func() {
+		w := serve(http.MethodPost, "/logout", "/logout", "", 0, http.Header{"Authorization": {"Bearer " + access}}, api.Logout)
+		if w.Code != http.StatusOK {
+			t.Fatalf("logout: %d %s", w.Code, w.Body.String())
+		}
+	})
+	logout := rec.only(t, audit.EventLogout)
+	if logout.Result != audit.ResultSuccess || logout.UserID != user.ID || logout.Device != "tablet" {
+		t.Fatalf("logout event = %+v", logout)
+	}
+}
+
+func TestAdminActionsRecordActor(t *testing.T) {
+	users, db := newTestUserService(t)
+	admin := createTestUser(t, db, "root", "13800000071", "Secret#2024")
+	target := createTestUser(t, db, "rhea", "13800000072", "Secret#2024")
+	rbac := service.NewRBACService(dao.NewRoleDAO(db), dao.NewUserDAO(db), users.Session.Store())
+	if err := rbac.SeedRoles([]config.RoleConfig{{Name: "moderator", Permissions: []string{"notes:moderate"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/users/" + strconv.FormatUint(target.ID, 10)
+
+	rec := recordAudits(t, func() {
+		if w := serve(http.MethodPost, "/users/:id/unlock", path+"/unlock", "", uint(admin.ID), nil, NewUserAPI(users).UnlockUser); w.Code != http.StatusOK {
+			t.Fatalf("unlock: %d %s", w.Code, w.Body.String())
+		}
+		if w := serve(http.MethodPost, "/users/:id/roles", path+"/roles", `{"role":"moderator"}`, uint(admin.ID), nil, NewRBACAPI(rbac).GrantRole); w.Code != http.StatusOK {
+			t.Fatalf("grant: %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	for _, event := range []string{audit.EventUnlock, audit.EventRoleGrant} {
+		e := rec.only(t, event)
+		if e.UserID != target.ID || e.ActorID != admin.ID || e.Result != audit.ResultSuccess || e.Device != "admin-console" {
+			t.Errorf("%s event = %+v", event, e)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package v1
+
+import (
+	"net/http"
+	"redbook/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NewCaptcha 生成一张图形验证码。答案保存在服务端，客户端在登录 / 注册时
+// 通过 X-Captcha-Id 与 X-Captcha-Answer 请求头提交。
+func (u *UserAPI) NewCaptcha(c *gin.Context) {
+	challenge, err := u.service.NewCaptcha()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "generate captcha failed"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, challenge)
+}
+
+// respondCaptcha 在需要验证码时返回 403 与 captcha_required，客户端据此
+// 获取验证码后重试。
+func respondCaptcha(c *gin.Context, err error) bool {
+	if !service.IsCaptchaError(err) {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "captcha_required": true})
+	return true
+}
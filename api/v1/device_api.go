@@ -0,0 +1,85 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"redbook/api/v1/request"
+	"redbook/internal/metrics"
+	"redbook/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HeaderDeviceTrust 可信设备的信任凭证：标记可信时在响应头中下发，此后登录时原样携带。
+const HeaderDeviceTrust = "X-Device-Trust"
+
+// LoginStepUp 提交短信验证码，确认来自新设备或陌生网络的登录。
+func (u *UserAPI) LoginStepUp(c *gin.Context) {
+	var req request.StepUpLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		metrics.IncLogin("bad_request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := u.service.CompleteStepUp(req.StepUpToken, req.Code, req.TrustDevice)
+	if err != nil {
+		respondLogin(c, "", "", err)
+		return
+	}
+	if result.DeviceTrust != "" {
+		c.Header(HeaderDeviceTrust, result.DeviceTrust)
+	}
+	respondLogin(c, result.AccessToken, result.RefreshToken, nil)
+}
+
+// ListDevices 列出登录过的设备及其可信状态。
+func (u *UserAPI) ListDevices(c *gin.Context) {
+	devices, err := u.service.ListKnownDevices(c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "list devices failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+// TrustDevice 将当前设备标记为可信并在 X-Device-Trust 响应头中下发信任凭证；
+// 此后该设备携带凭证登录时不再要求短信验证，陌生网络仍会提醒。
+func (u *UserAPI) TrustDevice(c *gin.Context) {
+	secret, err := u.service.TrustDevice(c.GetUint("user_id"), c.GetString("device"), c.Param("device"))
+	if err != nil {
+		respondDeviceError(c, err)
+		return
+	}
+	c.Header(HeaderDeviceTrust, secret)
+	c.JSON(http.StatusOK, gin.H{"device": c.Param("device"), "trusted": true})
+}
+
+// UntrustDevice 取消设备的可信标记，已下发的信任凭证随之失效。
+func (u *UserAPI) UntrustDevice(c *gin.Context) {
+	if err := u.service.UntrustDevice(c.GetUint("user_id"), c.Param("device")); err != nil {
+		respondDeviceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"device": c.Param("device"), "trusted": false})
+}
+
+// ForgetDevice 从登录历史中移除设备，下次登录会重新视为新设备。
+func (u *UserAPI) ForgetDevice(c *gin.Context) {
+	if err := u.service.ForgetDevice(c.GetUint("user_id"), c.Param("device")); err != nil {
+		respondDeviceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "device forgotten"})
+}
+
+func respondDeviceError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrDeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrTrustOtherDevice) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "update device failed"})
+}
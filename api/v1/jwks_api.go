@@ -0,0 +1,15 @@
+package v1
+
+import (
+	"net/http"
+	"redbook/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public signing keys so other services can verify tokens
+// without holding any secret.
+func JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, auth.Keys().JWKS())
+}
@@ -0,0 +1,74 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"redbook/api/v1/request"
+	"redbook/internal/metrics"
+	"redbook/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoginMFA 提交 TOTP 或恢复码完成两步验证登录。
+func (u *UserAPI) LoginMFA(c *gin.Context) {
+	var req request.MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		metrics.IncLogin("bad_request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	access, refresh, err := u.service.CompleteMFALogin(req.MFAToken, req.Code, req.RecoveryCode)
+	respondLogin(c, access, refresh, err)
+}
+
+// EnrollTOTP 生成待确认的 TOTP 密钥及 otpauth URI。
+func (u *UserAPI) EnrollTOTP(c *gin.Context) {
+	enrollment, err := u.service.EnrollTOTP(c.GetUint("user_id"))
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmTOTP 校验首个动态码，开启两步验证并返回一次性恢复码。
+func (u *UserAPI) ConfirmTOTP(c *gin.Context) {
+	var req request.TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	codes, err := u.service.ConfirmTOTP(c.GetUint("user_id"), req.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication enabled", "recovery_codes": codes})
+}
+
+// DisableTOTP 使用动态码或恢复码关闭两步验证。
+func (u *UserAPI) DisableTOTP(c *gin.Context) {
+	var req request.DisableTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := u.service.DisableTOTP(c.GetUint("user_id"), req.Code, req.RecoveryCode); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// respondMFAError 将两步验证相关错误映射为 HTTP 状态码。
+func respondMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrMFAAlreadyEnabled), errors.Is(err, service.ErrMFANotEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrMFANotEnrolling), errors.Is(err, service.ErrMFACodeInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "two-factor operation failed"})
+	}
+}
@@ -0,0 +1,195 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"redbook/api/v1/request"
+	"redbook/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OAuthAPI exposes the OAuth 2.0 authorization server endpoints.
+type OAuthAPI struct {
+	service *service.OAuthService
+	users   *service.UserService
+}
+
+// NewOAuthAPI wires the OAuth service into the HTTP handlers.
+func NewOAuthAPI(s *service.OAuthService, users *service.UserService) *OAuthAPI {
+	return &OAuthAPI{service: s, users: users}
+}
+
+// RegisterClient 为当前用户注册第三方应用；机密客户端的 secret 只返回这一次。
+func (o *OAuthAPI) RegisterClient(c *gin.Context) {
+	var req request.RegisterClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	client, secret, err := o.service.RegisterClient(c.GetUint("user_id"), req.Name, req.RedirectURIs, req.Scopes, req.Public)
+	if err != nil {
+		respondOAuthError(c, err)
+		return
+	}
+	resp := gin.H{
+		"client_id":     client.ClientID,
+		"name":          client.Name,
+		"redirect_uris": req.RedirectURIs,
+		"scopes":        client.Scopes,
+	}
+	if secret != "" {
+		resp["client_secret"] = secret
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// Authorize 处理授权请求：GET 判断是否需要用户确认，POST 提交用户的同意 / 拒绝。
+// 结果以 JSON 返回 redirect_to，由客户端完成跳转。
+func (o *OAuthAPI) Authorize(c *gin.Context) {
+	var req request.AuthorizeRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+	var decision *bool
+	if c.Request.Method == http.MethodPost {
+		decision = &req.Approve
+	}
+	result, err := o.service.Authorize(c.GetUint("user_id"), service.AuthorizeRequest{
+		ResponseType:        req.ResponseType,
+		ClientID:            req.ClientID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		State:               req.State,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+	}, decision)
+	if err != nil {
+		respondOAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// Token 实现 token 端点（authorization_code / refresh_token）。
+func (o *OAuthAPI) Token(c *gin.Context) {
+	var req request.TokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+	clientID, secret := clientCredentials(c, req.ClientID, req.ClientSecret)
+	resp, err := o.service.Exchange(service.TokenRequest{
+		GrantType:    req.GrantType,
+		Code:         req.Code,
+		RedirectURI:  req.RedirectURI,
+		CodeVerifier: req.CodeVerifier,
+		RefreshToken: req.RefreshToken,
+		ClientID:     clientID,
+		ClientSecret: secret,
+	}, clientInfo(c))
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	if err != nil {
+		respondOAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Introspect 实现 RFC 7662：返回 token 是否仍然有效及其 claims。
+func (o *OAuthAPI) Introspect(c *gin.Context) {
+	var req request.TokenActionRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+	clientID, secret := clientCredentials(c, req.ClientID, req.ClientSecret)
+	resp, err := o.service.Introspect(clientID, secret, req.Token, req.TokenTypeHint)
+	c.Header("Cache-Control", "no-store")
+	if err != nil {
+		respondOAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Revoke 实现 RFC 7009：无效 token 同样返回 200。
+func (o *OAuthAPI) Revoke(c *gin.Context) {
+	var req request.TokenActionRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+	clientID, secret := clientCredentials(c, req.ClientID, req.ClientSecret)
+	if err := o.service.Revoke(clientID, secret, req.Token); err != nil {
+		respondOAuthError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// ListConsents 列出当前用户授权过的第三方应用。
+func (o *OAuthAPI) ListConsents(c *gin.Context) {
+	consents, err := o.service.ListConsents(c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "list consents failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"consents": consents})
+}
+
+// RevokeConsent 撤销对某应用的授权并下线其会话。
+func (o *OAuthAPI) RevokeConsent(c *gin.Context) {
+	err := o.service.RevokeConsent(c.GetUint("user_id"), c.Param("client_id"))
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "consent not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "revoke consent failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "consent revoked"})
+}
+
+// UserInfo 返回授权用户的基本资料，需要 profile scope。
+func (o *OAuthAPI) UserInfo(c *gin.Context) {
+	user, err := o.users.GetProfile(c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"sub":        user.ID,
+		"username":   user.Username,
+		"nickname":   user.Nickname,
+		"avatar_url": user.AvatarURL,
+	})
+}
+
+// clientCredentials 支持 client_secret_basic 与 client_secret_post 两种客户端认证方式。
+func clientCredentials(c *gin.Context, formID, formSecret string) (string, string) {
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		return id, secret
+	}
+	return formID, formSecret
+}
+
+// respondOAuthError 按 RFC 6749 输出错误；可重定向的错误以 redirect_to 返回给客户端。
+func respondOAuthError(c *gin.Context, err error) {
+	var oe *service.OAuthError
+	if !errors.As(err, &oe) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if oe.RedirectTo != "" {
+		c.JSON(http.StatusOK, gin.H{"redirect_to": oe.RedirectTo})
+		return
+	}
+	if oe.Code == "invalid_client" {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	c.JSON(oe.Status, gin.H{"error": oe.Code, "error_description": oe.Description})
+}
@@ -0,0 +1,106 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"redbook/api/v1/request"
+	"redbook/internal/audit"
+	"redbook/internal/validator"
+	"redbook/model"
+	"redbook/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SendResetCode 发送找回密码验证码；无论手机号是否注册都返回相同结果。
+func (u *UserAPI) SendResetCode(c *gin.Context) {
+	var req request.SMSCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := u.service.SendResetCode(req.Mobile, c.ClientIP()); err != nil {
+		respondOTPError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "code sent"})
+}
+
+// VerifyResetCode 校验验证码，换取一次性的 reset_token。
+func (u *UserAPI) VerifyResetCode(c *gin.Context) {
+	var req request.VerifyResetCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	token, ttl, err := u.service.VerifyResetCode(req.Mobile, req.Code)
+	if err != nil {
+		respondOTPError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reset_token": token, "expires_in": int64(ttl.Seconds())})
+}
+
+// ResetPassword 使用 reset_token 设置新密码，并下线该用户的全部设备。
+func (u *UserAPI) ResetPassword(c *gin.Context) {
+	var req request.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := u.service.ResetPassword(req.ResetToken, req.NewPassword, clientInfo(c)); err != nil {
+		if respondPasswordPolicy(c, "new_password", err) {
+			return
+		}
+		if errors.Is(err, service.ErrResetTokenInvalid) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "reset password failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "password reset"})
+}
+
+// ChangePassword 校验当前密码后修改密码；keep_current_session 为 true 时仅下线其他设备。
+func (u *UserAPI) ChangePassword(c *gin.Context) {
+	var req request.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := u.service.ChangePassword(c.GetUint("user_id"), c.GetString("device"), req.OldPassword, req.NewPassword, req.KeepCurrentSession)
+	if !errors.Is(err, service.ErrPasswordUnchanged) {
+		service.RecordAudit(model.AuditEvent{Event: audit.EventPasswordChange, UserID: uint64(c.GetUint("user_id"))}, sessionClient(c), err)
+	}
+	if respondPasswordPolicy(c, "new_password", err) {
+		return
+	}
+	var throttled *service.LoginThrottledError
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"message": "password changed", "signed_out": !req.KeepCurrentSession})
+	case errors.As(err, &throttled):
+		respondThrottled(c, throttled)
+	case errors.Is(err, service.ErrPasswordMismatch):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrPasswordUnchanged):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "change password failed"})
+	}
+}
+
+// respondPasswordPolicy writes a 400 listing every failed password rule under
+// the request field it applies to. It reports whether err was a policy error.
+func respondPasswordPolicy(c *gin.Context, field string, err error) bool {
+	var policy *validator.PasswordPolicyError
+	if !errors.As(err, &policy) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":  "password does not meet policy",
+		"fields": gin.H{field: policy.Violations},
+	})
+	return true
+}
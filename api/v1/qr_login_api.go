@@ -0,0 +1,123 @@
+package v1
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"redbook/internal/metrics"
+	"redbook/service"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateQRLogin 网页端生成扫码登录票据；ticket 写入二维码，poll_token 留在网页端用于领取 token。
+func (u *UserAPI) CreateQRLogin(c *gin.Context) {
+	ticket, err := u.service.CreateQRLogin(clientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "create qr login failed"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, ticket)
+}
+
+// PollQRLogin 网页端轮询票据状态，确认后的首次轮询返回 token 对。
+func (u *UserAPI) PollQRLogin(c *gin.Context) {
+	state, err := u.service.PollQRLogin(c.Param("ticket"), qrPollToken(c))
+	if err != nil {
+		respondQRLoginError(c, err)
+		return
+	}
+	if state.AccessToken != "" {
+		metrics.IncLogin("success")
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, state)
+}
+
+// QRLoginEvents 以 SSE 推送票据状态变化，直到确认、拒绝或过期。
+// EventSource 无法设置请求头，poll_token 也可通过查询参数传递。
+func (u *UserAPI) QRLoginEvents(c *gin.Context) {
+	ticket, pollToken := c.Param("ticket"), qrPollToken(c)
+	state, err := u.service.PollQRLogin(ticket, pollToken)
+	if err != nil {
+		respondQRLoginError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Accel-Buffering", "no")
+	ticker := time.NewTicker(service.QRPollInterval())
+	defer ticker.Stop()
+	last := ""
+	c.Stream(func(w io.Writer) bool {
+		if state.Status != last {
+			c.SSEvent("status", state)
+			last = state.Status
+		}
+		if state.Status != service.QRStatusPending && state.Status != service.QRStatusScanned {
+			if state.AccessToken != "" {
+				metrics.IncLogin("success")
+			}
+			return false
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-ticker.C:
+		}
+		if state, err = u.service.PollQRLogin(ticket, pollToken); err != nil {
+			c.SSEvent("error", gin.H{"error": err.Error()})
+			return false
+		}
+		return true
+	})
+}
+
+// ScanQRLogin 移动端扫码，返回发起登录的网页端信息供用户核对。
+func (u *UserAPI) ScanQRLogin(c *gin.Context) {
+	requester, err := u.service.ScanQRLogin(c.GetUint("user_id"), c.Param("ticket"))
+	if err != nil {
+		respondQRLoginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": service.QRStatusScanned, "requester": requester})
+}
+
+// ConfirmQRLogin 移动端确认登录，网页端下一次轮询即可领取 token。
+func (u *UserAPI) ConfirmQRLogin(c *gin.Context) {
+	if err := u.service.ConfirmQRLogin(c.GetUint("user_id"), c.Param("ticket")); err != nil {
+		respondQRLoginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": service.QRStatusConfirmed})
+}
+
+// RejectQRLogin 移动端拒绝登录。
+func (u *UserAPI) RejectQRLogin(c *gin.Context) {
+	if err := u.service.RejectQRLogin(c.GetUint("user_id"), c.Param("ticket")); err != nil {
+		respondQRLoginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": service.QRStatusRejected})
+}
+
+func qrPollToken(c *gin.Context) string {
+	if token := c.GetHeader("X-QR-Poll-Token"); token != "" {
+		return token
+	}
+	return c.Query("poll_token")
+}
+
+// respondQRLoginError 将扫码登录错误映射为 HTTP 状态码。
+func respondQRLoginError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrQRTicketNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrQRTicketState), errors.Is(err, service.ErrSessionLimit):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "qr login failed"})
+	}
+}
@@ -0,0 +1,122 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"redbook/api/v1/request"
+	"redbook/internal/audit"
+	"redbook/model"
+	"redbook/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RBACAPI exposes role management for administrators.
+type RBACAPI struct {
+	service *service.RBACService
+}
+
+// NewRBACAPI wires the RBAC service into the HTTP handlers.
+func NewRBACAPI(s *service.RBACService) *RBACAPI {
+	return &RBACAPI{service: s}
+}
+
+// ListRoles 列出全部角色及其权限。
+func (r *RBACAPI) ListRoles(c *gin.Context) {
+	roles, err := r.service.ListRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "list roles failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// UserRoles 查看指定用户当前生效的角色与权限。
+func (r *RBACAPI) UserRoles(c *gin.Context) {
+	id, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	roles, err := r.service.UserRoles(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query roles failed"})
+		return
+	}
+	perms, err := r.service.Permissions(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query roles failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles, "permissions": perms})
+}
+
+// GrantRole 授予指定用户角色。
+func (r *RBACAPI) GrantRole(c *gin.Context) {
+	id, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	var req request.GrantRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := r.service.GrantRole(c.GetUint("user_id"), id, req.Role); err != nil {
+		respondRoleError(c, err)
+		return
+	}
+	auditRoleChange(c, audit.EventRoleGrant, id, req.Role)
+	c.JSON(http.StatusOK, gin.H{"message": "role granted"})
+}
+
+// RevokeRole 收回指定用户的角色。
+func (r *RBACAPI) RevokeRole(c *gin.Context) {
+	id, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	revoked, err := r.service.RevokeRole(c.GetUint("user_id"), id, c.Param("role"))
+	if err != nil {
+		respondRoleError(c, err)
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "role not granted"})
+		return
+	}
+	auditRoleChange(c, audit.EventRoleRevoke, id, c.Param("role"))
+	c.JSON(http.StatusOK, gin.H{"message": "role revoked"})
+}
+
+// userIDParam parses the :id path parameter, writing a 400 when it is malformed.
+func userIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// auditRoleChange records a role grant or revocation made by the calling admin.
+func auditRoleChange(c *gin.Context, event string, userID uint, role string) {
+	service.RecordAudit(model.AuditEvent{
+		Event:   event,
+		UserID:  uint64(userID),
+		ActorID: uint64(c.GetUint("user_id")),
+		Reason:  "role " + role,
+	}, sessionClient(c), nil)
+}
+
+func respondRoleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, service.ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "update roles failed"})
+	}
+}
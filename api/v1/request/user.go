@@ -2,7 +2,7 @@ package request
 
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"` // 强度由 service 层的密码策略校验
 	Mobile   string `json:"mobile" binding:"required,mobile"`
 }
 
@@ -14,3 +14,98 @@ type LoginRequest struct {
 type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
 }
+
+type SMSCodeRequest struct {
+	Mobile string `json:"mobile" binding:"required,mobile"`
+}
+
+type SMSLoginRequest struct {
+	Mobile string `json:"mobile" binding:"required,mobile"`
+	Code   string `json:"code" binding:"required,len=6,numeric"`
+}
+
+type VerifyMobileRequest struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
+
+type VerifyResetCodeRequest struct {
+	Mobile string `json:"mobile" binding:"required,mobile"`
+	Code   string `json:"code" binding:"required,len=6,numeric"`
+}
+
+type ResetPasswordRequest struct {
+	ResetToken  string `json:"reset_token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	OldPassword        string `json:"old_password" binding:"required"`
+	NewPassword        string `json:"new_password" binding:"required"`
+	KeepCurrentSession bool   `json:"keep_current_session"`
+}
+
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code" binding:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code" binding:"required_without=Code"`
+}
+
+type StepUpLoginRequest struct {
+	StepUpToken string `json:"step_up_token" binding:"required"`
+	Code        string `json:"code" binding:"required,len=6,numeric"`
+	TrustDevice bool   `json:"trust_device"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
+
+type DisableTOTPRequest struct {
+	Code         string `json:"code" binding:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code" binding:"required_without=Code"`
+}
+
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type" json:"response_type" binding:"required"`
+	ClientID            string `form:"client_id" json:"client_id" binding:"required"`
+	RedirectURI         string `form:"redirect_uri" json:"redirect_uri" binding:"required"`
+	Scope               string `form:"scope" json:"scope"`
+	State               string `form:"state" json:"state"`
+	CodeChallenge       string `form:"code_challenge" json:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"code_challenge_method"`
+	Approve             bool   `form:"approve" json:"approve"`
+}
+
+type TokenRequest struct {
+	GrantType    string `form:"grant_type" binding:"required"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+type RegisterClientRequest struct {
+	Name         string   `json:"name" binding:"required,max=100"`
+	RedirectURIs []string `json:"redirect_uris" binding:"required,min=1,dive,url"`
+	Scopes       []string `json:"scopes"`
+	Public       bool     `json:"public"`
+}
+
+type TokenActionRequest struct {
+	Token         string `form:"token" binding:"required"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+type GrantRoleRequest struct {
+	Role string `json:"role" binding:"required,max=50"`
+}
+
+type CreateAccessTokenRequest struct {
+	Name      string   `json:"name" binding:"required,max=100"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int64    `json:"expires_in_days" binding:"gte=0"`
+}
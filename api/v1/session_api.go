@@ -0,0 +1,68 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"redbook/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListSessions 列出当前用户的所有在线设备。
+func (u *UserAPI) ListSessions(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	current := c.GetString("device")
+	sessions, err := u.service.ListSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "list sessions failed"})
+		return
+	}
+	items := make([]gin.H, 0, len(sessions))
+	for _, sess := range sessions {
+		item := gin.H{
+			"device":            sess.Device,
+			"device_name":       sess.DeviceName,
+			"platform":          sess.Platform,
+			"app_version":       sess.AppVersion,
+			"device_class":      sess.Class,
+			"login_ip":          sess.LoginIP,
+			"last_seen_ip":      sess.LastSeenIP,
+			"user_agent":        sess.UserAgent,
+			"created_at":        sess.CreatedAt,
+			"last_refreshed_at": sess.LastRefreshedAt,
+			"current":           sess.Device == current,
+			"idle_timeout":      sess.IdleTimeout,
+		}
+		if !sess.ExpiresAt.IsZero() {
+			item["expires_at"] = sess.ExpiresAt
+		}
+		items = append(items, item)
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": items})
+}
+
+// RevokeSession 下线指定设备，并使其已签发的 access token 立即失效。
+func (u *UserAPI) RevokeSession(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	device := c.Param("device")
+	if err := u.service.RevokeSession(userID, device); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "revoke session failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+// RevokeOtherSessions 下线除当前设备外的所有会话。
+func (u *UserAPI) RevokeOtherSessions(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	revoked, err := u.service.RevokeOtherSessions(userID, c.GetString("device"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "revoke sessions failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "sessions revoked", "revoked": revoked})
+}
@@ -0,0 +1,74 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"redbook/api/v1/request"
+	"redbook/internal/metrics"
+	"redbook/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SendLoginCode 发送短信登录验证码；无论手机号是否注册都返回相同结果。
+func (u *UserAPI) SendLoginCode(c *gin.Context) {
+	var req request.SMSCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := u.service.SendLoginCode(req.Mobile, c.ClientIP()); err != nil {
+		respondOTPError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "code sent"})
+}
+
+// LoginBySMS 校验短信验证码并签发与密码登录相同的 token 对。
+func (u *UserAPI) LoginBySMS(c *gin.Context) {
+	var req request.SMSLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		metrics.IncLogin("bad_request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	access, refresh, err := u.service.LoginBySMS(req.Mobile, req.Code, clientInfo(c))
+	respondLogin(c, access, refresh, err)
+}
+
+// SendMobileVerifyCode 向当前用户绑定的手机号发送验证码。
+func (u *UserAPI) SendMobileVerifyCode(c *gin.Context) {
+	if err := u.service.SendMobileVerifyCode(c.GetUint("user_id"), c.ClientIP()); err != nil {
+		respondOTPError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "code sent"})
+}
+
+// VerifyMobile 校验验证码并标记手机号已验证。
+func (u *UserAPI) VerifyMobile(c *gin.Context) {
+	var req request.VerifyMobileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := u.service.VerifyMobile(c.GetUint("user_id"), req.Code); err != nil {
+		respondOTPError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "mobile verified"})
+}
+
+// respondOTPError 将验证码相关错误映射为 HTTP 状态码。
+func respondOTPError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrOTPTooFrequent), errors.Is(err, service.ErrOTPLimited):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrOTPInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrMobileVerified):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "sms service unavailable"})
+	}
+}
@@ -2,15 +2,17 @@ package v1
 
 import (
 	"errors"
+	"math"
 	"net/http"
 	"redbook/api/v1/request"
-	"redbook/config"
+	"redbook/internal/audit"
 	"redbook/internal/auth"
+	"redbook/internal/captcha"
 	"redbook/internal/metrics"
+	"redbook/middleware"
 	"redbook/model"
 	"redbook/service"
-	"strings"
-	"time"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -37,8 +39,14 @@ func (u *UserAPI) Register(c *gin.Context) {
 		Username: req.Username,
 		Password: req.Password,
 		Mobile:   req.Mobile,
-	})
+	}, clientInfo(c))
 	if err != nil {
+		if respondCaptcha(c, err) {
+			return
+		}
+		if respondPasswordPolicy(c, "password", err) {
+			return
+		}
 		if errors.Is(err, service.ErrUserExists) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "user already exists"})
 			return
@@ -57,8 +65,58 @@ func (u *UserAPI) Login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	device := c.GetHeader("X-Device")
-	access, refresh, err := u.service.Login(req.Username, req.Password, device)
+	access, refresh, err := u.service.Login(req.Username, req.Password, clientInfo(c))
+	respondLogin(c, access, refresh, err)
+}
+
+// respondThrottled answers a request blocked by the login lockout with 429 and
+// the time until the next attempt is allowed.
+func respondThrottled(c *gin.Context, throttled *service.LoginThrottledError) {
+	retry := int64(math.Ceil(throttled.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.FormatInt(retry, 10))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": throttled.Error(), "retry_after": retry})
+}
+
+// respondLogin writes the outcome of any login method: a token pair, a pending
+// two-factor or device verification, a CAPTCHA demand, a 429 while the
+// identifier is throttled, or a 401.
+func respondLogin(c *gin.Context, access, refresh string, err error) {
+	if respondCaptcha(c, err) {
+		metrics.IncLogin("captcha_required")
+		return
+	}
+	var stepUp *service.StepUpRequiredError
+	if errors.As(err, &stepUp) {
+		metrics.IncLogin("step_up_required")
+		c.JSON(http.StatusOK, gin.H{
+			"step_up_required": true,
+			"step_up_token":    stepUp.Token,
+			"expires_in":       stepUp.ExpiresIn,
+			"reasons":          stepUp.Reasons,
+		})
+		return
+	}
+	var mfa *service.MFARequiredError
+	if errors.As(err, &mfa) {
+		metrics.IncLogin("mfa_required")
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    mfa.Token,
+			"expires_in":   mfa.ExpiresIn,
+		})
+		return
+	}
+	var throttled *service.LoginThrottledError
+	if errors.As(err, &throttled) {
+		metrics.IncLogin("throttled")
+		respondThrottled(c, throttled)
+		return
+	}
+	if errors.Is(err, service.ErrSessionLimit) {
+		metrics.IncLogin("session_limit")
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": "session_limit"})
+		return
+	}
 	if err != nil {
 		metrics.IncLogin("unauthorized")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
@@ -79,11 +137,15 @@ func (u *UserAPI) RefreshToken(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	device := c.GetHeader("X-Device")
-	access, refresh, err := u.service.RotateRefreshToken(req.RefreshToken, device)
+	access, refresh, err := u.service.RotateRefreshToken(req.RefreshToken, clientInfo(c))
 	if err != nil {
+		if errors.Is(err, service.ErrRefreshReused) {
+			metrics.IncRefresh("reuse_detected")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
 		metrics.IncRefresh("unauthorized")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		middleware.AbortTokenError(c, err)
 		return
 	}
 	metrics.IncRefresh("success")
@@ -93,64 +155,61 @@ func (u *UserAPI) RefreshToken(c *gin.Context) {
 	})
 }
 
-// Logout 支持使用 Access Token 或 Refresh Token 注销
+// Logout 支持使用 Access Token 或 Refresh Token 注销：access 过期后客户端仍可用 refresh 注销当前设备。
 func (u *UserAPI) Logout(c *gin.Context) {
-	authHeader := c.GetHeader("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
+	tokenStr, _, ok := auth.AuthorizationToken(c.GetHeader("Authorization"))
+	if !ok {
 		metrics.IncLogout("bad_request")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "missing token"})
 		return
 	}
-	tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-
-	// 情况 1：Authorization 携带 Access Token，直接将其列入黑名单并删除对应 refresh。
-	claims, err := auth.ParseToken(tokenStr)
-	if err == nil {
-		// token 有效 —— treat as access token (可能是未过期的 access)
-		// 将 access 加入黑名单，TTL 用 access expire
-		if err := u.service.Session.AddBlackList(tokenStr,
-			time.Duration(config.GlobalConfig.JWT.AccessExpire)*time.Second); err != nil {
-			metrics.IncLogout("internal_error")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "blacklist failed"})
-			return
-		}
-		// 删除对应 refresh token 存储
-		_ = u.service.Session.DeleteRefreshToken(claims.UserID, claims.Device)
 
-		metrics.IncLogout("success")
-		c.JSON(http.StatusOK, gin.H{"message": "logout success"})
-		return
+	claims, err := u.service.Logout(tokenStr, clientInfo(c))
+	if claims != nil {
+		auditLogout(c, claims, err)
 	}
-
-	// 情况 2：token 不是 Access（或 access 已失效），改用宽松解析，把它视为 Refresh Token。
-	claims, err = auth.ParseTokenAllowExpired(tokenStr)
 	if err != nil {
 		metrics.IncLogout("invalid_token")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		middleware.AbortTokenError(c, err)
 		return
 	}
+	metrics.IncLogout("success")
+	c.JSON(http.StatusOK, gin.H{"message": "logout success"})
+}
 
-	// 验证此 refresh 与 Redis 中存的是否一致
-	stored, err := u.service.Session.GetRefreshToken(claims.UserID, claims.Device)
-	if err != nil || stored == "" || stored != tokenStr {
-		metrics.IncLogout("refresh_mismatch")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh invalid or expired"})
-		return
-	}
+// auditLogout records a logout of the token's device session.
+func auditLogout(c *gin.Context, claims *auth.Claims, err error) {
+	client := clientInfo(c)
+	client.Device = claims.Device
+	service.RecordAudit(model.AuditEvent{Event: audit.EventLogout, UserID: uint64(claims.UserID)}, client, err)
+}
 
-	// 把 refresh 加入黑名单（防止重放）
-	if err := u.service.Session.AddBlackList(tokenStr,
-		time.Duration(config.GlobalConfig.JWT.RefreshExpire)*time.Second); err != nil {
+// LogoutAll 递增 token 版本，使该用户所有设备上的 token 立即失效。
+func (u *UserAPI) LogoutAll(c *gin.Context) {
+	err := u.service.LogoutAll(c.GetUint("user_id"))
+	service.RecordAudit(model.AuditEvent{Event: audit.EventLogoutAll, UserID: uint64(c.GetUint("user_id"))}, sessionClient(c), err)
+	if err != nil {
 		metrics.IncLogout("internal_error")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "blacklist failed"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "logout all failed"})
 		return
 	}
+	metrics.IncLogout("success_all")
+	c.JSON(http.StatusOK, gin.H{"message": "logout success"})
+}
 
-	// 删除 Redis 中的 refresh 记录
-	if err := u.service.Session.DeleteRefreshToken(claims.UserID, claims.Device); err != nil {
-		// log 但仍视为成功
+// clientInfo collects the device header and connection metadata of the caller.
+func clientInfo(c *gin.Context) service.ClientInfo {
+	return service.ClientInfo{
+		Device:        c.GetHeader("X-Device"),
+		IP:            c.ClientIP(),
+		UserAgent:     c.Request.UserAgent(),
+		CaptchaID:     c.GetHeader(captcha.HeaderID),
+		CaptchaAnswer: c.GetHeader(captcha.HeaderAnswer),
+		CaptchaPassed: c.GetBool("captcha_passed"),
+		DPoPKey:       c.GetString("dpop_jkt"),
+		DeviceName:    c.GetHeader("X-Device-Name"),
+		Platform:      c.GetHeader("X-Platform"),
+		AppVersion:    c.GetHeader("X-App-Version"),
+		DeviceTrust:   c.GetHeader(HeaderDeviceTrust),
 	}
-
-	metrics.IncLogout("success")
-	c.JSON(http.StatusOK, gin.H{"message": "logout success"})
 }
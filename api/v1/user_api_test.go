@@ -0,0 +1,46 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"redbook/config"
+	"redbook/internal/auth"
+)
+
+func TestDeviceTypeHeaderCannotExtendLifetime(t *testing.T) {
+	users, db := newTestUserService(t)
+	createTestUser(t, db, "sage", "13800000073", "Secret#2024")
+	config.GlobalConfig.Session.Lifetimes = map[string]config.SessionLifetime{
+		"web":    {IdleTimeout: 1800, AbsoluteLifetime: 43200},
+		"mobile": {IdleTimeout: 2592000, AbsoluteLifetime: 7776000},
+	}
+
+	// 桌面浏览器自称 mobile，仍按 web 的有效期签发
+	w := serve(http.MethodPost, "/login", "/login", `{"username":"sage","password":"Secret#2024"}`, 0, http.Header{
+		"X-Device":      {"browser"},
+		"X-Device-Type": {"mobile"},
+		"User-Agent":    {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/126.0 Safari/537.36"},
+	}, NewUserAPI(users).Login)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := auth.ParseToken(body.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.IdleTimeout != 1800 || claims.MaxExpiry-claims.AuthTime != 43200 {
+		t.Fatalf("refresh token idle %d, lifetime %d; want the web policy", claims.IdleTimeout, claims.MaxExpiry-claims.AuthTime)
+	}
+	sess, err := users.Session.GetSession(claims.UserID, "browser")
+	if err != nil || sess.Class != "web" {
+		t.Fatalf("session class = %+v, %v", sess, err)
+	}
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,12 +20,20 @@ import (
 	v1 "redbook/api/v1"
 	"redbook/config"
 	"redbook/dao"
+	"redbook/internal/audit"
+	"redbook/internal/auth"
+	"redbook/internal/notify"
+	"redbook/internal/sms"
 	myvalidator "redbook/internal/validator"
 	"redbook/middleware"
 	"redbook/model"
 	"redbook/service"
+	"redbook/utils"
 )
 
+// shutdownTimeout 优雅退出时等待进行中请求（含 SSE 长连接）结束的最长时间。
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	// 初始化配置
 	configPath := os.Getenv("CONFIG_PATH")
@@ -28,7 +41,31 @@ func main() {
 		configPath = "../"
 	}
 	config.InitConfig(configPath)
-	config.InitRedis()
+
+	// 初始化会话存储：默认 Redis，可在 config.yaml 中切换为内存实现
+	var store auth.SessionStore
+	switch config.GlobalConfig.Session.Store {
+	case "memory":
+		store = auth.NewMemoryStore()
+	default:
+		config.InitRedis()
+		store = auth.NewRedisStore(config.RedisClient)
+	}
+
+	// 初始化 JWT 签名 key 并启动定时轮换
+	keys, err := auth.InitKeys()
+	if err != nil {
+		log.Fatalf("Init jwt keys failed: %v", err)
+	}
+	stopRotation := make(chan struct{})
+	keys.StartRotation(stopRotation)
+
+	// 初始化密码哈希方案
+	hasher, err := utils.NewPasswordHasher(config.GlobalConfig.Password)
+	if err != nil {
+		log.Fatalf("Init password hasher failed: %v", err)
+	}
+	utils.SetPasswordHasher(hasher)
 
 	// 初始化数据库
 	db, err := gorm.Open(mysql.Open(config.GlobalConfig.MySQL.DSN), &gorm.Config{})
@@ -37,17 +74,58 @@ func main() {
 	}
 
 	// 自动迁移
-	if err := db.AutoMigrate(&model.User{}); err != nil {
+	if err := db.AutoMigrate(&model.User{}, &model.RecoveryCode{}, &model.OAuthClient{}, &model.OAuthConsent{},
+		&model.Role{}, &model.Permission{}, &model.UserRole{}, &model.PersonalAccessToken{}, &model.AuditEvent{}, &model.KnownDevice{}); err != nil {
 		panic(err)
 	}
 
+	// 审计事件异步批量写入 MySQL
+	auditDAO := dao.NewAuditDAO(db)
+	stopAudit := audit.Start(auditDAO, config.GlobalConfig.Audit.Buffer)
+
+	// 初始化短信通道
+	smsProvider, err := sms.NewProvider(config.GlobalConfig.SMS)
+	if err != nil {
+		log.Fatalf("Init sms provider failed: %v", err)
+	}
+
 	// 初始化 DAO 和 Service
 	userDAO := dao.NewUserDAO(db)
-	userService := service.NewUserService(userDAO, config.RedisClient) // 传递 RedisClient
+	userService := service.NewUserService(userDAO, store, smsProvider) // 传递会话存储与短信通道
+	if path := config.GlobalConfig.Password.Policy.BreachedFile; path != "" {
+		breached, err := myvalidator.LoadBreachedSet(path)
+		if err != nil {
+			log.Fatalf("Load breached password list failed: %v", err)
+		}
+		userService.Passwords.Breached = breached
+		log.Printf("loaded %d breached password hashes", breached.Len())
+	}
+	notifier, err := notify.NewNotifier(config.GlobalConfig.LoginRisk, smsProvider)
+	if err != nil {
+		log.Fatalf("Init notifier failed: %v", err)
+	}
+	userService.Notifier = notifier
 	userAPI := v1.NewUserAPI(userService)
+	oauthService := service.NewOAuthService(dao.NewOAuthDAO(db), userService)
+	if err := oauthService.SeedClients(config.GlobalConfig.OAuth.Clients); err != nil {
+		log.Fatalf("Seed oauth clients failed: %v", err)
+	}
+	oauthAPI := v1.NewOAuthAPI(oauthService, userService)
+	rbacService := service.NewRBACService(dao.NewRoleDAO(db), userDAO, store)
+	if err := rbacService.SeedRoles(config.GlobalConfig.RBAC.Roles); err != nil {
+		log.Fatalf("Seed roles failed: %v", err)
+	}
+	rbacAPI := v1.NewRBACAPI(rbacService)
+	accessTokenService := service.NewAccessTokenService(dao.NewAccessTokenDAO(db), store, userService)
+	accessTokenAPI := v1.NewAccessTokenAPI(accessTokenService)
+	auditAPI := v1.NewAuditAPI(service.NewAuditService(auditDAO))
 	// 初始化路由
-	r := gin.Default()
+	r, err := middleware.NewEngine(config.GlobalConfig.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	r.GET("/.well-known/jwks.json", v1.JWKS)
 
 	// 注册自定义校验器
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
@@ -59,21 +137,111 @@ func main() {
 	// 公共路由
 	public := r.Group("/api/v1")
 	{
-		public.POST("/users/register", userAPI.Register)
-		loginLimiter := middleware.LoginRateLimiter(config.RedisClient, 100, time.Minute)
-		public.POST("/users/login", loginLimiter, userAPI.Login)
-		public.POST("/users/refresh", userAPI.RefreshToken)
+		// 限流策略见 config.yaml 的 rate_limits
+		loginLimiter := middleware.RateLimit(store, "login")
+		// 签发 token 的端点校验可选的 DPoP 证明，并把 token 绑定到证明公钥
+		dpop := middleware.DPoP(userService.DPoP)
+		public.GET("/captcha", middleware.RateLimit(store, "captcha"), userAPI.NewCaptcha)
+		public.POST("/users/register", middleware.RateLimit(store, "register"), userAPI.Register)
+		public.POST("/users/login", loginLimiter, dpop, userAPI.Login)
+		public.POST("/users/refresh", middleware.RateLimit(store, "refresh"), dpop, userAPI.RefreshToken)
+		// 注销接受 access 或 refresh，自行校验 token，不经过 AuthMiddleware
+		public.POST("/users/logout", middleware.RateLimit(store, "refresh"), dpop, userAPI.Logout)
+		public.POST("/users/sms/code", loginLimiter, userAPI.SendLoginCode)
+		public.POST("/users/login/sms", loginLimiter, dpop, userAPI.LoginBySMS)
+		public.POST("/users/login/2fa", loginLimiter, userAPI.LoginMFA)
+		public.POST("/users/login/verify-device", loginLimiter, userAPI.LoginStepUp)
+		// 扫码登录：网页端生成票据并轮询（或 SSE 订阅）状态
+		public.POST("/users/qr-login", loginLimiter, dpop, userAPI.CreateQRLogin)
+		qrPollLimiter := middleware.RateLimit(store, "qr_poll")
+		public.GET("/users/qr-login/:ticket", qrPollLimiter, userAPI.PollQRLogin)
+		public.GET("/users/qr-login/:ticket/events", qrPollLimiter, userAPI.QRLoginEvents)
+		// 找回密码：发送 / 校验验证码与设置新密码共享一组更严格的限流
+		resetLimiter := middleware.RateLimit(store, "password_reset")
+		public.POST("/users/password/reset/code", resetLimiter, userAPI.SendResetCode)
+		public.POST("/users/password/reset/verify", resetLimiter, userAPI.VerifyResetCode)
+		public.POST("/users/password/reset", resetLimiter, userAPI.ResetPassword)
 	}
 
-	// 私有路由
+	// 私有路由（仅限用户直接登录获得的 token，OAuth token 与个人访问 token 一律拒绝）
+	authMiddleware := middleware.AuthMiddleware(userService, accessTokenService, userService.DPoP)
 	private := r.Group("/api/v1")
-	private.Use(middleware.AuthMiddleware(userService.Session))
+	private.Use(authMiddleware, middleware.FirstPartyOnly())
+	{
+		private.POST("/users/logout-all", userAPI.LogoutAll)
+		private.POST("/users/password", userAPI.ChangePassword)
+		private.POST("/users/mobile/code", userAPI.SendMobileVerifyCode)
+		private.POST("/users/mobile/verify", userAPI.VerifyMobile)
+		private.POST("/users/2fa/enroll", userAPI.EnrollTOTP)
+		private.POST("/users/2fa/confirm", userAPI.ConfirmTOTP)
+		private.POST("/users/2fa/disable", userAPI.DisableTOTP)
+		private.POST("/users/sessions/revoke-others", userAPI.RevokeOtherSessions)
+		private.DELETE("/users/sessions/:device", userAPI.RevokeSession)
+		private.POST("/users/devices/:device/trust", userAPI.TrustDevice)
+		private.DELETE("/users/devices/:device/trust", userAPI.UntrustDevice)
+		private.DELETE("/users/devices/:device", userAPI.ForgetDevice)
+		private.POST("/users/qr-login/:ticket/scan", userAPI.ScanQRLogin)
+		private.POST("/users/qr-login/:ticket/confirm", userAPI.ConfirmQRLogin)
+		private.POST("/users/qr-login/:ticket/reject", userAPI.RejectQRLogin)
+		private.POST("/users/tokens", accessTokenAPI.Create)
+		private.GET("/users/tokens", accessTokenAPI.List)
+		private.DELETE("/users/tokens/:id", accessTokenAPI.Revoke)
+		private.POST("/oauth/clients", oauthAPI.RegisterClient)
+		private.GET("/oauth/consents", oauthAPI.ListConsents)
+		private.DELETE("/oauth/consents/:client_id", oauthAPI.RevokeConsent)
+	}
+
+	// 管理接口，按角色权限授权
+	admin := r.Group("/api/v1/admin")
+	admin.Use(authMiddleware, middleware.FirstPartyOnly())
 	{
-		private.POST("/users/logout", userAPI.Logout)
+		admin.POST("/users/:id/unlock", middleware.RequirePermission(rbacService, "users:unlock"), userAPI.UnlockUser)
+		manageRoles := middleware.RequirePermission(rbacService, "roles:manage")
+		admin.GET("/roles", manageRoles, rbacAPI.ListRoles)
+		admin.GET("/users/:id/roles", manageRoles, rbacAPI.UserRoles)
+		admin.POST("/users/:id/roles", manageRoles, rbacAPI.GrantRole)
+		admin.DELETE("/users/:id/roles/:role", manageRoles, rbacAPI.RevokeRole)
+		admin.GET("/audit", middleware.RequirePermission(rbacService, "audit:read"), auditAPI.Search)
 	}
 
-	// 启动服务
-	if err := r.Run(config.GlobalConfig.Server.Port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	// OAuth 2.0 授权服务器：authorize 需要用户本人登录，token 端点使用客户端认证
+	oauth := r.Group("/oauth")
+	{
+		oauth.GET("/authorize", authMiddleware, middleware.FirstPartyOnly(), oauthAPI.Authorize)
+		oauth.POST("/authorize", authMiddleware, middleware.FirstPartyOnly(), oauthAPI.Authorize)
+		oauth.POST("/token", middleware.DPoP(userService.DPoP), oauthAPI.Token)
+		oauth.POST("/introspect", oauthAPI.Introspect)
+		oauth.POST("/revoke", oauthAPI.Revoke)
+	}
+
+	// 供第三方应用与个人访问 token 调用的只读资源接口，逐个路由按 scope 授权；用户直接登录的 token 不受 scope 限制
+	delegated := r.Group("/api/v1")
+	delegated.Use(authMiddleware)
+	{
+		delegated.GET("/oauth/userinfo", middleware.RequireScope("profile"), oauthAPI.UserInfo)
+		delegated.GET("/users/sessions", middleware.RequireScope("account:read"), userAPI.ListSessions)
+		delegated.GET("/users/devices", middleware.RequireScope("account:read"), userAPI.ListDevices)
+		delegated.GET("/users/audit", middleware.RequireScope("account:read"), auditAPI.MyEvents)
+	}
+
+	// 启动服务；收到 SIGINT / SIGTERM 后不再接受新连接，等进行中的请求结束后再写完审计队列
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: config.GlobalConfig.Server.Port, Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("Shutting down server...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown: %v", err)
 	}
+	close(stopRotation)
+	stopAudit()
 }
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/go-redis/redis/v8"
 	"gopkg.in/yaml.v3"
@@ -25,6 +26,224 @@ type JWTConfig struct {
 	Secret        string `yaml:"secret"`
 	AccessExpire  int64  `yaml:"access_expire"`
 	RefreshExpire int64  `yaml:"refresh_expire"`
+	// Algorithm 取值 HS256 / RS256 / EdDSA，留空时沿用 HS256 + Secret。
+	Algorithm string `yaml:"algorithm"`
+	// KeyDir 存放非对称私钥（<kid>.pem），多副本需共享同一目录。
+	KeyDir string `yaml:"key_dir"`
+	// EphemeralKeys 允许在未配置 KeyDir 时只在内存中生成私钥，仅用于本地开发：
+	// 每次重启或新增副本都会让已签发的 token 全部失效。
+	EphemeralKeys bool `yaml:"ephemeral_keys"`
+	// RotateInterval 签名 key 的轮换周期（秒），旧 key 在轮换后仍可验签直到其签发的 token 全部过期。
+	RotateInterval int64 `yaml:"rotate_interval"`
+}
+
+// DPoPConfig 控制 RFC 9449 DPoP 持有证明。客户端在登录 / 刷新时携带 DPoP 头，
+// 签发的 token 绑定其公钥指纹（cnf.jkt），此后每次使用都需附带新的签名证明。
+type DPoPConfig struct {
+	// Enabled 为 false 时登录忽略 DPoP 头，不再签发新的绑定 token；已绑定的 token 仍需证明
+	Enabled bool `yaml:"enabled"`
+	// Required 为 true 时 token 端点拒绝未携带 DPoP 证明的请求
+	Required bool `yaml:"required"`
+	// ProofMaxAge 证明 iat 与服务端时间允许的最大偏差（秒）
+	ProofMaxAge int64 `yaml:"proof_max_age"`
+	// RequireNonce 为 true 时证明必须携带服务端下发的 DPoP-Nonce
+	RequireNonce bool  `yaml:"require_nonce"`
+	NonceTTL     int64 `yaml:"nonce_ttl"`
+}
+
+// SessionConfig 选择会话 / 黑名单 / 限流计数的存储后端。
+type SessionConfig struct {
+	// Store 取值 redis（默认）或 memory；memory 仅适用于单实例与测试。
+	Store string `yaml:"store"`
+	// Limits 每个账号各设备类型（mobile / web / tablet）最多同时在线的会话数，缺省或 0 表示不限
+	Limits map[string]int `yaml:"limits"`
+	// LimitPolicy 达到上限时的处理：evict_oldest（默认，下线最久未使用的会话）/ reject（拒绝新登录）
+	LimitPolicy string `yaml:"limit_policy"`
+	// Lifetimes 各客户端类型（mobile / web / tablet / oauth，default 兜底）的会话有效期
+	Lifetimes map[string]SessionLifetime `yaml:"lifetimes"`
+}
+
+// SessionLifetime 会话有效期策略，单位秒。
+type SessionLifetime struct {
+	// IdleTimeout 两次刷新之间允许的最长空闲时间，即 refresh token 有效期；缺省取 jwt.refresh_expire
+	IdleTimeout int64 `yaml:"idle_timeout"`
+	// AbsoluteLifetime 自登录起会话最长存活时间，到期后即使仍在活跃也必须重新登录
+	AbsoluteLifetime int64 `yaml:"absolute_lifetime"`
+}
+
+// 会话数达到上限时的处理方式
+const (
+	SessionLimitEvictOldest = "evict_oldest"
+	SessionLimitReject      = "reject"
+)
+
+// SMSConfig 控制短信验证码的发送通道、有效期与发送频率。
+type SMSConfig struct {
+	Provider string `yaml:"provider"` // log（默认，写日志/文件的本地假通道）
+	LogFile  string `yaml:"log_file"`
+	// CodeTTL 验证码有效期（秒）
+	CodeTTL int64 `yaml:"code_ttl"`
+	// SendInterval 同一手机号两次发送的最小间隔（秒）
+	SendInterval     int64 `yaml:"send_interval"`
+	MobileDailyLimit int64 `yaml:"mobile_daily_limit"`
+	IPHourlyLimit    int64 `yaml:"ip_hourly_limit"`
+	// MaxAttempts 单个验证码允许的最大校验次数，超过后作废
+	MaxAttempts int64 `yaml:"max_attempts"`
+}
+
+// MFAConfig 两步验证相关配置。
+type MFAConfig struct {
+	Issuer string `yaml:"issuer"` // otpauth URI 中展示的发行方名称
+	// ChallengeTTL 登录后等待提交 TOTP / 恢复码的有效期（秒）
+	ChallengeTTL int64 `yaml:"challenge_ttl"`
+}
+
+// OAuthClientConfig 在配置中声明的客户端（通常是自家的一方应用），启动时写入数据库。
+type OAuthClientConfig struct {
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"` // 为空表示公开客户端
+	Name         string   `yaml:"name"`
+	RedirectURIs []string `yaml:"redirect_uris"`
+	Scopes       []string `yaml:"scopes"`
+	FirstParty   bool     `yaml:"first_party"` // 一方应用免授权确认
+}
+
+// OAuthConfig 授权服务器配置。
+type OAuthConfig struct {
+	// CodeTTL 授权码有效期（秒）
+	CodeTTL int64 `yaml:"code_ttl"`
+	// Scopes 服务端支持的全部 scope
+	Scopes  []string            `yaml:"scopes"`
+	Clients []OAuthClientConfig `yaml:"clients"`
+}
+
+// LockoutConfig 按账号标识（用户名 / 手机号）统计登录失败，逐次增加等待并在达到阈值后临时锁定。
+type LockoutConfig struct {
+	// Threshold 窗口内累计失败达到该次数后锁定
+	Threshold int64 `yaml:"threshold"`
+	// Window 失败计数窗口（秒）
+	Window int64 `yaml:"window"`
+	// BaseDelay 首次失败后需等待的秒数，此后每次失败翻倍，不超过 MaxDelay
+	BaseDelay    int64 `yaml:"base_delay"`
+	MaxDelay     int64 `yaml:"max_delay"`
+	LockDuration int64 `yaml:"lock_duration"` // 锁定时长（秒）
+}
+
+// AccessTokenConfig 个人访问 token（供脚本与集成使用）的有效期与数量限制。
+type AccessTokenConfig struct {
+	DefaultTTL int64 `yaml:"default_ttl"` // 未指定有效期时使用（天）
+	MaxTTL     int64 `yaml:"max_ttl"`     // 允许的最长有效期（天）
+	MaxPerUser int64 `yaml:"max_per_user"`
+}
+
+// LoginRiskConfig 新设备 / 陌生网络登录的识别与处置。
+type LoginRiskConfig struct {
+	Notifier string `yaml:"notifier"` // log（默认）/ sms
+	// StepUp 为 true 时，可疑的密码登录需先通过短信验证码确认（已开启两步验证的账号除外）
+	StepUp bool `yaml:"step_up"`
+	// NetworkTTL 登录过的网络段（IPv4 /24、IPv6 /48）保留天数
+	NetworkTTL int64 `yaml:"network_ttl"`
+}
+
+// QRLoginConfig 扫码登录：网页端生成登录票据，已登录的移动端扫码确认后网页端领取 token。
+type QRLoginConfig struct {
+	// TicketTTL 票据有效期（秒）
+	TicketTTL int64 `yaml:"ticket_ttl"`
+	// PollInterval SSE 推送时检查票据状态的间隔（毫秒）
+	PollInterval int64 `yaml:"poll_interval"`
+}
+
+// CaptchaConfig 图形验证码。IP 或账号在窗口内的失败 / 注册次数达到阈值后，登录与注册需先通过验证码。
+type CaptchaConfig struct {
+	Kind string `yaml:"kind"` // digits（默认）/ math
+	TTL  int64  `yaml:"ttl"`  // 验证码有效期（秒）
+	// Window 风险计数窗口（秒）
+	Window           int64 `yaml:"window"`
+	IPThreshold      int64 `yaml:"ip_threshold"`
+	AccountThreshold int64 `yaml:"account_threshold"`
+}
+
+// AuditConfig 审计日志异步写入配置。
+type AuditConfig struct {
+	// Buffer 待写入队列长度，队列满时丢弃新事件并计入 redbook_audit_events_dropped_total
+	Buffer int `yaml:"buffer"`
+}
+
+// AdminConfig 引导用的管理员名单（用户 ID），名单中的用户隐式拥有 admin 角色，用于授予首批角色。
+type AdminConfig struct {
+	UserIDs []uint64 `yaml:"user_ids"`
+}
+
+// RoleConfig 在配置中声明的内置角色，启动时写入数据库并覆盖其权限。
+type RoleConfig struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Permissions []string `yaml:"permissions"`
+}
+
+// RBACConfig 角色与权限配置。
+type RBACConfig struct {
+	// DefaultRole 所有登录用户隐式拥有的角色
+	DefaultRole string       `yaml:"default_role"`
+	Roles       []RoleConfig `yaml:"roles"`
+}
+
+// 限流算法
+const (
+	RateLimitFixedWindow   = "fixed_window"
+	RateLimitSlidingWindow = "sliding_window"
+	RateLimitTokenBucket   = "token_bucket" // GCRA 实现
+)
+
+// RateLimitPolicy 描述一组接口的限流策略，在 rate_limits 中按名称声明。
+type RateLimitPolicy struct {
+	Algorithm string `yaml:"algorithm"` // fixed_window（默认）/ sliding_window / token_bucket
+	// Limit 每个 Window（秒）内允许的请求数；token_bucket 下为每个 Window 补充的令牌数，0 表示不限流
+	Limit  int64 `yaml:"limit"`
+	Window int64 `yaml:"window"`
+	// Burst token_bucket 的桶容量，默认等于 Limit
+	Burst int64 `yaml:"burst"`
+	// Key 限流维度，可组合 ip / user / device / route，默认 ip。user / device 取自已鉴权的 token，
+	// 鉴权前退回 IP；不支持按客户端请求头计数，未知维度启动时报错
+	Key []string `yaml:"key"`
+	// Captcha 为 true 时超限请求携带有效验证码即可放行，否则返回 429 并附带 captcha_required
+	Captcha bool `yaml:"captcha"`
+}
+
+// 密码哈希算法
+const (
+	PasswordArgon2id = "argon2id"
+	PasswordBcrypt   = "bcrypt"
+)
+
+// PasswordConfig 选择新密码使用的哈希方案。旧方案的哈希仍可校验，登录成功后按当前配置重新哈希。
+type PasswordConfig struct {
+	Algorithm  string       `yaml:"algorithm"`   // argon2id（默认）/ bcrypt
+	BcryptCost int          `yaml:"bcrypt_cost"` // 为 0 时使用 bcrypt.DefaultCost
+	Argon2     Argon2Config `yaml:"argon2"`
+	// Policy 注册、改密与找回密码时对新密码的强度要求
+	Policy PasswordPolicyConfig `yaml:"policy"`
+}
+
+// PasswordPolicyConfig 密码强度策略，未设置的长度与字符类别要求使用默认值（8~128 位、至少 3 类字符）。
+type PasswordPolicyConfig struct {
+	MinLength int `yaml:"min_length"`
+	MaxLength int `yaml:"max_length"`
+	// MinClasses 小写、大写、数字、符号四类中至少包含的类别数
+	MinClasses int `yaml:"min_classes"`
+	// AllowPersonalInfo 为 true 时允许密码包含用户名或手机号
+	AllowPersonalInfo bool `yaml:"allow_personal_info"`
+	// BreachedFile 本地泄露密码库，每行一个明文密码或 SHA-1（可带 :count），为空时不检查
+	BreachedFile string `yaml:"breached_file"`
+}
+
+// Argon2Config argon2id 参数，未设置的项使用 OWASP 推荐的基线值。
+type Argon2Config struct {
+	Memory      uint32 `yaml:"memory"` // KiB
+	Iterations  uint32 `yaml:"iterations"`
+	Parallelism uint8  `yaml:"parallelism"`
+	SaltLength  uint32 `yaml:"salt_length"`
+	KeyLength   uint32 `yaml:"key_length"`
 }
 
 type MySQLConfig struct {
@@ -33,13 +252,35 @@ type MySQLConfig struct {
 
 type ServerConfig struct {
 	Port string `yaml:"port"`
+	// TrustedProxies 为可信反向代理的 IP / CIDR，只有来自这些地址的请求才采信
+	// X-Forwarded-For / X-Real-IP；默认为空，即直接使用 TCP 对端地址
+	TrustedProxies []string `yaml:"trusted_proxies"`
 }
 
 type Config struct {
-	Server ServerConfig `yaml:"server"`
-	MySQL  MySQLConfig  `yaml:"mysql"`
-	Redis  RedisConfig  `yaml:"redis"`
-	JWT    JWTConfig    `yaml:"jwt"`
+	Server  ServerConfig  `yaml:"server"`
+	MySQL   MySQLConfig   `yaml:"mysql"`
+	Redis   RedisConfig   `yaml:"redis"`
+	JWT     JWTConfig     `yaml:"jwt"`
+	Session SessionConfig `yaml:"session"`
+	SMS     SMSConfig     `yaml:"sms"`
+	MFA     MFAConfig     `yaml:"mfa"`
+	OAuth   OAuthConfig   `yaml:"oauth"`
+	Lockout LockoutConfig `yaml:"lockout"`
+	Admin   AdminConfig   `yaml:"admin"`
+	RBAC    RBACConfig    `yaml:"rbac"`
+	Audit   AuditConfig   `yaml:"audit"`
+	Captcha CaptchaConfig `yaml:"captcha"`
+	DPoP    DPoPConfig    `yaml:"dpop"`
+	QRLogin QRLoginConfig `yaml:"qr_login"`
+	// LoginRisk 可疑登录检测
+	LoginRisk LoginRiskConfig `yaml:"login_risk"`
+	// AccessTokens 个人访问 token
+	AccessTokens AccessTokenConfig `yaml:"access_tokens"`
+	// Password 密码哈希方案
+	Password PasswordConfig `yaml:"password"`
+	// RateLimits 按策略名声明的限流规则，见 cmd/main.go 中各路由引用的名称
+	RateLimits map[string]RateLimitPolicy `yaml:"rate_limits"`
 }
 
 var GlobalConfig *Config
@@ -85,12 +326,29 @@ func applyEnvOverrides() {
 	if v := os.Getenv("REDIS_PASSWORD"); v != "" {
 		GlobalConfig.Redis.Password = v
 	}
+	if v := os.Getenv("SESSION_STORE"); v != "" {
+		GlobalConfig.Session.Store = v
+	}
 	if v := os.Getenv("SERVER_PORT"); v != "" {
 		GlobalConfig.Server.Port = v
 	}
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		GlobalConfig.Server.TrustedProxies = strings.Split(v, ",")
+	}
 	if v := os.Getenv("JWT_SECRET"); v != "" {
 		GlobalConfig.JWT.Secret = v
 	}
+	if v := os.Getenv("JWT_ALGORITHM"); v != "" {
+		GlobalConfig.JWT.Algorithm = v
+	}
+	if v := os.Getenv("JWT_KEY_DIR"); v != "" {
+		GlobalConfig.JWT.KeyDir = v
+	}
+	if v := os.Getenv("JWT_EPHEMERAL_KEYS"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			GlobalConfig.JWT.EphemeralKeys = parsed
+		}
+	}
 	if v := os.Getenv("JWT_ACCESS_EXPIRE"); v != "" {
 		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
 			GlobalConfig.JWT.AccessExpire = parsed
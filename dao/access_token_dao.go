@@ -0,0 +1,60 @@
+package dao
+
+import (
+	"redbook/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AccessTokenDAO struct {
+	db *gorm.DB
+}
+
+// NewAccessTokenDAO 创建一个新的 AccessTokenDAO 实例
+func NewAccessTokenDAO(db *gorm.DB) *AccessTokenDAO {
+	return &AccessTokenDAO{db: db}
+}
+
+// Create 保存新建的个人访问 token
+func (dao *AccessTokenDAO) Create(token *model.PersonalAccessToken) error {
+	return dao.db.Create(token).Error
+}
+
+// GetByHash 根据 token 哈希查询
+func (dao *AccessTokenDAO) GetByHash(hash string) (*model.PersonalAccessToken, error) {
+	var token model.PersonalAccessToken
+	err := dao.db.Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ListByUser 列出用户的全部 token
+func (dao *AccessTokenDAO) ListByUser(userID uint64) ([]model.PersonalAccessToken, error) {
+	var tokens []model.PersonalAccessToken
+	err := dao.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// CountByUser 统计用户尚未过期的 token 数
+func (dao *AccessTokenDAO) CountByUser(userID uint64, now time.Time) (int64, error) {
+	var n int64
+	err := dao.db.Model(&model.PersonalAccessToken{}).Where("user_id = ? AND expires_at > ?", userID, now).Count(&n).Error
+	return n, err
+}
+
+// Delete 吊销用户的某个 token，返回是否存在
+func (dao *AccessTokenDAO) Delete(userID, id uint64) (bool, error) {
+	res := dao.db.Where("user_id = ? AND id = ?", userID, id).Delete(&model.PersonalAccessToken{})
+	return res.RowsAffected > 0, res.Error
+}
+
+// TouchLastUsed 记录最近一次使用的时间与 IP
+func (dao *AccessTokenDAO) TouchLastUsed(id uint64, at time.Time, ip string) error {
+	return dao.db.Model(&model.PersonalAccessToken{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"last_used_at": at,
+		"last_used_ip": ip,
+	}).Error
+}
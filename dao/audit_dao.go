@@ -0,0 +1,63 @@
+package dao
+
+import (
+	"redbook/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AuditDAO struct {
+	db *gorm.DB
+}
+
+// NewAuditDAO 创建一个新的 AuditDAO 实例
+func NewAuditDAO(db *gorm.DB) *AuditDAO {
+	return &AuditDAO{db: db}
+}
+
+// AuditFilter 审计事件查询条件，零值字段不参与过滤
+type AuditFilter struct {
+	UserID uint64
+	Event  string
+	IP     string
+	Result string
+	Since  time.Time
+	Until  time.Time
+}
+
+// WriteAuditEvents 批量追加审计事件
+func (dao *AuditDAO) WriteAuditEvents(events []model.AuditEvent) error {
+	return dao.db.CreateInBatches(events, len(events)).Error
+}
+
+// QueryEvents 按条件分页查询审计事件（按时间倒序），并返回总数
+func (dao *AuditDAO) QueryEvents(f AuditFilter, offset, limit int) ([]model.AuditEvent, int64, error) {
+	q := dao.db.Model(&model.AuditEvent{})
+	if f.UserID != 0 {
+		q = q.Where("user_id = ?", f.UserID)
+	}
+	if f.Event != "" {
+		q = q.Where("event = ?", f.Event)
+	}
+	if f.IP != "" {
+		q = q.Where("ip = ?", f.IP)
+	}
+	if f.Result != "" {
+		q = q.Where("result = ?", f.Result)
+	}
+	if !f.Since.IsZero() {
+		q = q.Where("created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		q = q.Where("created_at < ?", f.Until)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var events []model.AuditEvent
+	err := q.Order("created_at DESC, id DESC").Offset(offset).Limit(limit).Find(&events).Error
+	return events, total, err
+}
@@ -0,0 +1,61 @@
+package dao
+
+import (
+	"redbook/model"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// GetKnownDevice 查询用户的某个已知设备
+func (dao *UserDAO) GetKnownDevice(userID uint64, device string) (*model.KnownDevice, error) {
+	var d model.KnownDevice
+	err := dao.db.Where("user_id = ? AND device = ?", userID, device).First(&d).Error
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// CountKnownDevices 统计用户的已知设备数
+func (dao *UserDAO) CountKnownDevices(userID uint64) (int64, error) {
+	var n int64
+	err := dao.db.Model(&model.KnownDevice{}).Where("user_id = ?", userID).Count(&n).Error
+	return n, err
+}
+
+// ListKnownDevices 列出用户的已知设备，最近使用的在前
+func (dao *UserDAO) ListKnownDevices(userID uint64) ([]model.KnownDevice, error) {
+	var devices []model.KnownDevice
+	err := dao.db.Where("user_id = ?", userID).Order("last_seen_at DESC").Find(&devices).Error
+	return devices, err
+}
+
+// SeeKnownDevice 记录一次成功登录：新设备插入，已知设备更新最近 IP 与时间
+func (dao *UserDAO) SeeKnownDevice(userID uint64, device, ip, userAgent string, at time.Time) error {
+	return dao.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "device"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_ip", "user_agent", "last_seen_at"}),
+	}).Create(&model.KnownDevice{
+		UserID:      userID,
+		Device:      device,
+		LastIP:      ip,
+		UserAgent:   userAgent,
+		FirstSeenAt: at,
+		LastSeenAt:  at,
+	}).Error
+}
+
+// SetDeviceTrust 保存设备信任凭证的哈希，空哈希表示取消可信，返回设备是否存在
+func (dao *UserDAO) SetDeviceTrust(userID uint64, device, trustHash string) (bool, error) {
+	res := dao.db.Model(&model.KnownDevice{}).
+		Where("user_id = ? AND device = ?", userID, device).
+		Updates(map[string]any{"trusted": trustHash != "", "trust_hash": trustHash})
+	return res.RowsAffected > 0, res.Error
+}
+
+// DeleteKnownDevice 忘记设备，下次从该设备登录会重新视为新设备
+func (dao *UserDAO) DeleteKnownDevice(userID uint64, device string) (bool, error) {
+	res := dao.db.Where("user_id = ? AND device = ?", userID, device).Delete(&model.KnownDevice{})
+	return res.RowsAffected > 0, res.Error
+}
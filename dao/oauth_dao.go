@@ -0,0 +1,71 @@
+package dao
+
+import (
+	"redbook/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type OAuthDAO struct {
+	db *gorm.DB
+}
+
+// NewOAuthDAO 创建一个新的 OAuthDAO 实例
+func NewOAuthDAO(db *gorm.DB) *OAuthDAO {
+	return &OAuthDAO{db: db}
+}
+
+// CreateClient 注册新的 OAuth 客户端
+func (dao *OAuthDAO) CreateClient(client *model.OAuthClient) error {
+	return dao.db.Create(client).Error
+}
+
+// UpsertClient 按 client_id 写入配置中声明的客户端
+func (dao *OAuthDAO) UpsertClient(client *model.OAuthClient) error {
+	return dao.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"secret_hash", "name", "redirect_uris", "scopes", "first_party", "updated_at"}),
+	}).Create(client).Error
+}
+
+// GetClient 根据 client_id 查询客户端
+func (dao *OAuthDAO) GetClient(clientID string) (*model.OAuthClient, error) {
+	var client model.OAuthClient
+	err := dao.db.Where("client_id = ?", clientID).First(&client).Error
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// GetConsent 查询用户对某客户端的授权记录
+func (dao *OAuthDAO) GetConsent(userID uint64, clientID string) (*model.OAuthConsent, error) {
+	var consent model.OAuthConsent
+	err := dao.db.Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent).Error
+	if err != nil {
+		return nil, err
+	}
+	return &consent, nil
+}
+
+// SaveConsent 新增或更新授权记录
+func (dao *OAuthDAO) SaveConsent(consent *model.OAuthConsent) error {
+	return dao.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scopes", "updated_at"}),
+	}).Create(consent).Error
+}
+
+// ListConsents 列出用户授权过的全部客户端
+func (dao *OAuthDAO) ListConsents(userID uint64) ([]model.OAuthConsent, error) {
+	var consents []model.OAuthConsent
+	err := dao.db.Where("user_id = ?", userID).Order("updated_at DESC").Find(&consents).Error
+	return consents, err
+}
+
+// DeleteConsent 撤销用户对某客户端的授权
+func (dao *OAuthDAO) DeleteConsent(userID uint64, clientID string) (bool, error) {
+	res := dao.db.Where("user_id = ? AND client_id = ?", userID, clientID).Delete(&model.OAuthConsent{})
+	return res.RowsAffected > 0, res.Error
+}
@@ -0,0 +1,94 @@
+package dao
+
+import (
+	"redbook/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RoleDAO struct {
+	db *gorm.DB
+}
+
+// NewRoleDAO 创建一个新的 RoleDAO 实例
+func NewRoleDAO(db *gorm.DB) *RoleDAO {
+	return &RoleDAO{db: db}
+}
+
+// UpsertRole 按名称写入角色，并把其权限替换为 permissions
+func (dao *RoleDAO) UpsertRole(name, description string, permissions []string) error {
+	return dao.db.Transaction(func(tx *gorm.DB) error {
+		role := model.Role{Name: name}
+		if err := tx.Where("name = ?", name).FirstOrCreate(&role).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&role).Update("description", description).Error; err != nil {
+			return err
+		}
+		perms := make([]model.Permission, 0, len(permissions))
+		for _, p := range permissions {
+			perm := model.Permission{Name: p}
+			if err := tx.Where("name = ?", p).FirstOrCreate(&perm).Error; err != nil {
+				return err
+			}
+			perms = append(perms, perm)
+		}
+		return tx.Model(&role).Association("Permissions").Replace(perms)
+	})
+}
+
+// ListRoles 列出全部角色及其权限
+func (dao *RoleDAO) ListRoles() ([]model.Role, error) {
+	var roles []model.Role
+	err := dao.db.Preload("Permissions").Order("id").Find(&roles).Error
+	return roles, err
+}
+
+// GetRole 根据名称查询角色
+func (dao *RoleDAO) GetRole(name string) (*model.Role, error) {
+	var role model.Role
+	err := dao.db.Where("name = ?", name).First(&role).Error
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// UserRoleNames 查询授予用户的角色名
+func (dao *RoleDAO) UserRoleNames(userID uint64) ([]string, error) {
+	var names []string
+	err := dao.db.Model(&model.Role{}).
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.id").
+		Pluck("roles.name", &names).Error
+	return names, err
+}
+
+// RolePermissions 查询一组角色拥有的权限（去重）
+func (dao *RoleDAO) RolePermissions(roles []string) ([]string, error) {
+	var perms []string
+	if len(roles) == 0 {
+		return perms, nil
+	}
+	err := dao.db.Model(&model.Permission{}).
+		Distinct("permissions.name").
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Joins("JOIN roles ON roles.id = role_permissions.role_id").
+		Where("roles.name IN ?", roles).
+		Pluck("permissions.name", &perms).Error
+	return perms, err
+}
+
+// GrantRole 授予用户角色，重复授予不报错
+func (dao *RoleDAO) GrantRole(userID, roleID, grantedBy uint64) error {
+	return dao.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&model.UserRole{UserID: userID, RoleID: roleID, GrantedBy: grantedBy}).Error
+}
+
+// RevokeRole 收回用户角色，返回是否确有该授权
+func (dao *RoleDAO) RevokeRole(userID, roleID uint64) (bool, error) {
+	res := dao.db.Where("user_id = ? AND role_id = ?", userID, roleID).Delete(&model.UserRole{})
+	return res.RowsAffected > 0, res.Error
+}
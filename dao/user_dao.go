@@ -2,6 +2,7 @@ package dao
 
 import (
 	"redbook/model"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -39,3 +40,94 @@ func (dao *UserDAO) GetByUsername(username string) (*model.User, error) {
 	}
 	return &user, nil
 }
+
+// GetByID 根据主键获取用户
+func (dao *UserDAO) GetByID(id uint64) (*model.User, error) {
+	var user model.User
+	err := dao.db.First(&user, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// MarkMobileVerified 标记用户手机号已验证
+func (dao *UserDAO) MarkMobileVerified(id uint64) error {
+	return dao.db.Model(&model.User{}).Where("id = ?", id).Update("mobile_verified", true).Error
+}
+
+// UpdatePassword 更新用户密码哈希
+func (dao *UserDAO) UpdatePassword(id uint64, hash string) error {
+	return dao.db.Model(&model.User{}).Where("id = ?", id).Update("password", hash).Error
+}
+
+// GetTokenVersion 读取用户当前的 token 版本
+func (dao *UserDAO) GetTokenVersion(id uint64) (int64, error) {
+	var user model.User
+	err := dao.db.Select("token_version").First(&user, id).Error
+	if err != nil {
+		return 0, err
+	}
+	return user.TokenVersion, nil
+}
+
+// IncrTokenVersion 原子递增 token 版本并返回新值
+func (dao *UserDAO) IncrTokenVersion(id uint64) (int64, error) {
+	err := dao.db.Model(&model.User{}).
+		Where("id = ?", id).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error
+	if err != nil {
+		return 0, err
+	}
+	return dao.GetTokenVersion(id)
+}
+
+// DeleteAccessTokens 吊销用户的全部个人访问 token，返回删除的数量
+func (dao *UserDAO) DeleteAccessTokens(id uint64) (int64, error) {
+	res := dao.db.Where("user_id = ?", id).Delete(&model.PersonalAccessToken{})
+	return res.RowsAffected, res.Error
+}
+
+// EnableTOTP 保存已确认的 TOTP 密钥并替换全部恢复码
+func (dao *UserDAO) EnableTOTP(id uint64, secret string, codeHashes []string) error {
+	return dao.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"totp_secret":  secret,
+			"totp_enabled": true,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]model.RecoveryCode, 0, len(codeHashes))
+		for _, h := range codeHashes {
+			codes = append(codes, model.RecoveryCode{UserID: id, CodeHash: h})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// DisableTOTP 关闭两步验证并清理恢复码
+func (dao *UserDAO) DisableTOTP(id uint64) error {
+	return dao.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"totp_secret":  "",
+			"totp_enabled": false,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", id).Delete(&model.RecoveryCode{}).Error
+	})
+}
+
+// UseRecoveryCode 原子地消费一个未使用的恢复码，返回是否命中
+func (dao *UserDAO) UseRecoveryCode(id uint64, codeHash string) (bool, error) {
+	res := dao.db.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", id, codeHash).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"redbook/internal/metrics"
+	"redbook/model"
+)
+
+// 事件类型
+const (
+	EventLoginPassword = "login_password"
+	EventLoginSMS      = "login_sms"
+	EventLoginMFA      = "login_mfa"
+	EventLoginStepUp   = "login_step_up"
+	EventLoginQR       = "login_qr"
+	// EventSuspiciousLogin 新设备或陌生网络登录
+	EventSuspiciousLogin = "suspicious_login"
+	EventRefresh         = "refresh"
+	EventLogout          = "logout"
+	EventLogoutAll       = "logout_all"
+	// EventSessionEvicted 设备数达到上限，最久未使用的会话被新登录挤下线
+	EventSessionEvicted = "session_evicted"
+	EventLockout        = "lockout"
+	EventUnlock         = "unlock"
+	EventRateLimit      = "rate_limit"
+	EventPasswordChange = "password_change"
+	EventPasswordReset  = "password_reset"
+	EventRoleGrant      = "role_grant"
+	EventRoleRevoke     = "role_revoke"
+	EventTokenCreate    = "access_token_create"
+	EventTokenRevoke    = "access_token_revoke"
+)
+
+// 事件结果
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	// ResultPending 第一因素通过、等待两步验证
+	ResultPending = "pending"
+)
+
+const (
+	batchSize     = 100
+	flushInterval = time.Second
+)
+
+// Sink persists a batch of audit events.
+type Sink interface {
+	WriteAuditEvents(events []model.AuditEvent) error
+}
+
+var (
+	mu    sync.RWMutex
+	queue chan model.AuditEvent
+	done  chan struct{}
+)
+
+// Start begins writing recorded events to sink from a background goroutine and
+// returns a function that flushes the queue and stops the writer. Until Start
+// is called, Record discards events.
+func Start(sink Sink, buffer int) (stop func()) {
+	if buffer <= 0 {
+		buffer = 1024
+	}
+	q := make(chan model.AuditEvent, buffer)
+	d := make(chan struct{})
+	mu.Lock()
+	queue, done = q, d
+	mu.Unlock()
+
+	go run(sink, q, d)
+	return func() {
+		mu.Lock()
+		if queue == q {
+			queue = nil
+		}
+		mu.Unlock()
+		close(q)
+		<-d
+	}
+}
+
+// Record queues an event without blocking. When the queue is full the event is
+// dropped and counted, so a slow database never stalls a login.
+func Record(e model.AuditEvent) {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if queue == nil {
+		return
+	}
+	select {
+	case queue <- e:
+	default:
+		metrics.IncAuditDropped()
+	}
+}
+
+func run(sink Sink, q <-chan model.AuditEvent, d chan<- struct{}) {
+	defer close(d)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]model.AuditEvent, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := sink.WriteAuditEvents(batch); err != nil {
+			log.Printf("write %d audit events failed: %v", len(batch), err)
+		}
+		batch = make([]model.AuditEvent, 0, batchSize)
+	}
+	for {
+		select {
+		case e, ok := <-q:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
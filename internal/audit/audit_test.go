@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"sync"
+	"testing"
+
+	"redbook/model"
+)
+
+type memorySink struct {
+	mu      sync.Mutex
+	batches [][]model.AuditEvent
+}
+
+func (m *memorySink) WriteAuditEvents(events []model.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches = append(m.batches, events)
+	return nil
+}
+
+func TestRecordWritesAsynchronously(t *testing.T) {
+	// 未启动时丢弃事件且不阻塞
+	Record(model.AuditEvent{Event: EventLoginPassword})
+
+	sink := &memorySink{}
+	stop := Start(sink, 1000)
+	for i := 0; i < batchSize+5; i++ {
+		Record(model.AuditEvent{UserID: uint64(i), Event: EventLoginPassword, Result: ResultSuccess})
+	}
+	stop()
+
+	var total int
+	for _, batch := range sink.batches {
+		if len(batch) > batchSize {
+			t.Fatalf("batch of %d exceeds %d", len(batch), batchSize)
+		}
+		for _, e := range batch {
+			if e.CreatedAt.IsZero() {
+				t.Fatal("CreatedAt should be stamped at record time")
+			}
+		}
+		total += len(batch)
+	}
+	if total != batchSize+5 {
+		t.Fatalf("wrote %d events, want %d", total, batchSize+5)
+	}
+
+	// 停止后再记录不应 panic
+	Record(model.AuditEvent{Event: EventLogout})
+}
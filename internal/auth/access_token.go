@@ -0,0 +1,9 @@
+package auth
+
+// 个人访问 token 不是 JWT，以固定前缀与之区分；持有者按 OAuth 客户端对待。
+const (
+	// AccessTokenPrefix 标识个人访问 token，AuthMiddleware 据此区分 JWT。
+	AccessTokenPrefix = "rbp_"
+	// AccessTokenClientID 写入请求上下文的 client_id：与 OAuth token 一样只能访问按 scope 授权的接口。
+	AccessTokenClientID = "personal_access_token"
+)
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"redbook/config"
+)
+
+// DPoPHeader carries the proof; DPoPNonceHeader carries a server-issued nonce.
+const (
+	DPoPHeader      = "DPoP"
+	DPoPNonceHeader = "DPoP-Nonce"
+	dpopType        = "dpop+jwt"
+)
+
+var (
+	ErrDPoPInvalid = errors.New("invalid DPoP proof")
+	ErrDPoPReplay  = errors.New("DPoP proof replayed")
+	// ErrDPoPNonce asks the client to retry with the nonce from the DPoP-Nonce header.
+	ErrDPoPNonce = errors.New("use_dpop_nonce")
+	// ErrDPoPBinding is returned when a bound token arrives without a matching proof.
+	ErrDPoPBinding = errors.New("token is bound to a different DPoP key")
+)
+
+// Confirmation is the RFC 7800 cnf claim; JKT is the RFC 7638 SHA-256
+// thumbprint of the key the token is bound to.
+type Confirmation struct {
+	JKT string `json:"jkt"`
+}
+
+// dpopClaims is the payload of a DPoP proof JWT.
+type dpopClaims struct {
+	HTM   string `json:"htm"`
+	HTU   string `json:"htu"`
+	ATH   string `json:"ath,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// dpopJWK is the public key embedded in a proof header.
+type dpopJWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+// DPoPVerifier checks DPoP proofs. Proof jti values are remembered in the
+// session store for the accepted iat window, so each proof is used once.
+type DPoPVerifier struct {
+	store SessionStore
+	cfg   config.DPoPConfig
+}
+
+// NewDPoPVerifier fills unset limits with defaults: proofs may be 60 seconds
+// off the server clock and nonces live for 5 minutes.
+func NewDPoPVerifier(store SessionStore, cfg config.DPoPConfig) *DPoPVerifier {
+	if cfg.ProofMaxAge <= 0 {
+		cfg.ProofMaxAge = 60
+	}
+	if cfg.NonceTTL <= 0 {
+		cfg.NonceTTL = 300
+	}
+	return &DPoPVerifier{store: store, cfg: cfg}
+}
+
+// Settings returns the effective configuration.
+func (v *DPoPVerifier) Settings() config.DPoPConfig {
+	return v.cfg
+}
+
+func dpopJTIKey(jkt, jti string) string { return fmt.Sprintf("rb:dpop:jti:%s:%s", jkt, jti) }
+func dpopNonceKey(nonce string) string  { return fmt.Sprintf("rb:dpop:nonce:%s", nonce) }
+
+// NewNonce issues a nonce that proofs may carry until it expires.
+func (v *DPoPVerifier) NewNonce() (string, error) {
+	nonce, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	if err := v.store.Set(dpopNonceKey(nonce), "1", time.Duration(v.cfg.NonceTTL)*time.Second); err != nil {
+		return "", err
+	}
+	return nonce, nil
+}
+
+// Verify checks a proof for the given request and returns the thumbprint of
+// its key. accessToken is set when the proof accompanies an access token, whose
+// hash must then appear in the ath claim.
+func (v *DPoPVerifier) Verify(proof string, r *http.Request, accessToken string) (string, error) {
+	var jkt string
+	token, err := jwt.ParseWithClaims(proof, &dpopClaims{}, func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != dpopType {
+			return nil, fmt.Errorf("unexpected typ %q", typ)
+		}
+		raw, err := json.Marshal(t.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		var key dpopJWK
+		if err := json.Unmarshal(raw, &key); err != nil {
+			return nil, err
+		}
+		pub, err := key.publicKey()
+		if err != nil {
+			return nil, err
+		}
+		jkt = key.thumbprint()
+		return pub, nil
+	}, jwt.WithValidMethods([]string{"ES256", "RS256", "PS256", "EdDSA"}), jwt.WithoutClaimsValidation())
+	if err != nil || !token.Valid {
+		return "", ErrDPoPInvalid
+	}
+	claims := token.Claims.(*dpopClaims)
+
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return "", ErrDPoPInvalid
+	}
+	maxAge := time.Duration(v.cfg.ProofMaxAge) * time.Second
+	if skew := time.Since(claims.IssuedAt.Time); skew > maxAge || skew < -maxAge {
+		return "", ErrDPoPInvalid
+	}
+	if !strings.EqualFold(claims.HTM, r.Method) || !sameTarget(claims.HTU, DPoPTarget(r)) {
+		return "", ErrDPoPInvalid
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if claims.ATH != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return "", ErrDPoPInvalid
+		}
+	}
+	if v.cfg.RequireNonce {
+		if claims.Nonce == "" {
+			return "", ErrDPoPNonce
+		}
+		if ok, _ := v.store.Exists(dpopNonceKey(claims.Nonce)); !ok {
+			return "", ErrDPoPNonce
+		}
+	}
+
+	// 同一证明只能使用一次；超出 iat 窗口的证明已被上面拒绝，记录保留两倍窗口即可
+	seen, err := v.store.Incr(dpopJTIKey(jkt, claims.ID), 2*maxAge)
+	if err != nil {
+		return "", err
+	}
+	if seen > 1 {
+		return "", ErrDPoPReplay
+	}
+	return jkt, nil
+}
+
+// DPoPTarget is the htu a proof for r must carry: scheme, host and path,
+// without query or fragment.
+func DPoPTarget(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+func sameTarget(htu, target string) bool {
+	htu, _, _ = strings.Cut(htu, "#")
+	htu, _, _ = strings.Cut(htu, "?")
+	return strings.EqualFold(htu, target)
+}
+
+func (k dpopJWK) publicKey() (interface{}, error) {
+	if k.D != "" {
+		return nil, errors.New("jwk must not contain a private key")
+	}
+	switch k.Kty {
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeCoordinate(k.X, 32)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeCoordinate(k.Y, 32)
+		if err != nil {
+			return nil, err
+		}
+		// 借助 ecdh 校验点在曲线上
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		if len(n)*8 < 2048 {
+			return nil, errors.New("rsa key too short")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeCoordinate(k.X, ed25519.PublicKeySize)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// thumbprint computes the RFC 7638 SHA-256 thumbprint from the required
+// members in lexicographic order.
+func (k dpopJWK) thumbprint() string {
+	var canonical string
+	switch k.Kty {
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Crv, k.X, k.Y)
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, k.Crv, k.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func decodeCoordinate(s string, size int) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != size {
+		return nil, errors.New("invalid key length")
+	}
+	return b, nil
+}
+
+// AuthorizationToken extracts the token from an Authorization header using the
+// Bearer or DPoP scheme and reports which scheme it was.
+func AuthorizationToken(header string) (token string, dpop bool, ok bool) {
+	if t, found := strings.CutPrefix(header, "Bearer "); found {
+		return t, false, t != ""
+	}
+	if t, found := strings.CutPrefix(header, "DPoP "); found {
+		return t, true, t != ""
+	}
+	return "", false, false
+}
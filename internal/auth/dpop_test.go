@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"redbook/config"
+)
+
+func signProof(t *testing.T, key *ecdsa.PrivateKey, jti, htm, htu, accessToken string) string {
+	t.Helper()
+	claims := dpopClaims{HTM: htm, HTU: htu, RegisteredClaims: jwt.RegisteredClaims{
+		ID:       jti,
+		IssuedAt: jwt.NewNumericDate(time.Now()),
+	}}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims.ATH = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = dpopType
+	token.Header["jwk"] = map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestDPoPVerify(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewDPoPVerifier(NewMemoryStore(), config.DPoPConfig{Enabled: true})
+	req := httptest.NewRequest("POST", "http://api.example.com/api/v1/users/refresh?x=1", nil)
+
+	proof := signProof(t, key, "jti-1", "POST", "http://api.example.com/api/v1/users/refresh", "")
+	jkt, err := v.Verify(proof, req, "")
+	if err != nil || jkt == "" {
+		t.Fatalf("Verify = %q, %v", jkt, err)
+	}
+	if _, err := v.Verify(proof, req, ""); !errors.Is(err, ErrDPoPReplay) {
+		t.Fatalf("expected replay rejection, got %v", err)
+	}
+
+	// htm / htu 与请求不符
+	wrong := signProof(t, key, "jti-2", "GET", "http://api.example.com/api/v1/users/refresh", "")
+	if _, err := v.Verify(wrong, req, ""); !errors.Is(err, ErrDPoPInvalid) {
+		t.Fatalf("expected method mismatch rejection, got %v", err)
+	}
+
+	// 携带 access token 时 ath 必须匹配
+	get := httptest.NewRequest("GET", "http://api.example.com/api/v1/users/sessions", nil)
+	withATH := signProof(t, key, "jti-3", "GET", "http://api.example.com/api/v1/users/sessions", "access")
+	if _, err := v.Verify(withATH, get, "other-access"); !errors.Is(err, ErrDPoPInvalid) {
+		t.Fatalf("expected ath mismatch rejection, got %v", err)
+	}
+	withATH = signProof(t, key, "jti-4", "GET", "http://api.example.com/api/v1/users/sessions", "access")
+	if got, err := v.Verify(withATH, get, "access"); err != nil || got != jkt {
+		t.Fatalf("Verify with ath = %q, %v", got, err)
+	}
+}
+
+func TestDPoPRequireNonce(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewDPoPVerifier(NewMemoryStore(), config.DPoPConfig{Enabled: true, RequireNonce: true})
+	req := httptest.NewRequest("POST", "http://api.example.com/api/v1/users/login", nil)
+	proof := signProof(t, key, "jti-1", "POST", "http://api.example.com/api/v1/users/login", "")
+	if _, err := v.Verify(proof, req, ""); !errors.Is(err, ErrDPoPNonce) {
+		t.Fatalf("expected use_dpop_nonce, got %v", err)
+	}
+}
+
+// RFC 7638 §3.1 示例密钥及其指纹
+func TestJWKThumbprint(t *testing.T) {
+	k := dpopJWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMs" +
+			"tn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5h" +
+			"ajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+	if got := k.thumbprint(); got != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Fatalf("thumbprint = %s", got)
+	}
+}
@@ -0,0 +1,29 @@
+package auth
+
+import "errors"
+
+// ErrUnavailable marks failures of the storage behind token validation (session
+// store, database). Handlers answer them with 500 rather than 401, so an outage
+// is not mistaken for a signed-out user.
+var ErrUnavailable = errors.New("authentication backend unavailable")
+
+// Error is a token rejection carrying a machine-readable code. The API returns
+// the code next to the message so clients can tell the cases apart, e.g. show
+// "signed in on another device" instead of a generic login prompt.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ErrorCode returns the code of the first *Error in err's chain, or "".
+func ErrorCode(err error) string {
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+	return ""
+}
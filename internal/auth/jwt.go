@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"time"
 
@@ -9,48 +11,164 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Token uses distinguish the two halves of a pair so one cannot stand in for the other.
+const (
+	TokenUseAccess  = "access"
+	TokenUseRefresh = "refresh"
+)
+
 // Claims defines the JWT payload shared by both access and refresh tokens.
 // It embeds RegisteredClaims so expiration and issuance metadata are centralized.
 type Claims struct {
 	UserID uint   `json:"user_id"`
 	Device string `json:"device"`
+	// FamilyID 标识一次登录产生的 token 家族，refresh 轮换时保持不变。
+	FamilyID string `json:"fid,omitempty"`
+	// TokenVersion 为签发时用户的 token 版本，版本递增后旧 token 全部失效。
+	TokenVersion int64 `json:"tv"`
+	// ClientID / Scope 仅出现在经 OAuth 授权给第三方应用的 token 中。
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// TokenUse 为 access 或 refresh；旧版本签发的 token 没有该字段。
+	TokenUse string `json:"token_use,omitempty"`
+	// Cnf 绑定 DPoP 公钥指纹，使用时必须附带该私钥签名的证明。
+	Cnf *Confirmation `json:"cnf,omitempty"`
+	// AuthTime 为用户实际完成认证（登录）的时间，轮换时保持不变。
+	AuthTime int64 `json:"auth_time,omitempty"`
+	// IdleTimeout 为会话的空闲超时（秒），每次轮换 refresh 时按此顺延。
+	IdleTimeout int64 `json:"idle,omitempty"`
+	// MaxExpiry 为会话的绝对到期时间，任何 token 都不会越过该时间。
+	MaxExpiry int64 `json:"max_exp,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// TokenParams carries the per-session attributes embedded in both tokens of a pair.
+type TokenParams struct {
+	UserID       uint
+	Device       string
+	FamilyID     string
+	TokenVersion int64
+	ClientID     string
+	Scope        string
+	// JKT 为 DPoP 公钥指纹，为空时签发普通 bearer token
+	JKT string
+	// AuthTime / MaxExpiry 为 Unix 秒，IdleTimeout 为秒；为 0 时按全局 jwt 配置签发
+	AuthTime    int64
+	IdleTimeout int64
+	MaxExpiry   int64
+}
+
+// Params extracts the session attributes so a rotation can re-issue the same session.
+func (c *Claims) Params() TokenParams {
+	return TokenParams{
+		UserID:       c.UserID,
+		Device:       c.Device,
+		FamilyID:     c.FamilyID,
+		TokenVersion: c.TokenVersion,
+		ClientID:     c.ClientID,
+		Scope:        c.Scope,
+		JKT:          c.BoundKey(),
+		AuthTime:     c.AuthTime,
+		IdleTimeout:  c.IdleTimeout,
+		MaxExpiry:    c.MaxExpiry,
+	}
+}
+
+// LifetimeExceeded reports whether the session the token belongs to has reached
+// its absolute lifetime. Tokens issued before lifetimes existed never do.
+func (c *Claims) LifetimeExceeded(now time.Time) bool {
+	return c.MaxExpiry > 0 && now.Unix() >= c.MaxExpiry
+}
+
+// BoundKey returns the DPoP key thumbprint the token is bound to, if any.
+func (c *Claims) BoundKey() string {
+	if c.Cnf == nil {
+		return ""
+	}
+	return c.Cnf.JKT
+}
+
+// NewFamilyID returns a random identifier for a new refresh token family.
+func NewFamilyID() (string, error) {
+	return randomID()
+}
+
+// randomID returns 128 random bits in hex, used for family IDs and jti values.
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// MaxTokenLifetime is the longest any token may stay valid: the access and
+// default refresh expiry, or the idle timeout of a session class, which is how
+// long its refresh tokens live. Anything that must outlast every issued token,
+// such as revocation markers or retired signing keys, is kept this long.
+func MaxTokenLifetime(cfg config.JWTConfig, lifetimes map[string]config.SessionLifetime) time.Duration {
+	longest := max(cfg.AccessExpire, cfg.RefreshExpire)
+	for _, policy := range lifetimes {
+		longest = max(longest, policy.IdleTimeout)
+	}
+	return time.Duration(longest) * time.Second
+}
+
 // GenerateTokens issues a short-lived access token and a longer-lived refresh token
-// for the given user / device pair. Both tokens share the same claim structure.
-func GenerateTokens(userID uint, device string) (accessToken, refreshToken string, err error) {
+// for the given session. Both tokens share the same claim structure and carry the
+// family ID of the login they descend from. The refresh token lives for the
+// session's idle timeout, and neither token outlives the session's MaxExpiry.
+func GenerateTokens(p TokenParams) (accessToken, refreshToken string, err error) {
 	now := time.Now()
-	accessClaims := Claims{
-		UserID: userID,
-		Device: device,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(config.GlobalConfig.JWT.AccessExpire) * time.Second)),
-			IssuedAt:  jwt.NewNumericDate(now),
-		},
-	}
-	accessToken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims).SignedString([]byte(config.GlobalConfig.JWT.Secret))
+	accessToken, err = signClaims(p, TokenUseAccess, now, time.Duration(config.GlobalConfig.JWT.AccessExpire)*time.Second)
 	if err != nil {
 		return "", "", err
 	}
+	refreshTTL := time.Duration(config.GlobalConfig.JWT.RefreshExpire) * time.Second
+	if p.IdleTimeout > 0 {
+		refreshTTL = time.Duration(p.IdleTimeout) * time.Second
+	}
+	refreshToken, err = signClaims(p, TokenUseRefresh, now, refreshTTL)
+	return
+}
 
-	refreshClaims := Claims{
-		UserID: userID,
-		Device: device,
+func signClaims(p TokenParams, use string, now time.Time, ttl time.Duration) (string, error) {
+	// 每个 token 带唯一 jti，保证同一秒内轮换出的 token 也互不相同
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	if p.MaxExpiry > 0 {
+		ttl = min(ttl, time.Unix(p.MaxExpiry, 0).Sub(now))
+	}
+	claims := Claims{
+		UserID:       p.UserID,
+		Device:       p.Device,
+		FamilyID:     p.FamilyID,
+		TokenVersion: p.TokenVersion,
+		ClientID:     p.ClientID,
+		Scope:        p.Scope,
+		TokenUse:     use,
+		AuthTime:     p.AuthTime,
+		IdleTimeout:  p.IdleTimeout,
+		MaxExpiry:    p.MaxExpiry,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(config.GlobalConfig.JWT.RefreshExpire) * time.Second)),
+			ID:        id,
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
-	refreshToken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(config.GlobalConfig.JWT.Secret))
-	return
+	if p.JKT != "" {
+		claims.Cnf = &Confirmation{JKT: p.JKT}
+	}
+	return Keys().Sign(claims)
 }
 
 // ParseToken validates signature + expiry for standard access usage.
+// The verification key is resolved from the token's kid, so tokens signed by a
+// recently rotated key remain valid until they expire.
 func ParseToken(tokenStr string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.GlobalConfig.JWT.Secret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, Keys().Keyfunc)
 	if err != nil {
 		return nil, err
 	}
@@ -65,9 +183,7 @@ func ParseToken(tokenStr string) (*Claims, error) {
 // It is helpful when we need to inspect a refresh token that might have expired.
 func ParseTokenAllowExpired(tokenStr string) (*Claims, error) {
 	// 使用 WithoutClaimsValidation 以便在验证签名的同时允许过期
-	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.GlobalConfig.JWT.Secret), nil
-	}, jwt.WithoutClaimsValidation())
+	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, Keys().Keyfunc, jwt.WithoutClaimsValidation())
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"testing"
+
+	"redbook/config"
+)
+
+func initTestKeys(t *testing.T) {
+	t.Helper()
+	config.GlobalConfig = &config.Config{JWT: config.JWTConfig{Secret: "test-secret", AccessExpire: 60, RefreshExpire: 600}}
+	if _, err := InitKeys(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateTokensUniqueJTI(t *testing.T) {
+	initTestKeys(t)
+	p := TokenParams{UserID: 1, Device: "phone", FamilyID: "f1"}
+	seen := map[string]bool{}
+	// 同一秒内为同一会话签发的 token 也必须互不相同，黑名单才能精确到单个 token
+	for i := 0; i < 3; i++ {
+		access, refresh, err := GenerateTokens(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, token := range []string{access, refresh} {
+			claims, err := ParseToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.ID == "" || seen[claims.ID] || seen[token] {
+				t.Fatalf("duplicate or empty jti %q", claims.ID)
+			}
+			seen[claims.ID], seen[token] = true, true
+		}
+	}
+}
+
+func TestBlacklistIsPerToken(t *testing.T) {
+	initTestKeys(t)
+	s := NewSessionManager(NewMemoryStore())
+	_, first, _ := GenerateTokens(TokenParams{UserID: 1, Device: "phone"})
+	_, second, _ := GenerateTokens(TokenParams{UserID: 1, Device: "phone"})
+	if err := s.AddBlackList(first, 0); err != nil {
+		t.Fatal(err)
+	}
+	if in, _ := s.InBlackList(first); !in {
+		t.Fatal("blacklisted token not found")
+	}
+	if in, _ := s.InBlackList(second); in {
+		t.Fatal("token issued in the same second inherited the blacklist entry")
+	}
+}
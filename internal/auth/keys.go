@@ -0,0 +1,465 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"redbook/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultRotateInterval = 24 * time.Hour
+	rsaKeyBits            = 2048
+	// keyReloadInterval 限制遇到未知 kid 时重新扫描 key 目录的频率，避免伪造 kid 触发大量磁盘读取
+	keyReloadInterval = 10 * time.Second
+)
+
+// ErrKeyDirRequired is returned for asymmetric algorithms without a persistent
+// key directory unless ephemeral keys were explicitly allowed.
+var ErrKeyDirRequired = errors.New("jwt key_dir is required for asymmetric algorithms (set ephemeral_keys only for local development)")
+
+// SigningKey is a single entry of the key ring, addressed by its kid.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	Private   crypto.PrivateKey
+	Public    crypto.PublicKey
+	CreatedAt time.Time
+	// RetiredAt 为停止签发的时间，零值表示仍是当前签名 key。
+	RetiredAt time.Time
+}
+
+// KeyManager owns the signing keys and handles scheduled rotation.
+// In HS256 mode it simply wraps the shared secret and never rotates.
+type KeyManager struct {
+	mu          sync.RWMutex
+	method      jwt.SigningMethod
+	keys        map[string]*SigningKey
+	active      *SigningKey
+	rotateEvery time.Duration
+	// verifyFor 为旧 key 退役后继续验签的时长，需覆盖其签发的最长 token 生命周期。
+	verifyFor time.Duration
+	dir       string
+	// lastReload 为上一次因未知 kid 重新加载 key 目录的时间。
+	lastReload time.Time
+}
+
+var (
+	keyMu      sync.Mutex
+	keyManager *KeyManager
+)
+
+// NewKeyManager builds a key ring from the JWT config, loading persisted keys
+// from KeyDir when present and generating a fresh one otherwise. Asymmetric
+// algorithms need a KeyDir unless EphemeralKeys is set. Retired keys keep
+// verifying for MaxTokenLifetime so long-lived session classes survive rotation.
+func NewKeyManager(cfg config.JWTConfig, lifetimes map[string]config.SessionLifetime) (*KeyManager, error) {
+	m := &KeyManager{
+		keys:        make(map[string]*SigningKey),
+		rotateEvery: time.Duration(cfg.RotateInterval) * time.Second,
+		verifyFor:   MaxTokenLifetime(cfg, lifetimes),
+		dir:         cfg.KeyDir,
+	}
+	if m.rotateEvery <= 0 {
+		m.rotateEvery = defaultRotateInterval
+	}
+
+	switch strings.ToUpper(cfg.Algorithm) {
+	case "", "HS256":
+		if cfg.Secret == "" {
+			return nil, errors.New("jwt secret is required for HS256")
+		}
+		// kid 留空，保持与旧 token 的兼容。
+		m.method = jwt.SigningMethodHS256
+		m.active = &SigningKey{Method: m.method, Private: []byte(cfg.Secret), CreatedAt: time.Now()}
+		return m, nil
+	case "RS256":
+		m.method = jwt.SigningMethodRS256
+	case "EDDSA":
+		m.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm %q", cfg.Algorithm)
+	}
+
+	// 内存中的私钥随进程消失，重启或扩容都会让已签发的 token 失效，只允许显式声明的开发环境使用
+	if m.dir == "" && !cfg.EphemeralKeys {
+		return nil, ErrKeyDirRequired
+	}
+	if err := m.Refresh(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// InitKeys installs the process-wide key manager used by GenerateTokens / ParseToken.
+func InitKeys() (*KeyManager, error) {
+	m, err := NewKeyManager(config.GlobalConfig.JWT, config.GlobalConfig.Session.Lifetimes)
+	if err != nil {
+		return nil, err
+	}
+	keyMu.Lock()
+	keyManager = m
+	keyMu.Unlock()
+	return m, nil
+}
+
+// Keys returns the process-wide key manager, lazily building it from config.
+func Keys() *KeyManager {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	if keyManager == nil {
+		m, err := NewKeyManager(config.GlobalConfig.JWT, config.GlobalConfig.Session.Lifetimes)
+		if err != nil {
+			panic(fmt.Sprintf("init jwt keys failed: %v", err))
+		}
+		keyManager = m
+	}
+	return keyManager
+}
+
+// Symmetric reports whether tokens are signed with the shared HS256 secret.
+func (m *KeyManager) Symmetric() bool {
+	return m.method == jwt.SigningMethodHS256
+}
+
+// Sign serializes the claims with the active key and stamps its kid into the header.
+func (m *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	m.mu.RLock()
+	k := m.active
+	m.mu.RUnlock()
+	if k == nil {
+		return "", errors.New("no active signing key")
+	}
+	token := jwt.NewWithClaims(k.Method, claims)
+	if k.ID != "" {
+		token.Header["kid"] = k.ID
+	}
+	return token.SignedString(k.Private)
+}
+
+// Keyfunc resolves the verification key for a parsed token. Retired keys keep
+// verifying until every token they signed has expired.
+func (m *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() != m.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	if m.Symmetric() {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return m.active.Private, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	m.mu.RLock()
+	k, ok := m.keys[kid]
+	m.mu.RUnlock()
+	if !ok {
+		// 其他副本可能刚轮换出新 key，还没等到本实例的定时 Refresh
+		if k, ok = m.reload(kid); !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+	}
+	if !k.RetiredAt.IsZero() && time.Since(k.RetiredAt) > m.verifyFor {
+		return nil, fmt.Errorf("key %q expired", kid)
+	}
+	return k.Public, nil
+}
+
+// reload rescans KeyDir for a kid this instance has not seen yet, at most once
+// per keyReloadInterval. It only merges new keys and never rotates or prunes.
+func (m *KeyManager) reload(kid string) (*SigningKey, bool) {
+	if m.dir == "" {
+		return nil, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if k, ok := m.keys[kid]; ok {
+		return k, true
+	}
+	if time.Since(m.lastReload) < keyReloadInterval {
+		return nil, false
+	}
+	m.lastReload = time.Now()
+	if err := m.loadDir(); err != nil {
+		log.Printf("jwt key reload failed: %v", err)
+		return nil, false
+	}
+	m.relink()
+	k, ok := m.keys[kid]
+	return k, ok
+}
+
+// Refresh reloads persisted keys, prunes expired ones and rotates the active
+// key once it is older than the rotation interval.
+func (m *KeyManager) Refresh() error {
+	if m.Symmetric() {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.dir != "" {
+		if err := m.loadDir(); err != nil {
+			return err
+		}
+	}
+
+	m.relink()
+	if m.active == nil || time.Since(m.active.CreatedAt) >= m.rotateEvery {
+		k, err := m.generate()
+		if err != nil {
+			return err
+		}
+		m.keys[k.ID] = k
+		m.relink()
+	}
+	m.prune()
+	return nil
+}
+
+// Rotate forces a new signing key; the previous one is kept for verification only.
+func (m *KeyManager) Rotate() error {
+	if m.Symmetric() {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, err := m.generate()
+	if err != nil {
+		return err
+	}
+	m.keys[k.ID] = k
+	m.relink()
+	m.prune()
+	return nil
+}
+
+// StartRotation runs Refresh periodically until stop is closed.
+func (m *KeyManager) StartRotation(stop <-chan struct{}) {
+	if m.Symmetric() {
+		return
+	}
+	interval := m.rotateEvery / 4
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.Refresh(); err != nil {
+					log.Printf("jwt key rotation failed: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// relink picks the newest key as the active one and marks older keys as retired
+// at the moment their successor was created. Caller must hold m.mu.
+func (m *KeyManager) relink() {
+	ordered := make([]*SigningKey, 0, len(m.keys))
+	for _, k := range m.keys {
+		ordered = append(ordered, k)
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].CreatedAt.Before(ordered[j].CreatedAt) })
+	m.active = nil
+	for i, k := range ordered {
+		if i == len(ordered)-1 {
+			k.RetiredAt = time.Time{}
+			m.active = k
+			continue
+		}
+		k.RetiredAt = ordered[i+1].CreatedAt
+	}
+}
+
+// prune drops retired keys whose tokens can no longer be valid. Caller must hold m.mu.
+func (m *KeyManager) prune() {
+	for id, k := range m.keys {
+		if k.RetiredAt.IsZero() || time.Since(k.RetiredAt) <= m.verifyFor {
+			continue
+		}
+		delete(m.keys, id)
+		if m.dir != "" {
+			_ = os.Remove(filepath.Join(m.dir, id+".pem"))
+		}
+	}
+}
+
+// generate creates a key for the configured algorithm and persists it when KeyDir is set.
+func (m *KeyManager) generate() (*SigningKey, error) {
+	var (
+		priv crypto.Signer
+		err  error
+	)
+	switch m.method {
+	case jwt.SigningMethodRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case jwt.SigningMethodEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("cannot generate key for %s", m.method.Alg())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	k := &SigningKey{
+		ID:        hex.EncodeToString(id),
+		Method:    m.method,
+		Private:   priv,
+		Public:    priv.Public(),
+		CreatedAt: time.Now(),
+	}
+
+	if m.dir != "" {
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(m.dir, 0o700); err != nil {
+			return nil, err
+		}
+		data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(filepath.Join(m.dir, k.ID+".pem"), data, 0o600); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// loadDir merges the PEM keys found in KeyDir into the ring. The file mtime is
+// used as the creation time so every instance sharing the directory agrees on
+// which key is active. Caller must hold m.mu.
+func (m *KeyManager) loadDir() error {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".pem" {
+			continue
+		}
+		id := strings.TrimSuffix(e.Name(), ".pem")
+		if _, ok := m.keys[id]; ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filepath.Join(m.dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("key %s: invalid pem", e.Name())
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", e.Name(), err)
+		}
+		priv, ok := parsed.(crypto.Signer)
+		if !ok || !m.matchesMethod(priv) {
+			log.Printf("jwt key %s does not match algorithm %s, skipped", e.Name(), m.method.Alg())
+			continue
+		}
+		m.keys[id] = &SigningKey{
+			ID:        id,
+			Method:    m.method,
+			Private:   priv,
+			Public:    priv.Public(),
+			CreatedAt: info.ModTime(),
+		}
+	}
+	return nil
+}
+
+func (m *KeyManager) matchesMethod(priv crypto.Signer) bool {
+	switch priv.(type) {
+	case *rsa.PrivateKey:
+		return m.method == jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		return m.method == jwt.SigningMethodEdDSA
+	}
+	return false
+}
+
+// JWK is the public half of a signing key in RFC 7517 form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists every public key that can still verify tokens. It is empty in HS256 mode.
+func (m *KeyManager) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	if m.Symmetric() {
+		return set
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, k := range m.keys {
+		if !k.RetiredAt.IsZero() && time.Since(k.RetiredAt) > m.verifyFor {
+			continue
+		}
+		jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+		switch pub := k.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"redbook/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signTestToken(t *testing.T, m *KeyManager) string {
+	t.Helper()
+	token, err := m.Sign(jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func verifyTestToken(m *KeyManager, token string) (string, error) {
+	parsed, err := jwt.ParseWithClaims(token, &jwt.RegisteredClaims{}, m.Keyfunc)
+	if err != nil {
+		return "", err
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid, nil
+}
+
+func TestKeyManagerRequiresPersistentKeys(t *testing.T) {
+	cfg := config.JWTConfig{Algorithm: "EdDSA", AccessExpire: 60, RefreshExpire: 600}
+	if _, err := NewKeyManager(cfg, nil); !errors.Is(err, ErrKeyDirRequired) {
+		t.Fatalf("expected ErrKeyDirRequired, got %v", err)
+	}
+	cfg.EphemeralKeys = true
+	if _, err := NewKeyManager(cfg, nil); err != nil {
+		t.Fatalf("ephemeral keys rejected: %v", err)
+	}
+	// HS256 使用共享密钥，不需要 key 目录
+	if _, err := NewKeyManager(config.JWTConfig{Secret: "s"}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyRotationSignsWithNewKidAndVerifiesRetired(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewKeyManager(config.JWTConfig{Algorithm: "EdDSA", KeyDir: dir, AccessExpire: 60, RefreshExpire: 600}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := signTestToken(t, m)
+	oldKid, err := verifyTestToken(m, old)
+	if err != nil || oldKid == "" {
+		t.Fatalf("kid %q, %v", oldKid, err)
+	}
+
+	if err := m.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	current := signTestToken(t, m)
+	kid, err := verifyTestToken(m, current)
+	if err != nil || kid == oldKid {
+		t.Fatalf("token after rotation signed with %q (old %q), %v", kid, oldKid, err)
+	}
+	// 退役 key 在其 token 过期前仍可验签，JWKS 同时公布两把公钥
+	if _, err := verifyTestToken(m, old); err != nil {
+		t.Fatalf("retired key no longer verifies: %v", err)
+	}
+	if set := m.JWKS(); len(set.Keys) != 2 {
+		t.Fatalf("JWKS = %+v", set)
+	}
+
+	// 超过验签期后退役 key 被拒绝、从 JWKS 与 key 目录中移除
+	m.verifyFor = time.Nanosecond
+	if _, err := verifyTestToken(m, old); err == nil {
+		t.Fatal("expired retired key still verifies")
+	}
+	if err := m.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if set := m.JWKS(); len(set.Keys) != 1 || set.Keys[0].Kid != kid {
+		t.Fatalf("JWKS after prune = %+v", set)
+	}
+	if _, err := os.Stat(filepath.Join(dir, oldKid+".pem")); !os.IsNotExist(err) {
+		t.Fatalf("retired key file not removed: %v", err)
+	}
+}
+
+func TestRetiredKeyOutlivesLongestSessionLifetime(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.JWTConfig{Algorithm: "EdDSA", KeyDir: dir, AccessExpire: 60, RefreshExpire: 600, RotateInterval: 86400}
+	lifetimes := map[string]config.SessionLifetime{"mobile": {IdleTimeout: 2592000, AbsoluteLifetime: 7776000}}
+	m, err := NewKeyManager(cfg, lifetimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 移动端 refresh token 按 idle_timeout 存活，远长于 refresh_expire
+	old, err := m.Sign(jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(30 * 24 * time.Hour))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKid := m.active.ID
+	m.active.CreatedAt = time.Now().Add(-2 * time.Hour)
+	if err := m.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	// 退役已一小时，超过 refresh_expire
+	m.active.CreatedAt = time.Now().Add(-time.Hour)
+	m.relink()
+
+	if err := m.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyTestToken(m, old); err != nil {
+		t.Fatalf("mobile refresh token rejected after rotation: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, oldKid+".pem")); err != nil {
+		t.Fatalf("retired key pruned before the session lifetime: %v", err)
+	}
+}
+
+func TestKeyDirSharedAcrossInstances(t *testing.T) {
+	cfg := config.JWTConfig{Algorithm: "EdDSA", KeyDir: t.TempDir(), AccessExpire: 60, RefreshExpire: 600}
+	a, err := NewKeyManager(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signTestToken(t, a)
+	// 重启或另一个副本加载同一目录，沿用同一把 key
+	b, err := NewKeyManager(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyTestToken(b, token); err != nil {
+		t.Fatalf("token from another instance rejected: %v", err)
+	}
+	if ka, kb := a.JWKS().Keys, b.JWKS().Keys; len(kb) != 1 || ka[0].Kid != kb[0].Kid {
+		t.Fatalf("instances disagree on keys: %+v vs %+v", ka, kb)
+	}
+}
+
+func TestUnknownKidReloadsKeyDir(t *testing.T) {
+	cfg := config.JWTConfig{Algorithm: "EdDSA", KeyDir: t.TempDir(), AccessExpire: 60, RefreshExpire: 600}
+	a, err := NewKeyManager(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewKeyManager(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a 轮换后立即用新 key 签发，b 尚未执行定时 Refresh
+	if err := a.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	token := signTestToken(t, a)
+	if kid, err := verifyTestToken(b, token); err != nil || kid != a.active.ID {
+		t.Fatalf("token signed with rotated key rejected by peer: kid %q, %v", kid, err)
+	}
+
+	// 重新扫描有频率限制：刚加载过目录，短时间内另一把新 key 仍被拒绝
+	if err := a.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyTestToken(b, signTestToken(t, a)); err == nil {
+		t.Fatal("key dir reloaded again within the reload interval")
+	}
+	b.lastReload = time.Now().Add(-keyReloadInterval)
+	if _, err := verifyTestToken(b, signTestToken(t, a)); err != nil {
+		t.Fatalf("reload after the interval: %v", err)
+	}
+}
+
+func TestJWKSPublishesRSAPublicKey(t *testing.T) {
+	m, err := NewKeyManager(config.JWTConfig{Algorithm: "RS256", EphemeralKeys: true, AccessExpire: 60, RefreshExpire: 600}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := m.JWKS()
+	if len(set.Keys) != 1 {
+		t.Fatalf("JWKS = %+v", set)
+	}
+	jwk := set.Keys[0]
+	if jwk.Kty != "RSA" || jwk.Alg != "RS256" || jwk.Use != "sig" || jwk.Kid != m.active.ID {
+		t.Fatalf("unexpected jwk %+v", jwk)
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+	pub := m.active.Public.(*rsa.PublicKey)
+	if new(big.Int).SetBytes(n).Cmp(pub.N) != 0 || new(big.Int).SetBytes(e).Int64() != int64(pub.E) {
+		t.Fatal("jwk does not match the signing key")
+	}
+	// HS256 不公布任何 key
+	hs, _ := NewKeyManager(config.JWTConfig{Secret: "s"}, nil)
+	if keys := hs.JWKS().Keys; len(keys) != 0 {
+		t.Fatalf("HS256 JWKS = %+v", keys)
+	}
+}
@@ -2,50 +2,307 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
-
-	"github.com/go-redis/redis/v8"
+	"unicode/utf8"
 )
 
 var ctx = context.Background()
 
-// SessionManager persists refresh tokens and blacklist markers in Redis.
+// SessionManager persists refresh tokens and blacklist markers in a SessionStore.
 type SessionManager struct {
-	rdb *redis.Client
+	store SessionStore
+}
+
+func NewSessionManager(store SessionStore) *SessionManager {
+	return &SessionManager{store: store}
+}
+
+// Store exposes the underlying backend so other components (rate limiters etc.)
+// share the same storage.
+func (s *SessionManager) Store() SessionStore {
+	return s.store
+}
+
+func sessionKey(userID uint, device string) string {
+	return fmt.Sprintf("rb:session:%d:%s", userID, device)
+}
+
+func sessionIndexKey(userID uint) string {
+	return fmt.Sprintf("rb:sessions:%d", userID)
+}
+
+// SessionInfo describes one device session: who the client is, where it
+// signed in from and when it was last refreshed.
+type SessionInfo struct {
+	// Device 为客户端上报的设备 ID（X-Device）
+	Device     string    `json:"device"`
+	DeviceName string    `json:"device_name,omitempty"`
+	Platform   string    `json:"platform,omitempty"`
+	AppVersion string    `json:"app_version,omitempty"`
+	Class      string    `json:"device_class,omitempty"`
+	LoginIP    string    `json:"login_ip"`
+	LastSeenIP string    `json:"last_seen_ip"`
+	UserAgent  string    `json:"user_agent"`
+	FamilyID   string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	// LastRefreshedAt 最近一次登录或轮换 refresh 的时间
+	LastRefreshedAt time.Time `json:"last_refreshed_at"`
+	// IdleTimeout 为空闲超时（秒）：超过该时间未刷新，会话自动失效
+	IdleTimeout int64 `json:"idle_timeout,omitempty"`
+	// ExpiresAt 为会话的绝对到期时间，到期后必须重新登录
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// sessionRecord is the value stored per user/device: the current refresh
+// token and the session metadata.
+type sessionRecord struct {
+	SessionInfo
+	FamilyID     string `json:"family_id,omitempty"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// 客户端请求头中的设备信息在写入会话前截断到以下长度（字节）。
+const (
+	maxDeviceNameLen = 64
+	maxPlatformLen   = 32
+	maxAppVersionLen = 32
+	maxUserAgentLen  = 255
+)
+
+// sessionCASAttempts bounds the compare-and-set retries of SaveSession.
+const sessionCASAttempts = 5
+
+var errSessionContended = errors.New("device session changed concurrently")
+
+// ErrSessionRotated is returned by RotateSession when the device session no
+// longer holds the refresh token being rotated: another request rotated it first
+// or the device signed out.
+var ErrSessionRotated = errors.New("device session no longer holds this refresh token")
+
+// SaveSession stores the refresh token of a device session together with its
+// metadata and indexes the device under the user. A rotation within the same
+// token family keeps the creation time, login IP and any client details the
+// refreshing request did not send; a new family starts a fresh record. The
+// merge is written with compare-and-set against the record it was based on,
+// so concurrent writers for one device never lose each other's fields.
+func (s *SessionManager) SaveSession(userID uint, info SessionInfo, refreshToken string, ttl time.Duration) error {
+	return s.writeSession(userID, info, "", refreshToken, ttl)
+}
+
+// RotateSession replaces the refresh token of a device session like SaveSession,
+// but only while the session still holds current. Of two concurrent rotations
+// of the same token exactly one succeeds; the other gets ErrSessionRotated.
+func (s *SessionManager) RotateSession(userID uint, info SessionInfo, current, refreshToken string, ttl time.Duration) error {
+	if current == "" {
+		return ErrSessionRotated
+	}
+	return s.writeSession(userID, info, current, refreshToken, ttl)
+}
+
+// writeSession is the compare-and-set loop behind SaveSession and RotateSession.
+// A non-empty expect requires the stored record to hold that refresh token.
+func (s *SessionManager) writeSession(userID uint, info SessionInfo, expect, refreshToken string, ttl time.Duration) error {
+	info.DeviceName = truncate(info.DeviceName, maxDeviceNameLen)
+	info.Platform = truncate(info.Platform, maxPlatformLen)
+	info.AppVersion = truncate(info.AppVersion, maxAppVersionLen)
+	info.UserAgent = truncate(info.UserAgent, maxUserAgentLen)
+
+	key := sessionKey(userID, info.Device)
+	for i := 0; i < sessionCASAttempts; i++ {
+		raw, err := s.store.Get(key)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		var prev *sessionRecord
+		if raw != "" {
+			if prev, err = decodeRecord(info.Device, raw); err != nil {
+				prev = nil
+			}
+		}
+		// 比较基于 raw 整体，校验通过后写入前若被并发轮换，CAS 会失败并重新校验
+		if expect != "" && (prev == nil || prev.RefreshToken != expect) {
+			return ErrSessionRotated
+		}
+		data, err := json.Marshal(mergeSession(prev, info, refreshToken))
+		if err != nil {
+			return err
+		}
+		ok, err := s.store.CompareAndSet(key, raw, string(data), ttl)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return s.store.SAdd(sessionIndexKey(userID), info.Device, ttl)
+		}
+	}
+	return fmt.Errorf("save session %d/%s: %w", userID, info.Device, errSessionContended)
+}
+
+// mergeSession builds the record SaveSession writes over prev, which may be nil.
+func mergeSession(prev *sessionRecord, info SessionInfo, refreshToken string) sessionRecord {
+	now := time.Now()
+	rec := sessionRecord{SessionInfo: info, FamilyID: info.FamilyID, RefreshToken: refreshToken}
+	rec.LastRefreshedAt = now
+	if prev != nil && prev.FamilyID == info.FamilyID {
+		rec.CreatedAt = prev.CreatedAt
+		rec.LoginIP = prev.LoginIP
+		for _, f := range []struct {
+			dst *string
+			old string
+		}{
+			{&rec.DeviceName, prev.DeviceName},
+			{&rec.Platform, prev.Platform},
+			{&rec.AppVersion, prev.AppVersion},
+			{&rec.Class, prev.Class},
+			{&rec.UserAgent, prev.UserAgent},
+			{&rec.LastSeenIP, prev.LastSeenIP},
+		} {
+			if *f.dst == "" {
+				*f.dst = f.old
+			}
+		}
+		if rec.IdleTimeout == 0 {
+			rec.IdleTimeout = prev.IdleTimeout
+		}
+		if rec.ExpiresAt.IsZero() {
+			rec.ExpiresAt = prev.ExpiresAt
+		}
+	}
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = now
+	}
+	if rec.LoginIP == "" {
+		rec.LoginIP = rec.LastSeenIP
+	}
+	return rec
 }
 
-func NewSessionManager(rdb *redis.Client) *SessionManager {
-	return &SessionManager{rdb: rdb}
+// truncate cuts s to at most n bytes without splitting a UTF-8 character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
 }
 
-// SaveRefreshToken stores the refresh token for the specific user/device pair.
-func (s *SessionManager) SaveRefreshToken(userID uint, device, token string, ttl time.Duration) error {
-	key := fmt.Sprintf("rb:session:%d:%s", userID, device)
-	return s.rdb.Set(ctx, key, token, ttl).Err()
+// getRecord loads a device session.
+func (s *SessionManager) getRecord(userID uint, device string) (*sessionRecord, error) {
+	raw, err := s.store.Get(sessionKey(userID, device))
+	if err != nil {
+		return nil, err
+	}
+	return decodeRecord(device, raw)
+}
+
+// decodeRecord parses a stored device session. Sessions written before they
+// became structured records hold only the bare refresh token.
+func decodeRecord(device, raw string) (*sessionRecord, error) {
+	if !strings.HasPrefix(raw, "{") {
+		return &sessionRecord{SessionInfo: SessionInfo{Device: device}, RefreshToken: raw}, nil
+	}
+	var rec sessionRecord
+	if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// GetSession returns the metadata of a device session.
+func (s *SessionManager) GetSession(userID uint, device string) (*SessionInfo, error) {
+	rec, err := s.getRecord(userID, device)
+	if err != nil {
+		return nil, err
+	}
+	rec.SessionInfo.FamilyID = rec.FamilyID
+	return &rec.SessionInfo, nil
 }
 
 // GetRefreshToken fetches the latest refresh token for a user/device.
 func (s *SessionManager) GetRefreshToken(userID uint, device string) (string, error) {
-	key := fmt.Sprintf("rb:session:%d:%s", userID, device)
-	return s.rdb.Get(ctx, key).Result()
+	rec, err := s.getRecord(userID, device)
+	if err != nil {
+		return "", err
+	}
+	return rec.RefreshToken, nil
 }
 
-// DeleteRefreshToken removes the stored refresh token, used during logout.
+// DeleteRefreshToken removes the device session, used during logout.
 func (s *SessionManager) DeleteRefreshToken(userID uint, device string) error {
-	key := fmt.Sprintf("rb:session:%d:%s", userID, device)
-	return s.rdb.Del(ctx, key).Err()
+	if err := s.store.Del(sessionKey(userID, device)); err != nil {
+		return err
+	}
+	return s.store.SRem(sessionIndexKey(userID), device)
+}
+
+// ListSessions returns the user's live device sessions, most recently refreshed
+// first. Devices whose session has expired are pruned from the index on the way.
+func (s *SessionManager) ListSessions(userID uint) ([]SessionInfo, error) {
+	indexKey := sessionIndexKey(userID)
+	devices, err := s.store.SMembers(indexKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]SessionInfo, 0, len(devices))
+	for _, device := range devices {
+		rec, err := s.getRecord(userID, device)
+		if errors.Is(err, ErrNotFound) {
+			_ = s.store.SRem(indexKey, device)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec.SessionInfo.FamilyID = rec.FamilyID
+		sessions = append(sessions, rec.SessionInfo)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastRefreshedAt.After(sessions[j].LastRefreshedAt) })
+	return sessions, nil
 }
 
 // AddBlackList blacklists a token for the remainder of its lifetime.
 func (s *SessionManager) AddBlackList(token string, ttl time.Duration) error {
 	key := fmt.Sprintf("rb:black:%s", token)
-	return s.rdb.Set(ctx, key, "1", ttl).Err()
+	return s.store.Set(key, "1", ttl)
 }
 
 // InBlackList reports whether a token has been invalidated previously.
 func (s *SessionManager) InBlackList(token string) (bool, error) {
 	key := fmt.Sprintf("rb:black:%s", token)
-	res, err := s.rdb.Exists(ctx, key).Result()
-	return res == 1, err
+	return s.store.Exists(key)
+}
+
+// SaveFamily marks a refresh token family as live for the given user/device.
+func (s *SessionManager) SaveFamily(familyID string, userID uint, device string, ttl time.Duration) error {
+	key := fmt.Sprintf("rb:family:%s", familyID)
+	return s.store.Set(key, fmt.Sprintf("%d:%s", userID, device), ttl)
+}
+
+// FamilyExists reports whether the family is still live (not expired or logged out).
+func (s *SessionManager) FamilyExists(familyID string) (bool, error) {
+	key := fmt.Sprintf("rb:family:%s", familyID)
+	return s.store.Exists(key)
+}
+
+// RevokeFamily invalidates every access and refresh token issued to the family.
+// The marker must outlive the longest token of the family.
+func (s *SessionManager) RevokeFamily(familyID string, ttl time.Duration) error {
+	if err := s.store.Set(fmt.Sprintf("rb:family:revoked:%s", familyID), "1", ttl); err != nil {
+		return err
+	}
+	return s.store.Del(fmt.Sprintf("rb:family:%s", familyID))
+}
+
+// FamilyRevoked reports whether the family has been revoked.
+func (s *SessionManager) FamilyRevoked(familyID string) (bool, error) {
+	key := fmt.Sprintf("rb:family:revoked:%s", familyID)
+	return s.store.Exists(key)
 }
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestTokenFamilyRevocation(t *testing.T) {
+	s := NewSessionManager(NewMemoryStore())
+	if err := s.SaveFamily("f1", 7, "phone", 0); err != nil {
+		t.Fatal(err)
+	}
+	if live, _ := s.FamilyExists("f1"); !live {
+		t.Fatal("saved family should be live")
+	}
+	if revoked, _ := s.FamilyRevoked("f1"); revoked {
+		t.Fatal("fresh family reported as revoked")
+	}
+
+	if err := s.RevokeFamily("f1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if live, _ := s.FamilyExists("f1"); live {
+		t.Fatal("revoked family should no longer be live")
+	}
+	if revoked, _ := s.FamilyRevoked("f1"); !revoked {
+		t.Fatal("family should be revoked")
+	}
+	// 其他家族不受影响
+	if revoked, _ := s.FamilyRevoked("f2"); revoked {
+		t.Fatal("unrelated family revoked")
+	}
+}
+
+func TestListSessionsNewestFirstAndPrunesExpired(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+	s := NewSessionManager(store)
+
+	if err := s.SaveSession(3, SessionInfo{Device: "web", FamilyID: "f1"}, "r1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveSession(3, SessionInfo{Device: "phone", FamilyID: "f2"}, "r2", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := s.ListSessions(3)
+	if err != nil || len(sessions) != 2 || sessions[0].Device != "phone" {
+		t.Fatalf("ListSessions = %+v, %v", sessions, err)
+	}
+
+	// web 会话过期后从列表与索引中移除
+	now = now.Add(2 * time.Minute)
+	sessions, _ = s.ListSessions(3)
+	if len(sessions) != 1 || sessions[0].Device != "phone" || sessions[0].FamilyID != "f2" {
+		t.Fatalf("ListSessions after expiry = %+v", sessions)
+	}
+	if members, _ := store.SMembers(sessionIndexKey(3)); len(members) != 1 {
+		t.Fatalf("index not pruned: %v", members)
+	}
+}
+
+func TestSaveSessionConcurrentWritersKeepFields(t *testing.T) {
+	s := NewSessionManager(NewMemoryStore())
+	if err := s.SaveSession(4, SessionInfo{Device: "phone", FamilyID: "f1"}, "r0", 0); err != nil {
+		t.Fatal(err)
+	}
+	// 同一家族的并发写入各自只带一部分设备信息，合并结果不能丢字段
+	updates := []SessionInfo{
+		{Device: "phone", FamilyID: "f1", DeviceName: "Pixel"},
+		{Device: "phone", FamilyID: "f1", Platform: "android"},
+		{Device: "phone", FamilyID: "f1", AppVersion: "2.1.0"},
+		{Device: "phone", FamilyID: "f1", UserAgent: "redbook/2.1"},
+	}
+	var wg sync.WaitGroup
+	for i, info := range updates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.SaveSession(4, info, fmt.Sprintf("r%d", i+1), 0); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := s.GetSession(4, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DeviceName != "Pixel" || got.Platform != "android" || got.AppVersion != "2.1.0" || got.UserAgent != "redbook/2.1" {
+		t.Fatalf("merged session lost fields: %+v", got)
+	}
+}
+
+func TestSaveSessionCapsClientDetails(t *testing.T) {
+	s := NewSessionManager(NewMemoryStore())
+	info := SessionInfo{
+		Device:     "phone",
+		DeviceName: strings.Repeat("名", 40), // 3 字节的字符，截断不能落在字符中间
+		Platform:   strings.Repeat("p", 100),
+		AppVersion: strings.Repeat("9", 100),
+		UserAgent:  strings.Repeat("u", 10000),
+	}
+	if err := s.SaveSession(5, info, "r1", 0); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetSession(5, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.DeviceName) > maxDeviceNameLen || !utf8.ValidString(got.DeviceName) || got.DeviceName == "" {
+		t.Errorf("device name %q (%d bytes)", got.DeviceName, len(got.DeviceName))
+	}
+	if len(got.Platform) != maxPlatformLen || len(got.AppVersion) != maxAppVersionLen || len(got.UserAgent) != maxUserAgentLen {
+		t.Errorf("lengths: platform %d, app version %d, user agent %d", len(got.Platform), len(got.AppVersion), len(got.UserAgent))
+	}
+}
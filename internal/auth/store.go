@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by SessionStore.Get when the key is missing or expired.
+var ErrNotFound = errors.New("store: key not found")
+
+// SessionStore is the key/value backend behind refresh token storage, the token
+// blacklist and rate counters. Every write takes a TTL; a zero TTL keeps the key
+// until it is deleted.
+type SessionStore interface {
+	Set(key, value string, ttl time.Duration) error
+	Get(key string) (string, error)
+	// GetDel atomically reads and deletes a key, used for single-use values.
+	GetDel(key string) (string, error)
+	// CompareAndSet atomically replaces the value of key with value only while it
+	// still holds old; an empty old means "only if the key is absent". A zero TTL
+	// keeps the key until it is deleted. It reports whether the write happened.
+	CompareAndSet(key, old, value string, ttl time.Duration) (bool, error)
+	Del(keys ...string) error
+	Exists(key string) (bool, error)
+	Expire(key string, ttl time.Duration) error
+
+	// Incr atomically increments a counter and starts its TTL window on the first hit.
+	Incr(key string, window time.Duration) (int64, error)
+
+	HSet(key string, fields map[string]string, ttl time.Duration) error
+	HGetAll(key string) (map[string]string, error)
+
+	SAdd(key, member string, ttl time.Duration) error
+	SRem(key, member string) error
+	SMembers(key string) ([]string, error)
+
+	// SlidingWindow records a hit in a sliding log of the last window and allows
+	// it only while fewer than limit hits are in the log.
+	SlidingWindow(key string, limit int64, window time.Duration) (RateLimitResult, error)
+	// GCRA is a token bucket refilling limit tokens per period with room for
+	// burst tokens, implemented as the generic cell rate algorithm.
+	GCRA(key string, limit int64, period time.Duration, burst int64) (RateLimitResult, error)
+}
+
+// RateLimitResult is the decision of one rate limiter check.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int64
+	// RetryAfter 被拒绝时距离下一次可放行的时间
+	RetryAfter time.Duration
+}
+
+// gcraEmission is the interval at which the bucket regains one token.
+func gcraEmission(limit int64, period time.Duration) time.Duration {
+	if limit <= 0 {
+		return period
+	}
+	// Redis 脚本以毫秒计时，间隔不能小于 1ms
+	return max(period/time.Duration(limit), time.Millisecond)
+}
@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sweepEvery controls how many writes happen between full expiry sweeps.
+const sweepEvery = 1024
+
+type memoryEntry struct {
+	str       string
+	hash      map[string]string
+	set       map[string]struct{}
+	hits      []time.Time // SlidingWindow 的命中记录
+	tat       time.Time   // GCRA 的理论到达时间
+	expiresAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// MemoryStore is a TTL-aware, process-local SessionStore. It is meant for tests
+// and single-instance development setups where running Redis is not wanted.
+type MemoryStore struct {
+	mu     sync.Mutex
+	data   map[string]*memoryEntry
+	writes int
+	now    func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string]*memoryEntry), now: time.Now}
+}
+
+// SetClock replaces the time source used for expiry, letting tests step past TTLs.
+func (m *MemoryStore) SetClock(now func() time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = now
+}
+
+// lookup returns a live entry, dropping it if it has expired. Caller must hold m.mu.
+func (m *MemoryStore) lookup(key string) *memoryEntry {
+	e, ok := m.data[key]
+	if !ok {
+		return nil
+	}
+	if e.expired(m.now()) {
+		delete(m.data, key)
+		return nil
+	}
+	return e
+}
+
+// entry returns a live entry for writing, creating it when missing. Caller must hold m.mu.
+func (m *MemoryStore) entry(key string) *memoryEntry {
+	m.writes++
+	if m.writes%sweepEvery == 0 {
+		now := m.now()
+		for k, e := range m.data {
+			if e.expired(now) {
+				delete(m.data, k)
+			}
+		}
+	}
+	e := m.lookup(key)
+	if e == nil {
+		e = &memoryEntry{}
+		m.data[key] = e
+	}
+	return e
+}
+
+func (m *MemoryStore) deadline(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return m.now().Add(ttl)
+}
+
+func (m *MemoryStore) Set(key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entry(key)
+	*e = memoryEntry{str: value, expiresAt: m.deadline(ttl)}
+	return nil
+}
+
+func (m *MemoryStore) Get(key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.lookup(key)
+	if e == nil || e.hash != nil || e.set != nil {
+		return "", ErrNotFound
+	}
+	return e.str, nil
+}
+
+func (m *MemoryStore) GetDel(key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.lookup(key)
+	if e == nil || e.hash != nil || e.set != nil {
+		return "", ErrNotFound
+	}
+	delete(m.data, key)
+	return e.str, nil
+}
+
+func (m *MemoryStore) CompareAndSet(key, old, value string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.lookup(key)
+	if old == "" {
+		if e != nil {
+			return false, nil
+		}
+	} else if e == nil || e.hash != nil || e.set != nil || e.str != old {
+		return false, nil
+	}
+	e = m.entry(key)
+	*e = memoryEntry{str: value, expiresAt: m.deadline(ttl)}
+	return true, nil
+}
+
+func (m *MemoryStore) Del(keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range keys {
+		delete(m.data, k)
+	}
+	return nil
+}
+
+func (m *MemoryStore) Exists(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lookup(key) != nil, nil
+}
+
+func (m *MemoryStore) Expire(key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.lookup(key); e != nil {
+		e.expiresAt = m.deadline(ttl)
+	}
+	return nil
+}
+
+func (m *MemoryStore) Incr(key string, window time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entry(key)
+	count, _ := strconv.ParseInt(e.str, 10, 64)
+	count++
+	e.str = strconv.FormatInt(count, 10)
+	if count == 1 {
+		e.expiresAt = m.deadline(window)
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) HSet(key string, fields map[string]string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entry(key)
+	if e.hash == nil {
+		e.hash = make(map[string]string, len(fields))
+	}
+	for k, v := range fields {
+		e.hash[k] = v
+	}
+	if ttl > 0 {
+		e.expiresAt = m.deadline(ttl)
+	}
+	return nil
+}
+
+func (m *MemoryStore) HGetAll(key string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]string)
+	if e := m.lookup(key); e != nil {
+		for k, v := range e.hash {
+			out[k] = v
+		}
+	}
+	return out, nil
+}
+
+func (m *MemoryStore) SAdd(key, member string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entry(key)
+	if e.set == nil {
+		e.set = make(map[string]struct{})
+	}
+	e.set[member] = struct{}{}
+	if ttl > 0 {
+		e.expiresAt = m.deadline(ttl)
+	}
+	return nil
+}
+
+func (m *MemoryStore) SRem(key, member string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.lookup(key); e != nil && e.set != nil {
+		delete(e.set, member)
+		if len(e.set) == 0 {
+			delete(m.data, key)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) SMembers(key string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.lookup(key)
+	if e == nil {
+		return []string{}, nil
+	}
+	out := make([]string, 0, len(e.set))
+	for member := range e.set {
+		out = append(out, member)
+	}
+	return out, nil
+}
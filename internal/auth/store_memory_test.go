@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	m := NewMemoryStore()
+	m.now = func() time.Time { return now }
+
+	_ = m.Set("k", "v", time.Minute)
+	_ = m.SAdd("set", "a", time.Minute)
+	if v, err := m.Get("k"); err != nil || v != "v" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := m.Get("k"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected expired key, got %v", err)
+	}
+	if members, _ := m.SMembers("set"); len(members) != 0 {
+		t.Fatalf("expected expired set, got %v", members)
+	}
+}
+
+func TestMemoryStoreIncrWindow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	m := NewMemoryStore()
+	m.now = func() time.Time { return now }
+
+	for i := int64(1); i <= 3; i++ {
+		if n, _ := m.Incr("rl", time.Minute); n != i {
+			t.Fatalf("Incr = %d, want %d", n, i)
+		}
+	}
+	// 后续递增不应延长窗口
+	now = now.Add(time.Minute)
+	if n, _ := m.Incr("rl", time.Minute); n != 1 {
+		t.Fatalf("counter should restart after window, got %d", n)
+	}
+}
+
+func TestMemoryStoreSlidingWindow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	m := NewMemoryStore()
+	m.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if res, _ := m.SlidingWindow("sw", 3, time.Minute); !res.Allowed {
+			t.Fatalf("hit %d should be allowed", i)
+		}
+		now = now.Add(10 * time.Second)
+	}
+	res, _ := m.SlidingWindow("sw", 3, time.Minute)
+	if res.Allowed || res.RetryAfter != 30*time.Second {
+		t.Fatalf("expected rejection with 30s retry, got %+v", res)
+	}
+	// 最早的命中滑出窗口后腾出一个名额
+	now = now.Add(30 * time.Second)
+	if res, _ := m.SlidingWindow("sw", 3, time.Minute); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("expected one slot after slide, got %+v", res)
+	}
+}
+
+func TestMemoryStoreGCRA(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	m := NewMemoryStore()
+	m.now = func() time.Time { return now }
+
+	// 每分钟 60 个令牌，突发 3 个
+	for i := int64(0); i < 3; i++ {
+		res, _ := m.GCRA("tb", 60, time.Minute, 3)
+		if !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("burst request %d: %+v", i, res)
+		}
+	}
+	res, _ := m.GCRA("tb", 60, time.Minute, 3)
+	if res.Allowed || res.RetryAfter != time.Second {
+		t.Fatalf("expected rejection with 1s retry, got %+v", res)
+	}
+	now = now.Add(time.Second)
+	if res, _ := m.GCRA("tb", 60, time.Minute, 3); !res.Allowed {
+		t.Fatalf("expected a refilled token, got %+v", res)
+	}
+}
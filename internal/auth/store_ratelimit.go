@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 两个脚本都以 Redis 服务器时间为准，多实例部署时不受各节点时钟偏差影响。
+
+// slidingWindowScript keeps one sorted-set member per accepted hit, scored by
+// its timestamp in milliseconds.
+var slidingWindowScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count < limit then
+  redis.call('ZADD', KEYS[1], now, ARGV[3])
+  redis.call('PEXPIRE', KEYS[1], window)
+  return {1, limit - count - 1, 0}
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {0, 0, tonumber(oldest[2]) + window - now}
+`)
+
+// gcraScript stores the theoretical arrival time (TAT) of the next request.
+var gcraScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local emission = tonumber(ARGV[1])
+local tolerance = emission * tonumber(ARGV[2])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+  tat = now
+end
+local new_tat = tat + emission
+local allow_at = new_tat - tolerance
+if allow_at > now then
+  return {0, 0, allow_at - now}
+end
+redis.call('SET', KEYS[1], new_tat, 'PX', new_tat - now)
+return {1, math.floor((now - allow_at) / emission), 0}
+`)
+
+func (r *RedisStore) SlidingWindow(key string, limit int64, window time.Duration) (RateLimitResult, error) {
+	member, err := randomID()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	res, err := slidingWindowScript.Run(ctx, r.rdb, []string{key}, window.Milliseconds(), limit, member).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return scriptResult(res), nil
+}
+
+func (r *RedisStore) GCRA(key string, limit int64, period time.Duration, burst int64) (RateLimitResult, error) {
+	emission := gcraEmission(limit, period)
+	res, err := gcraScript.Run(ctx, r.rdb, []string{key}, emission.Milliseconds(), max(burst, 1)).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return scriptResult(res), nil
+}
+
+func scriptResult(res []int64) RateLimitResult {
+	return RateLimitResult{
+		Allowed:    res[0] == 1,
+		Remaining:  res[1],
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+	}
+}
+
+func (m *MemoryStore) SlidingWindow(key string, limit int64, window time.Duration) (RateLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	e := m.entry(key)
+	hits := e.hits[:0]
+	for _, hit := range e.hits {
+		if now.Sub(hit) < window {
+			hits = append(hits, hit)
+		}
+	}
+	e.hits = hits
+	if int64(len(hits)) >= limit {
+		if len(hits) == 0 {
+			return RateLimitResult{RetryAfter: window}, nil
+		}
+		return RateLimitResult{RetryAfter: hits[0].Add(window).Sub(now)}, nil
+	}
+	e.hits = append(e.hits, now)
+	e.expiresAt = m.deadline(window)
+	return RateLimitResult{Allowed: true, Remaining: limit - int64(len(e.hits))}, nil
+}
+
+func (m *MemoryStore) GCRA(key string, limit int64, period time.Duration, burst int64) (RateLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	emission := gcraEmission(limit, period)
+	tolerance := emission * time.Duration(max(burst, 1))
+
+	e := m.entry(key)
+	tat := e.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	newTAT := tat.Add(emission)
+	allowAt := newTAT.Add(-tolerance)
+	if allowAt.After(now) {
+		return RateLimitResult{RetryAfter: allowAt.Sub(now)}, nil
+	}
+	e.tat = newTAT
+	e.expiresAt = newTAT
+	return RateLimitResult{Allowed: true, Remaining: int64(now.Sub(allowAt) / emission)}, nil
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisStore implements SessionStore on top of a shared Redis client.
+type RedisStore struct {
+	rdb *redis.Client
+}
+
+func NewRedisStore(rdb *redis.Client) *RedisStore {
+	return &RedisStore{rdb: rdb}
+}
+
+func (r *RedisStore) Set(key, value string, ttl time.Duration) error {
+	return r.rdb.Set(ctx, key, value, ttl).Err()
+}
+
+func (r *RedisStore) Get(key string) (string, error) {
+	v, err := r.rdb.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	return v, err
+}
+
+func (r *RedisStore) GetDel(key string) (string, error) {
+	v, err := r.rdb.GetDel(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	return v, err
+}
+
+// compareAndSetScript writes ARGV[2] only while the key holds ARGV[1] (absent
+// when ARGV[1] is empty); ARGV[3] is the TTL in milliseconds, 0 for none.
+var compareAndSetScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if ARGV[1] == '' then
+  if cur then return 0 end
+elseif cur ~= ARGV[1] then
+  return 0
+end
+if tonumber(ARGV[3]) > 0 then
+  redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+else
+  redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+func (r *RedisStore) CompareAndSet(key, old, value string, ttl time.Duration) (bool, error) {
+	ok, err := compareAndSetScript.Run(ctx, r.rdb, []string{key}, old, value, ttl.Milliseconds()).Int()
+	return ok == 1, err
+}
+
+func (r *RedisStore) Del(keys ...string) error {
+	return r.rdb.Del(ctx, keys...).Err()
+}
+
+func (r *RedisStore) Exists(key string) (bool, error) {
+	n, err := r.rdb.Exists(ctx, key).Result()
+	return n == 1, err
+}
+
+func (r *RedisStore) Expire(key string, ttl time.Duration) error {
+	return r.rdb.Expire(ctx, key, ttl).Err()
+}
+
+func (r *RedisStore) Incr(key string, window time.Duration) (int64, error) {
+	count, err := r.rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 && window > 0 {
+		_ = r.rdb.Expire(ctx, key, window).Err()
+	}
+	return count, nil
+}
+
+func (r *RedisStore) HSet(key string, fields map[string]string, ttl time.Duration) error {
+	values := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		values[k] = v
+	}
+	pipe := r.rdb.TxPipeline()
+	pipe.HSet(ctx, key, values)
+	if ttl > 0 {
+		pipe.Expire(ctx, key, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *RedisStore) HGetAll(key string) (map[string]string, error) {
+	return r.rdb.HGetAll(ctx, key).Result()
+}
+
+func (r *RedisStore) SAdd(key, member string, ttl time.Duration) error {
+	pipe := r.rdb.TxPipeline()
+	pipe.SAdd(ctx, key, member)
+	if ttl > 0 {
+		pipe.Expire(ctx, key, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *RedisStore) SRem(key, member string) error {
+	return r.rdb.SRem(ctx, key, member).Err()
+}
+
+func (r *RedisStore) SMembers(key string) ([]string, error) {
+	return r.rdb.SMembers(ctx, key).Result()
+}
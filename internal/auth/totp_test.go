@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestValidateTOTPRFC6238(t *testing.T) {
+	// RFC 6238 附录 B 的 SHA-1 测试向量，取低 6 位
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tc := range cases {
+		if _, ok := ValidateTOTP(secret, tc.code, time.Unix(tc.unix, 0)); !ok {
+			t.Errorf("code %s rejected at %d", tc.code, tc.unix)
+		}
+	}
+	if _, ok := ValidateTOTP(secret, "287082", time.Unix(59+3*totpPeriod, 0)); ok {
+		t.Error("code outside the skew window should be rejected")
+	}
+}
@@ -0,0 +1,165 @@
+package captcha
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"redbook/config"
+	"redbook/internal/auth"
+	"redbook/utils"
+)
+
+// 验证码类型
+const (
+	KindDigits = "digits" // 图片中的随机数字
+	KindMath   = "math"   // 图片中的算式，答案为计算结果
+)
+
+// 客户端在这两个请求头中提交已解答的验证码
+const (
+	HeaderID     = "X-Captcha-Id"
+	HeaderAnswer = "X-Captcha-Answer"
+)
+
+var (
+	ErrRequired = errors.New("captcha required")
+	ErrInvalid  = errors.New("captcha invalid or expired")
+)
+
+// Challenge is a generated CAPTCHA. The answer stays on the server.
+type Challenge struct {
+	ID        string `json:"captcha_id"`
+	Image     string `json:"image"` // data:image/png;base64,...
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+// Manager issues CAPTCHAs and checks answers against the session store. Every
+// challenge can be answered once, right or wrong.
+type Manager struct {
+	store auth.SessionStore
+	cfg   config.CaptchaConfig
+}
+
+// NewManager fills unset settings with defaults: digit challenges valid for
+// 2 minutes, and a 15-minute risk window with thresholds of 5 per IP and 3 per account.
+func NewManager(store auth.SessionStore, cfg config.CaptchaConfig) *Manager {
+	if cfg.Kind == "" {
+		cfg.Kind = KindDigits
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 120
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 900
+	}
+	if cfg.IPThreshold <= 0 {
+		cfg.IPThreshold = 5
+	}
+	if cfg.AccountThreshold <= 0 {
+		cfg.AccountThreshold = 3
+	}
+	return &Manager{store: store, cfg: cfg}
+}
+
+// Settings returns the effective configuration.
+func (m *Manager) Settings() config.CaptchaConfig {
+	return m.cfg
+}
+
+func captchaKey(id string) string {
+	return fmt.Sprintf("rb:captcha:%s", id)
+}
+
+// New generates a challenge and stores its answer.
+func (m *Manager) New() (*Challenge, error) {
+	text, answer, err := newPuzzle(m.cfg.Kind)
+	if err != nil {
+		return nil, err
+	}
+	data, err := render(text)
+	if err != nil {
+		return nil, err
+	}
+	id, err := utils.RandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	ttl := time.Duration(m.cfg.TTL) * time.Second
+	if err := m.store.Set(captchaKey(id), answer, ttl); err != nil {
+		return nil, err
+	}
+	return &Challenge{
+		ID:        id,
+		Image:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(data),
+		ExpiresIn: m.cfg.TTL,
+	}, nil
+}
+
+// Check consumes the challenge and compares the answer. It returns ErrRequired
+// when no challenge was submitted and ErrInvalid when it is wrong or unknown.
+func (m *Manager) Check(id, answer string) error {
+	if id == "" || answer == "" {
+		return ErrRequired
+	}
+	stored, err := m.store.GetDel(captchaKey(id))
+	if err != nil {
+		return ErrInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(strings.TrimSpace(answer))) != 1 {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// newPuzzle returns the text drawn on the image and the expected answer.
+func newPuzzle(kind string) (string, string, error) {
+	switch kind {
+	case KindMath:
+		a, err := randInt(10, 50)
+		if err != nil {
+			return "", "", err
+		}
+		b, err := randInt(1, 10)
+		if err != nil {
+			return "", "", err
+		}
+		op, err := randInt(0, 3)
+		if err != nil {
+			return "", "", err
+		}
+		switch op {
+		case 0:
+			return fmt.Sprintf("%d+%d=?", a, b), strconv.Itoa(a + b), nil
+		case 1:
+			return fmt.Sprintf("%d-%d=?", a, b), strconv.Itoa(a - b), nil
+		default:
+			return fmt.Sprintf("%dx%d=?", a%10, b), strconv.Itoa(a % 10 * b), nil
+		}
+	default:
+		var sb strings.Builder
+		for i := 0; i < 5; i++ {
+			d, err := randInt(0, 10)
+			if err != nil {
+				return "", "", err
+			}
+			sb.WriteByte(byte('0' + d))
+		}
+		return sb.String(), sb.String(), nil
+	}
+}
+
+// randInt returns a uniform integer in [lo, hi).
+func randInt(lo, hi int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(hi-lo)))
+	if err != nil {
+		return 0, err
+	}
+	return lo + int(n.Int64()), nil
+}
@@ -0,0 +1,86 @@
+package captcha
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"strconv"
+	"strings"
+	"testing"
+
+	"redbook/config"
+	"redbook/internal/auth"
+)
+
+func TestCheckConsumesChallenge(t *testing.T) {
+	store := auth.NewMemoryStore()
+	m := NewManager(store, config.CaptchaConfig{})
+	ch, err := m.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ch.Image, "data:image/png;base64,"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("image is not a png: %v", err)
+	}
+
+	answer, err := store.Get(captchaKey(ch.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check(ch.ID, " "+answer+" "); err != nil {
+		t.Fatalf("correct answer rejected: %v", err)
+	}
+	// 每个验证码只能使用一次
+	if err := m.Check(ch.ID, answer); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected replay to fail, got %v", err)
+	}
+	if err := m.Check("", ""); !errors.Is(err, ErrRequired) {
+		t.Fatalf("expected ErrRequired, got %v", err)
+	}
+}
+
+func TestMathPuzzleAnswer(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		text, answer, err := newPuzzle(KindMath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expr := strings.TrimSuffix(text, "=?")
+		var a, b, want int
+		switch {
+		case strings.Contains(expr, "+"):
+			a, b = split(t, expr, "+")
+			want = a + b
+		case strings.Contains(expr, "-"):
+			a, b = split(t, expr, "-")
+			want = a - b
+		default:
+			a, b = split(t, expr, "x")
+			want = a * b
+		}
+		if answer != strconv.Itoa(want) {
+			t.Fatalf("%s: answer %s, want %d", text, answer, want)
+		}
+		for _, r := range text {
+			if _, ok := glyphs[r]; !ok {
+				t.Fatalf("no glyph for %q", r)
+			}
+		}
+	}
+}
+
+func split(t *testing.T, expr, op string) (int, int) {
+	t.Helper()
+	l, r, _ := strings.Cut(expr, op)
+	a, err1 := strconv.Atoi(l)
+	b, err2 := strconv.Atoi(r)
+	if err1 != nil || err2 != nil {
+		t.Fatalf("malformed expression %q", expr)
+	}
+	return a, b
+}
@@ -0,0 +1,128 @@
+package captcha
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand/v2"
+)
+
+// glyphs is a 5x7 bitmap font covering the characters challenges use. Each row
+// is five bits, most significant bit on the left.
+var glyphs = map[rune][7]uint8{
+	'0': {0x0E, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0E},
+	'1': {0x04, 0x0C, 0x04, 0x04, 0x04, 0x04, 0x0E},
+	'2': {0x0E, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1F},
+	'3': {0x1F, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0E},
+	'4': {0x02, 0x06, 0x0A, 0x12, 0x1F, 0x02, 0x02},
+	'5': {0x1F, 0x10, 0x1E, 0x01, 0x01, 0x11, 0x0E},
+	'6': {0x06, 0x08, 0x10, 0x1E, 0x11, 0x11, 0x0E},
+	'7': {0x1F, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8': {0x0E, 0x11, 0x11, 0x0E, 0x11, 0x11, 0x0E},
+	'9': {0x0E, 0x11, 0x11, 0x0F, 0x01, 0x02, 0x0C},
+	'+': {0x00, 0x04, 0x04, 0x1F, 0x04, 0x04, 0x00},
+	'-': {0x00, 0x00, 0x00, 0x1F, 0x00, 0x00, 0x00},
+	'x': {0x00, 0x11, 0x0A, 0x04, 0x0A, 0x11, 0x00},
+	'=': {0x00, 0x00, 0x1F, 0x00, 0x1F, 0x00, 0x00},
+	'?': {0x0E, 0x11, 0x01, 0x02, 0x04, 0x00, 0x04},
+	' ': {},
+}
+
+const (
+	glyphScale = 4
+	glyphGap   = 6
+	padding    = 10
+	noiseLines = 6
+	noiseDots  = 120
+)
+
+// render draws text with per-glyph jitter, random ink colours and noise, and
+// returns it PNG-encoded.
+func render(text string) ([]byte, error) {
+	runes := []rune(text)
+	width := padding*2 + len(runes)*(5*glyphScale+glyphGap)
+	height := padding*2 + 7*glyphScale + 8
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	bg := color.RGBA{uint8(230 + rand.IntN(25)), uint8(230 + rand.IntN(25)), uint8(230 + rand.IntN(25)), 255}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, bg)
+		}
+	}
+
+	for i, r := range runes {
+		ink := randomInk()
+		x0 := padding + i*(5*glyphScale+glyphGap) + rand.IntN(3)
+		y0 := padding + rand.IntN(8)
+		for row, bits := range glyphs[r] {
+			for col := 0; col < 5; col++ {
+				if bits&(1<<(4-col)) == 0 {
+					continue
+				}
+				fillRect(img, x0+col*glyphScale, y0+row*glyphScale, glyphScale, ink)
+			}
+		}
+	}
+
+	for i := 0; i < noiseLines; i++ {
+		line(img, rand.IntN(width), rand.IntN(height), rand.IntN(width), rand.IntN(height), randomInk())
+	}
+	for i := 0; i < noiseDots; i++ {
+		img.Set(rand.IntN(width), rand.IntN(height), randomInk())
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func randomInk() color.RGBA {
+	return color.RGBA{uint8(rand.IntN(140)), uint8(rand.IntN(140)), uint8(rand.IntN(140)), 255}
+}
+
+func fillRect(img *image.RGBA, x, y, size int, c color.RGBA) {
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			img.SetRGBA(x+dx, y+dy, c)
+		}
+	}
+}
+
+// line draws a Bresenham line from (x0, y0) to (x1, y1).
+func line(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	err := dx + dy
+	for {
+		img.SetRGBA(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
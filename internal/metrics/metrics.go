@@ -25,6 +25,26 @@ var (
 		Name: "redbook_rate_limit_hits_total",
 		Help: "Rate limiter activations grouped by limiter name.",
 	}, []string{"limiter"})
+
+	smsCodes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redbook_sms_codes_total",
+		Help: "SMS verification code sends and checks grouped by purpose and status.",
+	}, []string{"purpose", "status"})
+
+	loginLockouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redbook_login_lockouts_total",
+		Help: "Per-account login throttling grouped by event (delayed, locked, blocked, unlocked).",
+	}, []string{"event"})
+
+	auditDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "redbook_audit_events_dropped_total",
+		Help: "Audit events dropped because the write queue was full.",
+	})
+
+	securityEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redbook_security_events_total",
+		Help: "Security-relevant events grouped by event type.",
+	}, []string{"event"})
 )
 
 // IncLogin increments the login counter.
@@ -46,3 +66,23 @@ func IncLogout(status string) {
 func IncRateLimit(name string) {
 	rateLimitHits.WithLabelValues(name).Inc()
 }
+
+// IncSMS increments the SMS code counter.
+func IncSMS(purpose, status string) {
+	smsCodes.WithLabelValues(purpose, status).Inc()
+}
+
+// IncLockout increments the per-account lockout counter.
+func IncLockout(event string) {
+	loginLockouts.WithLabelValues(event).Inc()
+}
+
+// IncSecurityEvent increments the security event counter.
+func IncSecurityEvent(event string) {
+	securityEvents.WithLabelValues(event).Inc()
+}
+
+// IncAuditDropped counts an audit event dropped on a full queue.
+func IncAuditDropped() {
+	auditDropped.Inc()
+}
@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"fmt"
+	"log"
+
+	"redbook/config"
+	"redbook/internal/sms"
+	"redbook/model"
+)
+
+// Notifier delivers a security notice to a user.
+type Notifier interface {
+	Notify(user *model.User, message string) error
+}
+
+// NewNotifier builds the notifier selected in config. Email or push channels
+// plug in behind the interface.
+func NewNotifier(cfg config.LoginRiskConfig, provider sms.SMSProvider) (Notifier, error) {
+	switch cfg.Notifier {
+	case "", "log":
+		return LogNotifier{}, nil
+	case "sms":
+		return SMSNotifier{provider: provider}, nil
+	default:
+		return nil, fmt.Errorf("unsupported notifier %q", cfg.Notifier)
+	}
+}
+
+// LogNotifier writes notices to the process log, for development.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(user *model.User, message string) error {
+	log.Printf("[notify] user=%d msg=%s", user.ID, message)
+	return nil
+}
+
+// SMSNotifier texts notices to the user's verified mobile. Users without one
+// are skipped.
+type SMSNotifier struct {
+	provider sms.SMSProvider
+}
+
+func (n SMSNotifier) Notify(user *model.User, message string) error {
+	if !user.MobileVerified {
+		return nil
+	}
+	return n.provider.Send(user.Mobile, "【Redbook】"+message)
+}
@@ -0,0 +1,54 @@
+package sms
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"redbook/config"
+)
+
+// SMSProvider delivers a text message to a mobile number.
+type SMSProvider interface {
+	Send(mobile, message string) error
+}
+
+// NewProvider builds the provider selected in config. Only the local "log"
+// provider ships with the service; real gateways plug in behind the interface.
+func NewProvider(cfg config.SMSConfig) (SMSProvider, error) {
+	switch cfg.Provider {
+	case "", "log":
+		return NewLogProvider(cfg.LogFile), nil
+	default:
+		return nil, fmt.Errorf("unsupported sms provider %q", cfg.Provider)
+	}
+}
+
+// LogProvider is a fake gateway for development: messages go to the process
+// log, or are appended to a file when a path is configured.
+type LogProvider struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewLogProvider(path string) *LogProvider {
+	return &LogProvider{path: path}
+}
+
+func (p *LogProvider) Send(mobile, message string) error {
+	if p.path == "" {
+		log.Printf("[sms] to=%s msg=%s", mobile, message)
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, err := os.OpenFile(p.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), mobile, message)
+	return err
+}
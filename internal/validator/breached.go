@@ -0,0 +1,72 @@
+package validator
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"slices"
+	"strings"
+)
+
+// BreachedSet is an offline corpus of leaked passwords. Only the first 8 bytes
+// of each SHA-1 digest are kept in a sorted slice, so ten million entries take
+// about 80 MB and a lookup is a binary search. The truncation yields false
+// positives with probability n/2^64, which is negligible.
+type BreachedSet struct {
+	prefixes []uint64
+}
+
+// LoadBreachedSet reads a corpus file. Each line is either a plaintext password
+// or a hex SHA-1 digest optionally followed by ":count" (the HIBP download format).
+func LoadBreachedSet(path string) (*BreachedSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var prefixes []uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if digest, ok := parseSHA1Line(line); ok {
+			prefixes = append(prefixes, binary.BigEndian.Uint64(digest))
+			continue
+		}
+		prefixes = append(prefixes, breachedPrefix(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	slices.Sort(prefixes)
+	return &BreachedSet{prefixes: slices.Compact(prefixes)}, nil
+}
+
+// Len returns the number of distinct entries.
+func (b *BreachedSet) Len() int {
+	return len(b.prefixes)
+}
+
+func (b *BreachedSet) Contains(password string) bool {
+	_, found := slices.BinarySearch(b.prefixes, breachedPrefix(password))
+	return found
+}
+
+func breachedPrefix(password string) uint64 {
+	sum := sha1.Sum([]byte(password))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func parseSHA1Line(line string) ([]byte, bool) {
+	digest, _, _ := strings.Cut(line, ":")
+	if len(digest) != sha1.Size*2 {
+		return nil, false
+	}
+	b, err := hex.DecodeString(digest)
+	return b, err == nil
+}
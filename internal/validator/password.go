@@ -0,0 +1,111 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"redbook/config"
+)
+
+// PasswordViolation is one rule a password failed, with a stable code for
+// clients and a human-readable message.
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every rule a password failed.
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Message
+	}
+	return "password does not meet policy: " + strings.Join(msgs, "; ")
+}
+
+// PasswordPolicy checks new passwords at registration, change and reset.
+type PasswordPolicy struct {
+	cfg config.PasswordPolicyConfig
+	// Breached 泄露密码库，为 nil 时跳过该项检查
+	Breached *BreachedSet
+}
+
+// NewPasswordPolicy fills unset limits with defaults: 8 to 128 characters and
+// at least 3 of the 4 character classes.
+func NewPasswordPolicy(cfg config.PasswordPolicyConfig) *PasswordPolicy {
+	if cfg.MinLength <= 0 {
+		cfg.MinLength = 8
+	}
+	if cfg.MaxLength <= 0 {
+		cfg.MaxLength = 128
+	}
+	if cfg.MinClasses <= 0 {
+		cfg.MinClasses = 3
+	}
+	return &PasswordPolicy{cfg: cfg}
+}
+
+// Check validates password against the policy. personal holds values the
+// password must not contain, such as the username and mobile.
+func (p *PasswordPolicy) Check(password string, personal ...string) error {
+	var violations []PasswordViolation
+	add := func(code, format string, args ...any) {
+		violations = append(violations, PasswordViolation{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := len([]rune(password))
+	if length < p.cfg.MinLength {
+		add("too_short", "must be at least %d characters", p.cfg.MinLength)
+	}
+	if length > p.cfg.MaxLength {
+		add("too_long", "must be at most %d characters", p.cfg.MaxLength)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < p.cfg.MinClasses {
+		add("too_simple", "must mix at least %d of lowercase, uppercase, digits and symbols", p.cfg.MinClasses)
+	}
+
+	if !p.cfg.AllowPersonalInfo {
+		lowered := strings.ToLower(password)
+		for _, v := range personal {
+			// 过短的值（如单字符用户名）误伤太多，不做包含判断
+			if len([]rune(v)) >= 3 && strings.Contains(lowered, strings.ToLower(v)) {
+				add("contains_personal_info", "must not contain your username or mobile")
+				break
+			}
+		}
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		add("breached", "appears in a list of leaked passwords, choose another one")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
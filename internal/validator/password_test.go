@@ -0,0 +1,75 @@
+package validator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"redbook/config"
+)
+
+func violationCodes(err error) []string {
+	policyErr, ok := err.(*PasswordPolicyError)
+	if !ok {
+		return nil
+	}
+	codes := make([]string, len(policyErr.Violations))
+	for i, v := range policyErr.Violations {
+		codes[i] = v.Code
+	}
+	return codes
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	p := NewPasswordPolicy(config.PasswordPolicyConfig{})
+	cases := []struct {
+		password string
+		want     []string
+	}{
+		{"Passw0rd!", nil},
+		{"abc", []string{"too_short", "too_simple"}},
+		{"alllowercase", []string{"too_simple"}},
+		{"Alice#2024xy", []string{"contains_personal_info"}},
+		{"Pw13812345678", []string{"contains_personal_info"}},
+	}
+	for _, tc := range cases {
+		got := violationCodes(p.Check(tc.password, "alice", "13812345678"))
+		if len(got) != len(tc.want) {
+			t.Errorf("%q: got %v, want %v", tc.password, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%q: got %v, want %v", tc.password, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestBreachedSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	// 明文与 HIBP 格式混合："Passw0rd!" 的 SHA-1 带计数
+	corpus := "Summer2024!\r\nF4A69973E7B0BF9D160F9F60E3C3ACD2494BEB0D:3\n\nSummer2024!\n"
+	if err := os.WriteFile(path, []byte(corpus), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	set, err := LoadBreachedSet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Len() != 2 {
+		t.Fatalf("expected 2 distinct entries, got %d", set.Len())
+	}
+
+	p := NewPasswordPolicy(config.PasswordPolicyConfig{})
+	p.Breached = set
+	if codes := violationCodes(p.Check("Summer2024!")); len(codes) != 1 || codes[0] != "breached" {
+		t.Fatalf("expected breached violation, got %v", codes)
+	}
+	if !set.Contains("Passw0rd!") {
+		t.Fatal("hash lines should match their plaintext")
+	}
+	if err := p.Check("Winter2024!"); err != nil {
+		t.Fatalf("unexpected violation: %v", err)
+	}
+}
@@ -1,44 +1,107 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
-	"redbook/internal/auth" // 导入 auth 包以便使用 ParseToken
+	"redbook/internal/auth"
+	"redbook/internal/metrics"
+	"redbook/model"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware 验证 token 是否有效
-func AuthMiddleware(session *auth.SessionManager) gin.HandlerFunc {
+// TokenValidator checks a JWT access token: blacklist, signature and expiry,
+// family revocation and token version. service.UserService implements it.
+// Failures wrapping auth.ErrUnavailable are answered with 500, others with 401.
+type TokenValidator interface {
+	ValidateAccessToken(token string) (*auth.Claims, error)
+}
+
+// AccessTokenValidator resolves a personal access token and records its use.
+// service.AccessTokenService implements it.
+type AccessTokenValidator interface {
+	Validate(token, ip string) (*model.PersonalAccessToken, error)
+}
+
+// AuthMiddleware 验证 token 是否有效，同时接受 JWT 与个人访问 token。
+// 绑定了 DPoP 公钥的 JWT 须以 DPoP 方案提交，并附带对本次请求的签名证明。
+func AuthMiddleware(users TokenValidator, tokens AccessTokenValidator, verifier *auth.DPoPVerifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if !strings.HasPrefix(authHeader, "Bearer ") {
+		token, dpop, ok := auth.AuthorizationToken(c.GetHeader("Authorization"))
+		if !ok {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
 			c.Abort()
 			return
 		}
 
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-
-		// 检查 token 是否在黑名单
-		in, _ := session.InBlackList(token)
-		if in {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "token invalid"})
-			c.Abort()
+		// 个人访问 token 按 OAuth token 对待：只能访问按 scope 授权的接口
+		if !dpop && strings.HasPrefix(token, auth.AccessTokenPrefix) {
+			pat, err := tokens.Validate(token, c.ClientIP())
+			if err != nil {
+				AbortTokenError(c, err)
+				return
+			}
+			c.Set("user_id", uint(pat.UserID))
+			c.Set("device", "pat:"+strconv.FormatUint(pat.ID, 10))
+			c.Set("client_id", auth.AccessTokenClientID)
+			c.Set("scopes", strings.Fields(pat.Scopes))
+			c.Next()
 			return
 		}
 
-		// 解析 token
-		claims, err := auth.ParseToken(token) // 使用 auth 包中的 ParseToken
+		// 黑名单、签名/过期、token 家族与 token 版本统一由 service 校验
+		claims, err := users.ValidateAccessToken(token)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
-			c.Abort()
+			AbortTokenError(c, err)
 			return
 		}
 
+		if jkt := claims.BoundKey(); jkt != "" || dpop {
+			if jkt == "" || !dpop {
+				c.Header("WWW-Authenticate", `DPoP error="invalid_token"`)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token must be presented with the DPoP scheme it was issued for"})
+				return
+			}
+			proven, err := verifier.Verify(c.GetHeader(auth.DPoPHeader), c.Request, token)
+			if err == nil && proven != jkt {
+				err = auth.ErrDPoPBinding
+			}
+			if err != nil {
+				if errors.Is(err, auth.ErrDPoPNonce) {
+					respondDPoPError(c, verifier, err)
+					return
+				}
+				metrics.IncSecurityEvent("dpop_rejected")
+				c.Header("WWW-Authenticate", `DPoP error="invalid_dpop_proof"`)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
 		// 将用户信息写入上下文
 		c.Set("user_id", claims.UserID)
 		c.Set("device", claims.Device)
+		c.Set("client_id", claims.ClientID)
+		c.Set("scopes", strings.Fields(claims.Scope))
 		c.Next()
 	}
 }
+
+// AbortTokenError rejects a request whose token failed validation: backend
+// failures are a 500, so clients do not take them for a sign-out; everything
+// else is a 401 carrying the error's code (signed_in_elsewhere,
+// session_expired, ...) if any. Handlers that validate tokens themselves,
+// such as refresh and logout, answer through it too.
+func AbortTokenError(c *gin.Context, err error) {
+	if errors.Is(err, auth.ErrUnavailable) {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "token validation unavailable"})
+		return
+	}
+	body := gin.H{"error": err.Error()}
+	if code := auth.ErrorCode(err); code != "" {
+		body["code"] = code
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, body)
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"redbook/internal/auth"
+	"redbook/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+type stubValidator struct {
+	claims *auth.Claims
+	err    error
+}
+
+func (v stubValidator) ValidateAccessToken(string) (*auth.Claims, error) {
+	return v.claims, v.err
+}
+
+type stubAccessTokens struct{}
+
+func (stubAccessTokens) Validate(string, string) (*model.PersonalAccessToken, error) {
+	return nil, errors.New("unexpected personal access token")
+}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func serveAuth(t *testing.T, users TokenValidator, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	r := gin.New()
+	chain := append([]gin.HandlerFunc{AuthMiddleware(users, stubAccessTokens{}, nil)}, handlers...)
+	chain = append(chain, func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	r.GET("/", chain...)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthMiddlewareMapsValidationErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"revoked", errors.New("token revoked"), http.StatusUnauthorized, ""},
+		{"coded", &auth.Error{Code: "signed_in_elsewhere", Message: "signed in elsewhere"}, http.StatusUnauthorized, "signed_in_elsewhere"},
+		{"session expired", &auth.Error{Code: "session_expired", Message: "session expired: sign in again"}, http.StatusUnauthorized, "session_expired"},
+		{"backend", fmt.Errorf("token version lookup failed: %w", auth.ErrUnavailable), http.StatusInternalServerError, ""},
+	}
+	for _, tc := range cases {
+		w := serveAuth(t, stubValidator{err: tc.err})
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.status)
+		}
+		if tc.code != "" && !strings.Contains(w.Body.String(), `"code":"`+tc.code+`"`) {
+			t.Errorf("%s: body %s lacks code %s", tc.name, w.Body.String(), tc.code)
+		}
+	}
+
+	if w := serveAuth(t, stubValidator{claims: &auth.Claims{UserID: 1, Device: "phone"}}); w.Code != http.StatusNoContent {
+		t.Fatalf("valid token rejected: %d %s", w.Code, w.Body.String())
+	}
+}
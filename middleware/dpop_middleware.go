@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"redbook/internal/auth"
+	"redbook/internal/metrics"
+)
+
+// DPoP verifies the optional DPoP proof on token endpoints (login, refresh,
+// OAuth token). A valid proof puts its key thumbprint in the context as
+// "dpop_jkt" and the issued tokens are bound to that key.
+func DPoP(verifier *auth.DPoPVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := verifier.Settings()
+		proof := c.GetHeader(auth.DPoPHeader)
+		if proof == "" || !cfg.Enabled {
+			if cfg.Enabled && cfg.Required {
+				respondDPoPError(c, verifier, auth.ErrDPoPInvalid)
+				return
+			}
+			c.Next()
+			return
+		}
+		jkt, err := verifier.Verify(proof, c.Request, "")
+		if err != nil {
+			respondDPoPError(c, verifier, err)
+			return
+		}
+		c.Set("dpop_jkt", jkt)
+		c.Next()
+	}
+}
+
+// respondDPoPError 拒绝证明无效的请求；缺少 nonce 时下发新的 DPoP-Nonce 供客户端重试。
+func respondDPoPError(c *gin.Context, verifier *auth.DPoPVerifier, err error) {
+	metrics.IncSecurityEvent("dpop_rejected")
+	if errors.Is(err, auth.ErrDPoPNonce) {
+		if nonce, nerr := verifier.NewNonce(); nerr == nil {
+			c.Header(auth.DPoPNonceHeader, nonce)
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "use_dpop_nonce", "error_description": "retry with the DPoP-Nonce header value"})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_dpop_proof", "error_description": err.Error()})
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// NewEngine returns a gin engine with the default logger and recovery
+// middleware whose ClientIP only honours X-Forwarded-For / X-Real-IP when the
+// direct peer is one of trustedProxies. With no trusted proxies the socket
+// address is used, so clients cannot pick the IP that rate limits, CAPTCHA
+// thresholds and login risk checks are keyed on.
+func NewEngine(trustedProxies []string) (*gin.Engine, error) {
+	r := gin.New()
+	r.Use(gin.Logger(), gin.Recovery())
+	// gin 默认信任所有代理，必须显式收窄；nil 表示不信任任何代理
+	if len(trustedProxies) == 0 {
+		trustedProxies = nil
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PermissionChecker resolves whether a user holds a permission; implemented by
+// service.RBACService.
+type PermissionChecker interface {
+	HasPermission(userID uint, perm string) (bool, error)
+}
+
+// RequirePermission 要求当前用户通过其角色拥有指定权限，需放在 AuthMiddleware 之后。
+func RequirePermission(rbac PermissionChecker, perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, err := rbac.HasPermission(c.GetUint("user_id"), perm)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "permission check failed"})
+			return
+		}
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied", "permission": perm})
+			return
+		}
+		c.Next()
+	}
+}
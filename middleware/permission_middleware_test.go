@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type stubPermissions struct {
+	granted map[uint][]string
+	err     error
+}
+
+func (p stubPermissions) HasPermission(userID uint, perm string) (bool, error) {
+	for _, g := range p.granted[userID] {
+		if g == perm {
+			return true, nil
+		}
+	}
+	return false, p.err
+}
+
+func servePermission(checker PermissionChecker, userID uint, perm string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.GET("/", func(c *gin.Context) { c.Set("user_id", userID) }, RequirePermission(checker, perm),
+		func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w
+}
+
+func TestRequirePermission(t *testing.T) {
+	checker := stubPermissions{granted: map[uint][]string{1: {"users:unlock"}}}
+
+	if w := servePermission(checker, 1, "users:unlock"); w.Code != http.StatusNoContent {
+		t.Fatalf("granted permission: %d %s", w.Code, w.Body.String())
+	}
+	w := servePermission(checker, 2, "users:unlock")
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"permission":"users:unlock"`) {
+		t.Fatalf("missing permission: %d %s", w.Code, w.Body.String())
+	}
+	if w := servePermission(stubPermissions{err: errors.New("db down")}, 1, "users:unlock"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("lookup failure: %d", w.Code)
+	}
+}
@@ -1,42 +1,168 @@
 package middleware
 
 import (
-	"context"
 	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/redis/v8"
 
+	"redbook/config"
+	"redbook/internal/audit"
+	"redbook/internal/auth"
+	"redbook/internal/captcha"
 	"redbook/internal/metrics"
+	"redbook/model"
 )
 
-var rlCtx = context.Background()
+// RateLimit applies the named policy from config.rate_limits. Routes are wired
+// at startup, so a policy missing from config or keyed on an unknown dimension
+// panics there instead of leaving the route unlimited; only an explicit zero
+// limit disables limiting. Policies keyed by user or device must run after
+// AuthMiddleware; before it they fall back to the client IP.
+func RateLimit(store auth.SessionStore, name string) gin.HandlerFunc {
+	policy, ok := config.GlobalConfig.RateLimits[name]
+	if !ok {
+		panic(fmt.Sprintf("rate limit policy %q not configured", name))
+	}
+	if err := checkLimitKey(policy.Key); err != nil {
+		panic(fmt.Sprintf("rate limit policy %q: %v", name, err))
+	}
+	if policy.Limit <= 0 {
+		log.Printf("rate limit policy %q has no limit, limiting disabled", name)
+		return func(c *gin.Context) { c.Next() }
+	}
+	return RateLimitWith(store, name, policy)
+}
+
+// RateLimitWith builds a limiter from an explicit policy. With policy.Captcha
+// set, a request over the limit still passes when it carries a solved CAPTCHA;
+// without one (or with a wrong or replayed answer) it gets 429 with
+// captcha_required: true, telling the client a CAPTCHA lets it through.
+func RateLimitWith(store auth.SessionStore, name string, policy config.RateLimitPolicy) gin.HandlerFunc {
+	window := time.Duration(policy.Window) * time.Second
+	if window <= 0 {
+		window = time.Minute
+	}
+	burst := policy.Burst
+	if burst <= 0 {
+		burst = policy.Limit
+	}
+	var captchas *captcha.Manager
+	if policy.Captcha {
+		captchas = captcha.NewManager(store, config.GlobalConfig.Captcha)
+	}
 
-// LoginRateLimiter limits login attempts per client IP using Redis counters.
-func LoginRateLimiter(rdb *redis.Client, limit int64, window time.Duration) gin.HandlerFunc {
-	const limiterName = "login"
 	return func(c *gin.Context) {
-		ip := c.ClientIP()
-		key := fmt.Sprintf("rb:rl:%s:%s", limiterName, ip)
+		key := "rb:rl:" + name + ":" + limitKey(c, policy.Key)
 
-		count, err := rdb.Incr(rlCtx, key).Result()
+		var (
+			res auth.RateLimitResult
+			err error
+		)
+		switch policy.Algorithm {
+		case config.RateLimitSlidingWindow:
+			res, err = store.SlidingWindow(key, policy.Limit, window)
+		case config.RateLimitTokenBucket:
+			res, err = store.GCRA(key, policy.Limit, window, burst)
+		default:
+			res, err = fixedWindow(store, key, policy.Limit, window)
+		}
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "rate limiter failed"})
 			return
 		}
-		if count == 1 {
-			_ = rdb.Expire(rlCtx, key, window).Err()
-		}
-		if count > limit {
-			metrics.IncRateLimit(limiterName)
-			c.Header("Retry-After", fmt.Sprintf("%.f", window.Seconds()))
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many login attempts"})
+
+		c.Header("X-RateLimit-Limit", strconv.FormatInt(policy.Limit, 10))
+		c.Header("X-RateLimit-Remaining", strconv.FormatInt(max(res.Remaining, 0), 10))
+		if !res.Allowed {
+			metrics.IncRateLimit(name)
+			audit.Record(model.AuditEvent{
+				Event:     audit.EventRateLimit,
+				UserID:    uint64(c.GetUint("user_id")),
+				Device:    c.GetHeader("X-Device"),
+				IP:        c.ClientIP(),
+				UserAgent: c.Request.UserAgent(),
+				Result:    audit.ResultFailure,
+				Reason:    name + ": " + c.Request.Method + " " + c.FullPath(),
+			})
+			c.Header("Retry-After", fmt.Sprintf("%.f", math.Ceil(res.RetryAfter.Seconds())))
+			if captchas != nil {
+				err := captchas.Check(c.GetHeader(captcha.HeaderID), c.GetHeader(captcha.HeaderAnswer))
+				if err == nil {
+					// 下游登录 / 注册无需再次校验同一个验证码
+					c.Set("captcha_passed", true)
+					c.Next()
+					return
+				}
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "captcha_required": true})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
 			return
 		}
-		c.Header("X-RateLimit-Limit", fmt.Sprintf("%d", limit))
-		c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", limit-count))
 		c.Next()
 	}
 }
+
+// fixedWindow 是最初的 INCR/EXPIRE 计数器，窗口内无法得知精确的剩余时间。
+func fixedWindow(store auth.SessionStore, key string, limit int64, window time.Duration) (auth.RateLimitResult, error) {
+	count, err := store.Incr(key, window)
+	if err != nil {
+		return auth.RateLimitResult{}, err
+	}
+	if count > limit {
+		return auth.RateLimitResult{RetryAfter: window}, nil
+	}
+	return auth.RateLimitResult{Allowed: true, Remaining: limit - count}, nil
+}
+
+// limitDimensions 为可用的限流维度。只取服务端可信的值：客户端 IP 与通过鉴权的
+// 用户 / 设备会话，绝不读取 X-Device 等客户端自报的请求头，否则换个请求头即可绕过限流。
+// 客户端 IP 可信的前提是引擎由 NewEngine 创建：只有 trusted_proxies 转发的请求才采信
+// X-Forwarded-For。
+var limitDimensions = []string{"ip", "user", "device", "route"}
+
+func checkLimitKey(parts []string) error {
+	for _, part := range parts {
+		if !slices.Contains(limitDimensions, part) {
+			return fmt.Errorf("unknown key dimension %q (want one of %v)", part, limitDimensions)
+		}
+	}
+	return nil
+}
+
+// limitKey 按策略中的维度拼出限流 key。
+func limitKey(c *gin.Context, parts []string) string {
+	if len(parts) == 0 {
+		parts = []string{"ip"}
+	}
+	values := make([]string, 0, len(parts))
+	for _, part := range parts {
+		switch part {
+		case "user":
+			if uid := c.GetUint("user_id"); uid != 0 {
+				values = append(values, "u"+strconv.FormatUint(uint64(uid), 10))
+			} else {
+				values = append(values, c.ClientIP())
+			}
+		case "device":
+			// 仅 AuthMiddleware 从 token 中取出的设备可信，鉴权前退回 IP
+			if uid, device := c.GetUint("user_id"), c.GetString("device"); uid != 0 && device != "" {
+				values = append(values, "u"+strconv.FormatUint(uint64(uid), 10)+"/"+device)
+			} else {
+				values = append(values, c.ClientIP())
+			}
+		case "route":
+			values = append(values, c.Request.Method+" "+c.FullPath())
+		default:
+			values = append(values, c.ClientIP())
+		}
+	}
+	return strings.Join(values, ":")
+}
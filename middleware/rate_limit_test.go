@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"redbook/config"
+	"redbook/internal/auth"
+	"redbook/internal/captcha"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveLimited 依次发送请求，每个请求可附加请求头，返回各自的状态码。
+func serveLimited(limiter gin.HandlerFunc, headers ...map[string]string) []int {
+	return serveLimitedOn(gin.New(), limiter, headers...)
+}
+
+// serveLimitedOn 同 serveLimited，但使用给定的引擎，用于检查代理头的处理。
+func serveLimitedOn(r *gin.Engine, limiter gin.HandlerFunc, headers ...map[string]string) []int {
+	r.POST("/", limiter, func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	codes := make([]int, 0, len(headers))
+	for _, h := range headers {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		for k, v := range h {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+	return codes
+}
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	config.GlobalConfig = &config.Config{}
+	policy := config.RateLimitPolicy{Limit: 2, Window: 60, Key: []string{"ip"}}
+	spoofed := []map[string]string{
+		{"X-Forwarded-For": "1.1.1.1"},
+		{"X-Forwarded-For": "2.2.2.2", "X-Real-IP": "2.2.2.2"},
+		{"X-Forwarded-For": "3.3.3.3"},
+	}
+
+	// 未配置可信代理：伪造的 X-Forwarded-For 不改变限流 key
+	r, err := NewEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := serveLimitedOn(r, RateLimitWith(auth.NewMemoryStore(), "login", policy), spoofed...)
+	if codes[2] != http.StatusTooManyRequests {
+		t.Fatalf("statuses = %v, spoofed X-Forwarded-For bypassed the ip limit", codes)
+	}
+
+	// 对端是可信代理时才采信其转发的客户端地址
+	r, err = NewEngine([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes = serveLimitedOn(r, RateLimitWith(auth.NewMemoryStore(), "login", policy), spoofed...)
+	for i, code := range codes {
+		if code != http.StatusNoContent {
+			t.Fatalf("request %d via trusted proxy = %d, clients behind it share one limit", i+1, code)
+		}
+	}
+}
+
+func TestRateLimitIgnoresDeviceHeader(t *testing.T) {
+	config.GlobalConfig = &config.Config{}
+	policy := config.RateLimitPolicy{Limit: 2, Window: 60, Key: []string{"ip", "device"}}
+	limiter := RateLimitWith(auth.NewMemoryStore(), "refresh", policy)
+
+	// 每次换一个 X-Device 也不能拿到新的配额
+	codes := serveLimited(limiter, map[string]string{"X-Device": "a"}, map[string]string{"X-Device": "b"}, map[string]string{"X-Device": "c"})
+	if codes[2] != http.StatusTooManyRequests {
+		t.Fatalf("statuses = %v, third request should be limited", codes)
+	}
+}
+
+func TestRateLimitRequiresConfiguredPolicy(t *testing.T) {
+	config.GlobalConfig = &config.Config{RateLimits: map[string]config.RateLimitPolicy{
+		"bad_key": {Limit: 1, Key: []string{"ip", "header"}},
+		"off":     {Limit: 0},
+	}}
+	for _, name := range []string{"missing", "bad_key"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("policy %q should fail at startup", name)
+				}
+			}()
+			RateLimit(auth.NewMemoryStore(), name)
+		}()
+	}
+	// 显式 limit: 0 仍表示不限流
+	codes := serveLimited(RateLimit(auth.NewMemoryStore(), "off"), nil, nil)
+	if codes[1] != http.StatusNoContent {
+		t.Fatalf("statuses = %v for a disabled policy", codes)
+	}
+}
+
+func TestRateLimitCaptchaBranch(t *testing.T) {
+	config.GlobalConfig = &config.Config{}
+	store := auth.NewMemoryStore()
+	limiter := RateLimitWith(store, "login", config.RateLimitPolicy{Limit: 1, Window: 60, Captcha: true})
+	captchas := captcha.NewManager(store, config.CaptchaConfig{})
+
+	// solve 生成一个验证码并从存储中取出答案
+	solve := func() (string, string) {
+		ch, err := captchas.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		answer, err := store.Get("rb:captcha:" + ch.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ch.ID, answer
+	}
+	r := gin.New()
+	r.POST("/", limiter, func(c *gin.Context) {
+		if !c.GetBool("captcha_passed") {
+			t.Error("request let through without captcha_passed")
+		}
+		c.Status(http.StatusNoContent)
+	})
+	send := func(id, answer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if id != "" {
+			req.Header.Set(captcha.HeaderID, id)
+			req.Header.Set(captcha.HeaderAnswer, answer)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	// 用掉唯一的配额
+	if codes := serveLimited(limiter, nil); codes[0] != http.StatusNoContent {
+		t.Fatalf("first request: %d", codes[0])
+	}
+
+	id, answer := solve()
+	if w := send(id, answer); w.Code != http.StatusNoContent {
+		t.Fatalf("solved captcha: %d %s", w.Code, w.Body.String())
+	}
+	wrongID, _ := solve()
+	cases := []struct {
+		name       string
+		id, answer string
+	}{
+		{"missing", "", ""},
+		{"wrong answer", wrongID, "not-the-answer"},
+		{"replayed", id, answer},
+	}
+	for _, tc := range cases {
+		w := send(tc.id, tc.answer)
+		if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), `"captcha_required":true`) {
+			t.Errorf("%s: %d %s, want 429 with captcha_required", tc.name, w.Code, w.Body.String())
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: missing Retry-After", tc.name)
+		}
+	}
+}
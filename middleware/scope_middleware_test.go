@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"redbook/internal/auth"
+	"redbook/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+type stubPAT struct {
+	token *model.PersonalAccessToken
+}
+
+func (p stubPAT) Validate(string, string) (*model.PersonalAccessToken, error) {
+	return p.token, nil
+}
+
+// serveScoped 以给定凭据访问挂了 guard 的路由，返回状态码。
+func serveScoped(users TokenValidator, tokens AccessTokenValidator, token string, guard gin.HandlerFunc) int {
+	r := gin.New()
+	r.GET("/", AuthMiddleware(users, tokens, nil), guard, func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestPersonalAccessTokenScopes(t *testing.T) {
+	pat := stubPAT{token: &model.PersonalAccessToken{ID: 3, UserID: 1, Scopes: "profile"}}
+	token := auth.AccessTokenPrefix + "secret"
+
+	if code := serveScoped(nil, pat, token, RequireScope("profile")); code != http.StatusNoContent {
+		t.Fatalf("granted scope: %d", code)
+	}
+	if code := serveScoped(nil, pat, token, RequireScope("account:read")); code != http.StatusForbidden {
+		t.Fatalf("missing scope: %d", code)
+	}
+	// 账号管理接口不接受个人访问 token
+	if code := serveScoped(nil, pat, token, FirstPartyOnly()); code != http.StatusForbidden {
+		t.Fatalf("first-party route: %d", code)
+	}
+}
+
+func TestDirectLoginTokenSkipsScopes(t *testing.T) {
+	users := stubValidator{claims: &auth.Claims{UserID: 1, Device: "phone"}}
+	for _, guard := range []gin.HandlerFunc{RequireScope("account:read"), FirstPartyOnly()} {
+		if code := serveScoped(users, stubAccessTokens{}, "jwt", guard); code != http.StatusNoContent {
+			t.Fatalf("direct login token rejected: %d", code)
+		}
+	}
+	oauth := stubValidator{claims: &auth.Claims{UserID: 1, ClientID: "rbc_app", Scope: "profile"}}
+	if code := serveScoped(oauth, stubAccessTokens{}, "jwt", RequireScope("account:read")); code != http.StatusForbidden {
+		t.Fatalf("oauth token without scope: %d", code)
+	}
+	if code := serveScoped(oauth, stubAccessTokens{}, "jwt", FirstPartyOnly()); code != http.StatusForbidden {
+		t.Fatalf("oauth token on a first-party route: %d", code)
+	}
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// PersonalAccessToken 用户为脚本 / 集成创建的长期 token，仅保存哈希
+type PersonalAccessToken struct {
+	ID         uint64     `gorm:"primarykey" json:"id"`
+	UserID     uint64     `gorm:"not null;index" json:"-"`
+	Name       string     `gorm:"not null;size:100" json:"name"`
+	TokenHash  string     `gorm:"unique;not null;size:64" json:"-"`
+	Prefix     string     `gorm:"not null;size:16" json:"prefix"` // token 开头几位，便于用户辨认
+	Scopes     string     `gorm:"size:255" json:"scopes"`         // 空格分隔
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `gorm:"size:64" json:"last_used_ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// TokenVersion 创建时用户的 token 版本；全端登出、找回密码递增版本后该 token 随之失效
+	TokenVersion int64 `gorm:"not null;default:0" json:"-"`
+}
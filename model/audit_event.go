@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// AuditEvent 安全审计事件，只追加不修改
+type AuditEvent struct {
+	ID uint64 `gorm:"primarykey" json:"id"`
+	// UserID 事件涉及的账号，登录时账号不存在则为 0
+	UserID uint64 `gorm:"index:idx_audit_user_time,priority:1" json:"user_id"`
+	// ActorID 代为操作的管理员，用户本人操作时为 0
+	ActorID    uint64    `json:"actor_id,omitempty"`
+	Event      string    `gorm:"not null;size:32;index" json:"event"`
+	Identifier string    `gorm:"size:100" json:"identifier,omitempty"` // 登录时提交的用户名 / 手机号
+	Device     string    `gorm:"size:100" json:"device"`
+	IP         string    `gorm:"size:64;index" json:"ip"`
+	UserAgent  string    `gorm:"size:255" json:"user_agent"`
+	Result     string    `gorm:"not null;size:16" json:"result"`
+	Reason     string    `gorm:"size:255" json:"reason,omitempty"`
+	CreatedAt  time.Time `gorm:"index:idx_audit_user_time,priority:2;index" json:"created_at"`
+}
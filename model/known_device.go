@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// KnownDevice 用户登录成功过的设备，用于识别新设备登录
+type KnownDevice struct {
+	ID     uint64 `gorm:"primarykey" json:"-"`
+	UserID uint64 `gorm:"not null;uniqueIndex:idx_known_device_user_device" json:"-"`
+	Device string `gorm:"not null;size:100;uniqueIndex:idx_known_device_user_device" json:"device"`
+	// Trusted 用户标记为可信的设备：携带有效的设备信任凭证登录时不再要求短信验证，陌生网络仍会提醒
+	Trusted bool `gorm:"not null;default:false" json:"trusted"`
+	// TrustHash 服务端签发给该设备的信任凭证（X-Device-Trust）的 SHA-256，只存哈希
+	TrustHash   string    `gorm:"size:64" json:"-"`
+	LastIP      string    `gorm:"size:64" json:"last_ip"`
+	UserAgent   string    `gorm:"size:255" json:"user_agent"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
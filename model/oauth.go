@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// OAuthClient 接入 "使用 Redbook 登录" 的应用
+type OAuthClient struct {
+	ID           uint64    `gorm:"primarykey" json:"id"`
+	ClientID     string    `gorm:"unique;not null;size:64" json:"client_id"`
+	SecretHash   string    `gorm:"size:64" json:"-"` // 公开客户端（SPA / 移动端）为空
+	Name         string    `gorm:"not null;size:100" json:"name"`
+	RedirectURIs string    `gorm:"column:redirect_uris;type:text" json:"redirect_uris"` // 空格分隔
+	Scopes       string    `gorm:"size:255" json:"scopes"`                              // 空格分隔
+	FirstParty   bool      `gorm:"not null;default:false" json:"first_party"`
+	OwnerID      uint64    `gorm:"index" json:"owner_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// OAuthConsent 用户对第三方应用授予的 scope
+type OAuthConsent struct {
+	ID        uint64    `gorm:"primarykey" json:"id"`
+	UserID    uint64    `gorm:"not null;uniqueIndex:idx_consent_user_client" json:"user_id"`
+	ClientID  string    `gorm:"not null;size:64;uniqueIndex:idx_consent_user_client" json:"client_id"`
+	Scopes    string    `gorm:"size:255" json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// RecoveryCode 两步验证的一次性恢复码，仅保存哈希
+type RecoveryCode struct {
+	ID        uint64     `gorm:"primarykey" json:"id"`
+	UserID    uint64     `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"not null;size:64" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
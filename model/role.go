@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// Role 角色及其权限，内置角色由 rbac.roles 配置在启动时同步
+type Role struct {
+	ID          uint64       `gorm:"primarykey" json:"id"`
+	Name        string       `gorm:"unique;not null;size:50" json:"name"`
+	Description string       `gorm:"size:255" json:"description"`
+	Permissions []Permission `gorm:"many2many:role_permissions" json:"permissions"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// Permission 形如 "notes:moderate" 的权限点
+type Permission struct {
+	ID   uint64 `gorm:"primarykey" json:"-"`
+	Name string `gorm:"unique;not null;size:100" json:"name"`
+}
+
+// UserRole 授予用户的角色；默认角色对所有用户隐式生效，不落库
+type UserRole struct {
+	UserID    uint64    `gorm:"primaryKey" json:"user_id"`
+	RoleID    uint64    `gorm:"primaryKey;index" json:"role_id"`
+	GrantedBy uint64    `json:"granted_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
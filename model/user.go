@@ -4,14 +4,18 @@ import "time"
 
 // User 用户模型
 type User struct {
-	ID           uint64    `gorm:"primarykey" json:"id"`
-	Mobile       string    `gorm:"unique;not null;size:11" json:"mobile"`
-	Username     string    `gorm:"not null;size:50" json:"username"`
-	Password     string    `gorm:"not null;size:100" json:"password"`
-	Nickname     string    `gorm:"not null;size:100" json:"nickname"`
-	PasswordHash string    `gorm:"not null;size:255" json:"-"` // 忽略JSON序列化
-	AvatarURL    string    `gorm:"size:255" json:"avatar_url"`
-	Bio          string    `gorm:"type:text" json:"bio"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID             uint64    `gorm:"primarykey" json:"id"`
+	Mobile         string    `gorm:"unique;not null;size:11" json:"mobile"`
+	MobileVerified bool      `gorm:"not null;default:false" json:"mobile_verified"`
+	Username       string    `gorm:"not null;size:50" json:"username"`
+	Password       string    `gorm:"not null;size:255" json:"password"` // 自描述的哈希串（argon2id / bcrypt）
+	Nickname       string    `gorm:"not null;size:100" json:"nickname"`
+	PasswordHash   string    `gorm:"not null;size:255" json:"-"` // 忽略JSON序列化
+	AvatarURL      string    `gorm:"size:255" json:"avatar_url"`
+	Bio            string    `gorm:"type:text" json:"bio"`
+	TokenVersion   int64     `gorm:"not null;default:0" json:"-"` // 递增后所有已签发 token 失效
+	TOTPSecret     string    `gorm:"size:64" json:"-"`            // 已确认的 TOTP 密钥
+	TOTPEnabled    bool      `gorm:"not null;default:false" json:"totp_enabled"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
 }
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"redbook/config"
+	"redbook/dao"
+	"redbook/internal/auth"
+	"redbook/model"
+	"redbook/utils"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAccessTokenInvalid = errors.New("access token invalid or expired")
+	ErrAccessTokenLimit   = errors.New("too many access tokens")
+	ErrAccessTokenScope   = errors.New("unsupported scope")
+	ErrAccessTokenTTL     = errors.New("expiry exceeds the allowed maximum")
+)
+
+// lastUsedInterval 限制 last_used 的写库频率。
+const lastUsedInterval = time.Minute
+
+func accessTokenUsedKey(id uint64) string {
+	return fmt.Sprintf("rb:pat:used:%d", id)
+}
+
+// TokenVersionSource resolves a user's current token version; implemented by
+// UserService.
+type TokenVersionSource interface {
+	TokenVersion(userID uint) (int64, error)
+}
+
+// AccessTokenService manages personal access tokens: long-lived, scoped bearer
+// tokens stored only as SHA-256 hashes. Like JWTs they carry the user's token
+// version, so signing out everywhere also invalidates them.
+type AccessTokenService struct {
+	dao      *dao.AccessTokenDAO
+	store    auth.SessionStore
+	versions TokenVersionSource
+	now      func() time.Time
+}
+
+func NewAccessTokenService(tokens *dao.AccessTokenDAO, store auth.SessionStore, versions TokenVersionSource) *AccessTokenService {
+	return &AccessTokenService{dao: tokens, store: store, versions: versions, now: time.Now}
+}
+
+// accessTokenSettings 返回带默认值的个人访问 token 配置。
+func accessTokenSettings() config.AccessTokenConfig {
+	cfg := config.GlobalConfig.AccessTokens
+	if cfg.DefaultTTL <= 0 {
+		cfg.DefaultTTL = 30
+	}
+	if cfg.MaxTTL <= 0 {
+		cfg.MaxTTL = 365
+	}
+	if cfg.MaxPerUser <= 0 {
+		cfg.MaxPerUser = 20
+	}
+	return cfg
+}
+
+// Create issues a token for the user. The plaintext is returned only once.
+// A zero ttlDays means the configured default.
+func (s *AccessTokenService) Create(userID uint, name string, scopes []string, ttlDays int64) (*model.PersonalAccessToken, string, error) {
+	cfg := accessTokenSettings()
+	if ttlDays <= 0 {
+		ttlDays = cfg.DefaultTTL
+	}
+	if ttlDays > cfg.MaxTTL {
+		return nil, "", ErrAccessTokenTTL
+	}
+	if len(scopes) == 0 {
+		scopes = defaultOAuthScopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(supportedScopes(), scope) {
+			return nil, "", fmt.Errorf("%w: %s", ErrAccessTokenScope, scope)
+		}
+	}
+
+	now := s.now()
+	count, err := s.dao.CountByUser(uint64(userID), now)
+	if err != nil {
+		return nil, "", err
+	}
+	if count >= cfg.MaxPerUser {
+		return nil, "", ErrAccessTokenLimit
+	}
+
+	version, err := s.versions.TokenVersion(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	plain := auth.AccessTokenPrefix + secret
+	token := &model.PersonalAccessToken{
+		UserID:       uint64(userID),
+		Name:         name,
+		TokenHash:    hashAccessToken(plain),
+		Prefix:       plain[:len(auth.AccessTokenPrefix)+6],
+		Scopes:       strings.Join(scopes, " "),
+		ExpiresAt:    now.Add(time.Duration(ttlDays) * 24 * time.Hour),
+		TokenVersion: version,
+	}
+	if err := s.dao.Create(token); err != nil {
+		return nil, "", err
+	}
+	return token, plain, nil
+}
+
+// List returns the user's tokens, newest first.
+func (s *AccessTokenService) List(userID uint) ([]model.PersonalAccessToken, error) {
+	return s.dao.ListByUser(uint64(userID))
+}
+
+// Revoke deletes one of the user's tokens and reports whether it existed.
+func (s *AccessTokenService) Revoke(userID uint, id uint64) (bool, error) {
+	return s.dao.Delete(uint64(userID), id)
+}
+
+// Validate resolves a presented token and records its use. A token created
+// before the user's token version was bumped (sign out everywhere, password
+// reset) is rejected just like an outdated JWT.
+func (s *AccessTokenService) Validate(plain, ip string) (*model.PersonalAccessToken, error) {
+	token, err := s.dao.GetByHash(hashAccessToken(plain))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAccessTokenInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("access token lookup failed: %w", auth.ErrUnavailable)
+	}
+	now := s.now()
+	if !now.Before(token.ExpiresAt) {
+		return nil, ErrAccessTokenInvalid
+	}
+	version, err := s.versions.TokenVersion(uint(token.UserID))
+	if err != nil {
+		return nil, err
+	}
+	if token.TokenVersion != version {
+		return nil, ErrAccessTokenInvalid
+	}
+	s.touch(token, now, ip)
+	return token, nil
+}
+
+// touch updates last_used at most once per lastUsedInterval per token, off the
+// request path.
+func (s *AccessTokenService) touch(token *model.PersonalAccessToken, now time.Time, ip string) {
+	key := accessTokenUsedKey(token.ID)
+	if seen, _ := s.store.Exists(key); seen {
+		return
+	}
+	_ = s.store.Set(key, strconv.FormatInt(now.Unix(), 10), lastUsedInterval)
+	go func() {
+		if err := s.dao.TouchLastUsed(token.ID, now, ip); err != nil {
+			log.Printf("record access token %d usage failed: %v", token.ID, err)
+		}
+	}()
+}
+
+func hashAccessToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"redbook/config"
+	"redbook/dao"
+	"redbook/internal/auth"
+)
+
+// newTestAccessTokens 在 SQLite 上建立个人访问 token 服务，返回其所属用户。
+func newTestAccessTokens(t *testing.T) (*AccessTokenService, *UserService, uint) {
+	t.Helper()
+	s, db := newDBTestService(t)
+	user := createTestUser(t, db, "pat-user", "13800000060", "Secret#2024")
+	return NewAccessTokenService(dao.NewAccessTokenDAO(db), s.Session.Store(), s), s, uint(user.ID)
+}
+
+func TestAccessTokenCreateValidatesRequest(t *testing.T) {
+	tokens, _, userID := newTestAccessTokens(t)
+	config.GlobalConfig.AccessTokens = config.AccessTokenConfig{MaxTTL: 30, MaxPerUser: 2}
+
+	if _, _, err := tokens.Create(userID, "ci", []string{"admin"}, 0); !errors.Is(err, ErrAccessTokenScope) {
+		t.Fatalf("unsupported scope = %v", err)
+	}
+	if _, _, err := tokens.Create(userID, "ci", nil, 31); !errors.Is(err, ErrAccessTokenTTL) {
+		t.Fatalf("expiry over the maximum = %v", err)
+	}
+	token, plain, err := tokens.Create(userID, "ci", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Scopes != "profile" || token.Prefix != plain[:len(token.Prefix)] || token.TokenHash == plain {
+		t.Fatalf("created token = %+v", token)
+	}
+	if _, _, err := tokens.Create(userID, "ci-2", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tokens.Create(userID, "ci-3", nil, 0); !errors.Is(err, ErrAccessTokenLimit) {
+		t.Fatalf("token over the per-user limit = %v", err)
+	}
+}
+
+func TestAccessTokenValidate(t *testing.T) {
+	tokens, _, userID := newTestAccessTokens(t)
+	token, plain, err := tokens.Create(userID, "ci", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := tokens.Validate(plain, "10.0.0.1")
+	if err != nil || got.ID != token.ID || got.UserID != uint64(userID) {
+		t.Fatalf("Validate = %+v, %v", got, err)
+	}
+	if _, err := tokens.Validate(auth.AccessTokenPrefix+"unknown", ""); !errors.Is(err, ErrAccessTokenInvalid) {
+		t.Fatalf("unknown token = %v", err)
+	}
+
+	tokens.now = func() time.Time { return token.ExpiresAt }
+	if _, err := tokens.Validate(plain, ""); !errors.Is(err, ErrAccessTokenInvalid) {
+		t.Fatalf("expired token = %v", err)
+	}
+	tokens.now = time.Now
+
+	if revoked, err := tokens.Revoke(userID, token.ID); err != nil || !revoked {
+		t.Fatalf("Revoke = %v, %v", revoked, err)
+	}
+	if _, err := tokens.Validate(plain, ""); !errors.Is(err, ErrAccessTokenInvalid) {
+		t.Fatalf("revoked token = %v", err)
+	}
+}
+
+func TestAccessTokenRevokeOnlyOwnTokens(t *testing.T) {
+	tokens, _, userID := newTestAccessTokens(t)
+	token, plain, err := tokens.Create(userID, "ci", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := tokens.Revoke(userID+1, token.ID); err != nil || revoked {
+		t.Fatalf("revoke by another user = %v, %v", revoked, err)
+	}
+	if _, err := tokens.Validate(plain, ""); err != nil {
+		t.Fatalf("token revoked by another user: %v", err)
+	}
+}
+
+func TestAccessTokenInvalidatedBySignOutEverywhere(t *testing.T) {
+	tokens, users, userID := newTestAccessTokens(t)
+	_, plain, err := tokens.Create(userID, "ci", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := users.LogoutAll(userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Validate(plain, ""); !errors.Is(err, ErrAccessTokenInvalid) {
+		t.Fatalf("token after sign out everywhere = %v", err)
+	}
+	// 之后新建的 token 记录新的版本，照常可用
+	_, fresh, err := tokens.Create(userID, "ci-2", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Validate(fresh, ""); err != nil {
+		t.Fatalf("token created after the bump: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"errors"
+	"redbook/dao"
+	"redbook/internal/audit"
+	"redbook/model"
+)
+
+const (
+	defaultAuditPageSize = 20
+	maxAuditPageSize     = 100
+)
+
+// AuditPage is one page of audit events.
+type AuditPage struct {
+	Events   []model.AuditEvent `json:"events"`
+	Total    int64              `json:"total"`
+	Page     int                `json:"page"`
+	PageSize int                `json:"page_size"`
+}
+
+// AuditService answers queries over the security audit log.
+type AuditService struct {
+	dao *dao.AuditDAO
+}
+
+func NewAuditService(events *dao.AuditDAO) *AuditService {
+	return &AuditService{dao: events}
+}
+
+// Search returns a page of events matching filter, newest first. Out-of-range
+// page numbers and sizes are clamped.
+func (a *AuditService) Search(filter dao.AuditFilter, page, pageSize int) (*AuditPage, error) {
+	page = max(page, 1)
+	if pageSize <= 0 {
+		pageSize = defaultAuditPageSize
+	}
+	pageSize = min(pageSize, maxAuditPageSize)
+	events, total, err := a.dao.QueryEvents(filter, (page-1)*pageSize, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditPage{Events: events, Total: total, Page: page, PageSize: pageSize}, nil
+}
+
+// RecordAudit fills e with the client and the outcome err and queues it. A
+// pending two-factor or device verification is recorded as pending rather than failed.
+func RecordAudit(e model.AuditEvent, client ClientInfo, err error) {
+	e.Device = client.Device
+	e.IP = client.IP
+	e.UserAgent = client.UserAgent
+	var (
+		mfa    *MFARequiredError
+		stepUp *StepUpRequiredError
+	)
+	switch {
+	case err == nil:
+		e.Result = audit.ResultSuccess
+	case errors.As(err, &mfa):
+		e.Result = audit.ResultPending
+		e.Reason = "mfa_required"
+	case errors.As(err, &stepUp):
+		e.Result = audit.ResultPending
+		e.Reason = "step_up_required"
+	default:
+		e.Result = audit.ResultFailure
+		if e.Reason == "" {
+			e.Reason = err.Error()
+		}
+	}
+	audit.Record(e)
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"redbook/dao"
+	"redbook/internal/audit"
+	"redbook/model"
+)
+
+// auditRecorder 是内存中的审计 Sink，测试据此检查实际写出的事件。
+type auditRecorder struct {
+	mu     sync.Mutex
+	events []model.AuditEvent
+}
+
+func (r *auditRecorder) WriteAuditEvents(events []model.AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, events...)
+	return nil
+}
+
+// find 返回指定类型的全部事件。
+func (r *auditRecorder) find(event string) []model.AuditEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []model.AuditEvent
+	for _, e := range r.events {
+		if e.Event == event {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// recordAudits 在 fn 执行期间收集审计事件；返回时 defer 的 stop 已写完队列。
+func recordAudits(t *testing.T, fn func()) *auditRecorder {
+	t.Helper()
+	rec := &auditRecorder{}
+	stop := audit.Start(rec, 0)
+	defer stop()
+	fn()
+	return rec
+}
+
+func TestAuditSearchFiltersAndPaginates(t *testing.T) {
+	db := newTestDB(t)
+	events := dao.NewAuditDAO(db)
+	svc := NewAuditService(events)
+
+	base := time.Now().Add(-time.Hour)
+	stop := audit.Start(events, 0)
+	for i := 0; i < 25; i++ {
+		RecordAudit(model.AuditEvent{Event: audit.EventLoginPassword, UserID: 1, CreatedAt: base.Add(time.Duration(i) * time.Minute)},
+			ClientInfo{IP: "10.0.0.1"}, nil)
+	}
+	RecordAudit(model.AuditEvent{Event: audit.EventLoginPassword, UserID: 2, CreatedAt: base},
+		ClientInfo{IP: "10.0.0.2"}, ErrInvalidCredentials)
+	RecordAudit(model.AuditEvent{Event: audit.EventLogout, UserID: 1, CreatedAt: base.Add(30 * time.Minute)},
+		ClientInfo{IP: "10.0.0.1"}, nil)
+	stop()
+
+	// 默认每页 20 条，按时间倒序
+	page, err := svc.Search(dao.AuditFilter{UserID: 1, Event: audit.EventLoginPassword}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 25 || len(page.Events) != 20 || page.Page != 1 || page.PageSize != 20 {
+		t.Fatalf("page = total %d, %d events, page %d, size %d", page.Total, len(page.Events), page.Page, page.PageSize)
+	}
+	if !page.Events[0].CreatedAt.After(page.Events[1].CreatedAt) {
+		t.Fatal("events should be newest first")
+	}
+	if page, err = svc.Search(dao.AuditFilter{UserID: 1, Event: audit.EventLoginPassword}, 2, 20); err != nil || len(page.Events) != 5 {
+		t.Fatalf("second page = %v, %v", page, err)
+	}
+	if page, _ = svc.Search(dao.AuditFilter{}, 1, 1000); page.PageSize != maxAuditPageSize {
+		t.Fatalf("page size %d, want clamp to %d", page.PageSize, maxAuditPageSize)
+	}
+
+	cases := []struct {
+		name   string
+		filter dao.AuditFilter
+		total  int64
+	}{
+		{"result", dao.AuditFilter{Result: audit.ResultFailure}, 1},
+		{"ip", dao.AuditFilter{IP: "10.0.0.1"}, 26},
+		{"event", dao.AuditFilter{Event: audit.EventLogout}, 1},
+		{"since", dao.AuditFilter{UserID: 1, Since: base.Add(20 * time.Minute)}, 6},
+		{"until", dao.AuditFilter{UserID: 1, Until: base.Add(time.Minute)}, 1},
+	}
+	for _, tc := range cases {
+		page, err := svc.Search(tc.filter, 1, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != tc.total {
+			t.Errorf("%s: total %d, want %d", tc.name, page.Total, tc.total)
+		}
+	}
+}
+
+func TestLoginRecordsAuditEvents(t *testing.T) {
+	s, db := newDBTestService(t)
+	user := createTestUser(t, db, "paula", "13800000060", "Secret#2024")
+	warmTokenVersion(t, s, uint(user.ID))
+	client := ClientInfo{Device: "phone", IP: "10.0.0.9", UserAgent: "test"}
+
+	rec := recordAudits(t, func() {
+		if _, _, err := s.Login("paula", "wrong-password", client); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("wrong password: %v", err)
+		}
+		// 跳过失败后的递增延迟
+		s.clearLoginFailures(usernameIdentifier("paula"))
+		if _, _, err := s.Login("paula", "Secret#2024", client); err != nil {
+			t.Fatalf("login: %v", err)
+		}
+	})
+
+	events := rec.find(audit.EventLoginPassword)
+	if len(events) != 2 {
+		t.Fatalf("recorded %d login events, want 2", len(events))
+	}
+	failed, ok := events[0], events[1]
+	if failed.Result != audit.ResultFailure || failed.Reason != "wrong password" || failed.UserID != user.ID {
+		t.Fatalf("failure event = %+v", failed)
+	}
+	if ok.Result != audit.ResultSuccess || ok.UserID != user.ID || ok.Device != "phone" || ok.IP != "10.0.0.9" || ok.Identifier != "paula" {
+		t.Fatalf("success event = %+v", ok)
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"redbook/internal/captcha"
+	"redbook/internal/metrics"
+	"strconv"
+	"time"
+)
+
+func riskLoginIPKey(ip string) string    { return fmt.Sprintf("rb:risk:login:%s", ip) }
+func riskRegisterIPKey(ip string) string { return fmt.Sprintf("rb:risk:register:%s", ip) }
+
+// riskLoginAccountKey 为账号标识的登录失败计数，独立于锁定计数：锁定时清空
+// 锁定计数不会让验证码要求随之消失。
+func riskLoginAccountKey(id string) string { return fmt.Sprintf("rb:risk:login:acct:%s", id) }
+
+// IsCaptchaError reports whether err asks the client to solve a CAPTCHA.
+func IsCaptchaError(err error) bool {
+	return errors.Is(err, captcha.ErrRequired) || errors.Is(err, captcha.ErrInvalid)
+}
+
+// NewCaptcha issues a CAPTCHA challenge.
+func (s *UserService) NewCaptcha() (*captcha.Challenge, error) {
+	return s.Captcha.New()
+}
+
+// requireLoginCaptcha asks for a solved CAPTCHA once the account identifier or
+// the client IP has failed too many logins inside the risk window.
+func (s *UserService) requireLoginCaptcha(id string, client ClientInfo) error {
+	cfg := s.Captcha.Settings()
+	if s.riskCount(riskLoginAccountKey(id)) < cfg.AccountThreshold && s.riskCount(riskLoginIPKey(client.IP)) < cfg.IPThreshold {
+		return nil
+	}
+	return s.checkCaptcha(client)
+}
+
+// requireRegisterCaptcha asks for a solved CAPTCHA once the client IP has
+// registered too often inside the risk window. Every attempt counts.
+func (s *UserService) requireRegisterCaptcha(client ClientInfo) error {
+	cfg := s.Captcha.Settings()
+	key := riskRegisterIPKey(client.IP)
+	required := s.riskCount(key) >= cfg.IPThreshold
+	_, _ = s.Session.Store().Incr(key, time.Duration(cfg.Window)*time.Second)
+	if !required {
+		return nil
+	}
+	return s.checkCaptcha(client)
+}
+
+// recordLoginRisk counts a failed login against the account identifier and
+// the client IP.
+func (s *UserService) recordLoginRisk(id string, client ClientInfo) {
+	window := time.Duration(s.Captcha.Settings().Window) * time.Second
+	store := s.Session.Store()
+	_, _ = store.Incr(riskLoginAccountKey(id), window)
+	if client.IP != "" {
+		_, _ = store.Incr(riskLoginIPKey(client.IP), window)
+	}
+}
+
+func (s *UserService) checkCaptcha(client ClientInfo) error {
+	// 限流中间件已校验并消费了同一个验证码
+	if client.CaptchaPassed {
+		return nil
+	}
+	err := s.Captcha.Check(client.CaptchaID, client.CaptchaAnswer)
+	switch {
+	case errors.Is(err, captcha.ErrRequired):
+		metrics.IncSecurityEvent("captcha_required")
+	case err != nil:
+		metrics.IncSecurityEvent("captcha_failed")
+	}
+	return err
+}
+
+func (s *UserService) riskCount(key string) int64 {
+	v, err := s.Session.Store().Get(key)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(v, 10, 64)
+	return n
+}
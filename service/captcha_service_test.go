@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"redbook/config"
+	"redbook/internal/captcha"
+)
+
+func TestLoginCaptchaAfterAccountFailures(t *testing.T) {
+	s := newTestService(t)
+	id := usernameIdentifier("alice")
+	client := ClientInfo{IP: "203.0.113.5"}
+	if err := s.requireLoginCaptcha(id, client); err != nil {
+		t.Fatalf("captcha required too early: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		s.recordLoginRisk(id, ClientInfo{})
+	}
+	if err := s.requireLoginCaptcha(id, client); !errors.Is(err, captcha.ErrRequired) {
+		t.Fatalf("expected ErrRequired, got %v", err)
+	}
+	ch, err := s.NewCaptcha()
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, _ := s.Session.Store().Get("rb:captcha:" + ch.ID)
+	client.CaptchaID, client.CaptchaAnswer = ch.ID, answer
+	if err := s.requireLoginCaptcha(id, client); err != nil {
+		t.Fatalf("solved captcha rejected: %v", err)
+	}
+}
+
+func TestLoginCaptchaSurvivesLockout(t *testing.T) {
+	s, db := newDBTestService(t)
+	clock := useFakeClock(s)
+	createTestUser(t, db, "bob", "13800000041", "Secret#2024")
+	// 锁定阈值与验证码阈值相同：锁定时清空的锁定计数不能带走验证码要求
+	s.Captcha = captcha.NewManager(s.Session.Store(), config.CaptchaConfig{AccountThreshold: 3})
+	config.GlobalConfig.Lockout = config.LockoutConfig{Threshold: 3, LockDuration: 60}
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := s.Login("bob", "wrong", ClientInfo{Device: "phone"}); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("attempt %d = %v", i+1, err)
+		}
+		clock.Advance(time.Minute)
+	}
+	clock.Advance(time.Minute)
+
+	if _, _, err := s.Login("bob", "Secret#2024", ClientInfo{Device: "phone"}); !errors.Is(err, captcha.ErrRequired) {
+		t.Fatalf("login after the lockout expired = %v, want captcha", err)
+	}
+	if _, _, err := s.Login("bob", "Secret#2024", ClientInfo{Device: "phone", CaptchaPassed: true}); err != nil {
+		t.Fatalf("login with a solved captcha: %v", err)
+	}
+	// 登录成功后账号的验证码计数清零
+	if _, _, err := s.Login("bob", "Secret#2024", ClientInfo{Device: "phone"}); err != nil {
+		t.Fatalf("login after success still asks for a captcha: %v", err)
+	}
+}
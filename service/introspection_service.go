@@ -0,0 +1,99 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"redbook/internal/auth"
+	"strconv"
+)
+
+// IntrospectionResponse is the RFC 7662 §2.2 body. Inactive tokens only carry
+// active=false, so nothing about them leaks to the caller.
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Sub       string `json:"sub,omitempty"`
+	Device    string `json:"device,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	// Cnf 为 DPoP 绑定的公钥指纹（RFC 9449 §6.2），资源服务器据此校验证明
+	Cnf *auth.Confirmation `json:"cnf,omitempty"`
+	// SessionActive 表示签发该 token 的设备会话（refresh 记录）是否仍然存在。
+	SessionActive bool `json:"session_active,omitempty"`
+}
+
+// Introspect reports whether a token is currently usable, applying the same
+// blacklist, family and token-version checks as AuthMiddleware. Only
+// confidential clients may introspect: a client sees its own tokens, while
+// first-party clients (our resource servers) may introspect any token. Tokens
+// the caller may not see are reported inactive, as RFC 7662 §2.2 suggests.
+func (o *OAuthService) Introspect(clientID, secret, token, hint string) (*IntrospectionResponse, error) {
+	client, err := o.authenticateConfidential(clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims *auth.Claims
+	// token_type_hint 只影响尝试顺序
+	if hint == "refresh_token" {
+		if claims, err = o.users.ValidateRefreshToken(token); err != nil {
+			claims, err = o.users.ValidateAccessToken(token)
+		}
+	} else {
+		if claims, err = o.users.ValidateAccessToken(token); err != nil {
+			claims, err = o.users.ValidateRefreshToken(token)
+		}
+	}
+	if errors.Is(err, auth.ErrUnavailable) {
+		// 无法确认时不能回答 inactive，否则资源服务器会把有效 token 当作已吊销
+		return nil, err
+	}
+	if err != nil || (claims.ClientID != client.ClientID && !client.FirstParty) {
+		return &IntrospectionResponse{Active: false}, nil
+	}
+
+	resp := &IntrospectionResponse{
+		Active:    true,
+		Sub:       strconv.FormatUint(uint64(claims.UserID), 10),
+		Device:    claims.Device,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		TokenType: "access_token",
+		Jti:       claims.ID,
+		Cnf:       claims.Cnf,
+	}
+	if claims.TokenUse == auth.TokenUseRefresh {
+		resp.TokenType = "refresh_token"
+	}
+	if claims.ExpiresAt != nil {
+		resp.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		resp.Iat = claims.IssuedAt.Unix()
+	}
+	if stored, err := o.users.Session.GetRefreshToken(claims.UserID, claims.Device); err == nil && stored != "" {
+		resp.SessionActive = true
+	}
+	return resp, nil
+}
+
+// Revoke implements RFC 7009. Unknown or malformed tokens are treated as
+// already revoked; a client may only revoke tokens issued to it, and only
+// first-party clients may revoke tokens from a direct login.
+func (o *OAuthService) Revoke(clientID, secret, token string) error {
+	client, err := o.authenticateConfidential(clientID, secret)
+	if err != nil {
+		return err
+	}
+	claims, err := auth.ParseTokenAllowExpired(token)
+	if err != nil {
+		return nil
+	}
+	if claims.ClientID != client.ClientID && !(claims.ClientID == "" && client.FirstParty) {
+		return &OAuthError{Code: "unauthorized_client", Description: "token was not issued to this client", Status: http.StatusBadRequest}
+	}
+	return o.users.RevokeToken(token, claims)
+}
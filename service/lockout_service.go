@@ -0,0 +1,161 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"redbook/config"
+	"redbook/internal/audit"
+	"redbook/internal/metrics"
+	"redbook/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCredentials 密码登录失败时统一返回，不区分用户名是否存在。
+var ErrInvalidCredentials = errors.New("用户名或密码错误")
+
+// LoginThrottledError is returned when an identifier has failed too often. It
+// is produced before the account is looked up, so unknown identifiers are
+// throttled exactly like real ones.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *LoginThrottledError) Error() string {
+	return "too many failed login attempts, try again later"
+}
+
+// lockoutSettings 返回带默认值的锁定策略。
+func lockoutSettings() config.LockoutConfig {
+	cfg := config.GlobalConfig.Lockout
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 10
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 900
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = 1
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = 30
+	}
+	if cfg.LockDuration <= 0 {
+		cfg.LockDuration = 900
+	}
+	return cfg
+}
+
+// 登录标识按类型区分，用户名与手机号的失败计数互不影响。
+func usernameIdentifier(username string) string {
+	return "u:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func mobileIdentifier(mobile string) string {
+	return "m:" + strings.TrimSpace(mobile)
+}
+
+// accountIdentifiers 返回账号的全部登录标识；第二因素失败计入这些标识的锁定计数。
+func accountIdentifiers(user *model.User) []string {
+	ids := []string{usernameIdentifier(user.Username)}
+	if user.Mobile != "" {
+		ids = append(ids, mobileIdentifier(user.Mobile))
+	}
+	return ids
+}
+
+func lockFailKey(id string) string  { return fmt.Sprintf("rb:lock:fail:%s", id) }
+func lockDelayKey(id string) string { return fmt.Sprintf("rb:lock:next:%s", id) }
+func lockUntilKey(id string) string { return fmt.Sprintf("rb:lock:until:%s", id) }
+
+// checkLockout rejects the attempt while the identifier is locked or still
+// inside its backoff delay.
+func (s *UserService) checkLockout(id string) error {
+	store := s.Session.Store()
+	now := time.Now()
+	for _, lock := range []struct {
+		key    string
+		locked bool
+	}{{lockUntilKey(id), true}, {lockDelayKey(id), false}} {
+		v, err := store.Get(lock.key)
+		if err != nil {
+			continue
+		}
+		ms, _ := strconv.ParseInt(v, 10, 64)
+		if wait := time.UnixMilli(ms).Sub(now); wait > 0 {
+			metrics.IncLockout("blocked")
+			return &LoginThrottledError{RetryAfter: wait, Locked: lock.locked}
+		}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed attempt and starts the next backoff delay,
+// or locks the identifier once the threshold is reached. It reports whether
+// this failure locked the identifier.
+func (s *UserService) recordLoginFailure(id string) bool {
+	cfg := lockoutSettings()
+	store := s.Session.Store()
+	failures, err := store.Incr(lockFailKey(id), time.Duration(cfg.Window)*time.Second)
+	if err != nil {
+		return false
+	}
+
+	if failures >= cfg.Threshold {
+		ttl := time.Duration(cfg.LockDuration) * time.Second
+		_ = store.Set(lockUntilKey(id), strconv.FormatInt(time.Now().Add(ttl).UnixMilli(), 10), ttl)
+		_ = store.Del(lockFailKey(id), lockDelayKey(id))
+		metrics.IncLockout("locked")
+		return true
+	}
+
+	// 第 n 次失败后等待 base * 2^(n-1) 秒
+	delay := cfg.BaseDelay << min(failures-1, 16)
+	if delay > cfg.MaxDelay {
+		delay = cfg.MaxDelay
+	}
+	ttl := time.Duration(delay) * time.Second
+	_ = store.Set(lockDelayKey(id), strconv.FormatInt(time.Now().Add(ttl).UnixMilli(), 10), ttl)
+	metrics.IncLockout("delayed")
+	return false
+}
+
+// auditLoginFailure records a failed login attempt, and the lockout it caused.
+func auditLoginFailure(entry model.AuditEvent, client ClientInfo, err error, locked bool) {
+	RecordAudit(entry, client, err)
+	if locked {
+		entry.Event = audit.EventLockout
+		entry.Reason = fmt.Sprintf("locked for %ds", lockoutSettings().LockDuration)
+		RecordAudit(entry, client, nil)
+	}
+}
+
+// clearLoginFailures resets the counters once the login has fully succeeded,
+// i.e. after the second factor for accounts with 2FA. The account's CAPTCHA
+// counter goes with them.
+func (s *UserService) clearLoginFailures(ids ...string) {
+	var keys []string
+	for _, id := range ids {
+		keys = append(keys, lockFailKey(id), lockDelayKey(id), riskLoginAccountKey(id))
+	}
+	_ = s.Session.Store().Del(keys...)
+}
+
+// UnlockAccount lifts any lockout on the user's username and mobile.
+func (s *UserService) UnlockAccount(userID uint) error {
+	user, err := s.dao.GetByID(uint64(userID))
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for _, id := range accountIdentifiers(user) {
+		keys = append(keys, lockFailKey(id), lockDelayKey(id), lockUntilKey(id))
+	}
+	if err := s.Session.Store().Del(keys...); err != nil {
+		return err
+	}
+	metrics.IncLockout("unlocked")
+	return nil
+}
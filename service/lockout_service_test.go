@@ -0,0 +1,53 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"redbook/config"
+)
+
+func TestLoginFailuresBackOffThenLock(t *testing.T) {
+	s := newTestService(t)
+	config.GlobalConfig.Lockout = config.LockoutConfig{Threshold: 3, BaseDelay: 60, LockDuration: 600}
+	id := usernameIdentifier("alice")
+
+	s.recordLoginFailure(id)
+	var throttled *LoginThrottledError
+	if err := s.checkLockout(id); !errors.As(err, &throttled) || throttled.Locked {
+		t.Fatalf("expected backoff delay, got %v", err)
+	}
+
+	s.recordLoginFailure(id)
+	s.recordLoginFailure(id)
+	if err := s.checkLockout(id); !errors.As(err, &throttled) || !throttled.Locked {
+		t.Fatalf("expected lockout, got %v", err)
+	}
+
+	// 不存在的账号与真实账号走同一套计数
+	if err := s.checkLockout(usernameIdentifier("nobody")); err != nil {
+		t.Fatalf("fresh identifier should not be throttled: %v", err)
+	}
+}
+
+func TestUnlockAccountClearsUsernameAndMobile(t *testing.T) {
+	s, db := newDBTestService(t)
+	config.GlobalConfig.Lockout = config.LockoutConfig{Threshold: 1, LockDuration: 600}
+	user := createTestUser(t, db, "Nina", "13800000040", "Secret#2024")
+	// 登录时用户名不区分大小写，锁定记在小写标识上
+	ids := []string{usernameIdentifier("NINA"), mobileIdentifier(user.Mobile)}
+	for _, id := range ids {
+		if !s.recordLoginFailure(id) {
+			t.Fatalf("%s should be locked", id)
+		}
+	}
+
+	if err := s.UnlockAccount(uint(user.ID)); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if err := s.checkLockout(id); err != nil {
+			t.Fatalf("%s still throttled after unlock: %v", id, err)
+		}
+	}
+}
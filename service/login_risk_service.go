@@ -0,0 +1,282 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"redbook/config"
+	"redbook/internal/audit"
+	"redbook/model"
+	"redbook/utils"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OTPPurposeStepUp 可疑登录的短信确认
+const OTPPurposeStepUp = "stepup"
+
+// 可疑登录的原因
+const (
+	RiskNewDevice  = "new_device"
+	RiskNewNetwork = "new_network"
+)
+
+var (
+	ErrStepUpChallenge = errors.New("device verification expired, please sign in again")
+	ErrDeviceNotFound  = errors.New("device not found")
+	// ErrTrustOtherDevice 信任凭证只能交给发起请求的设备本身
+	ErrTrustOtherDevice = errors.New("only the current device can be trusted")
+)
+
+// StepUpRequiredError is returned when a suspicious login has to be confirmed
+// with an SMS code sent to the user's verified mobile before tokens are issued.
+type StepUpRequiredError struct {
+	Token     string
+	ExpiresIn int64
+	Reasons   []string
+}
+
+func (e *StepUpRequiredError) Error() string {
+	return "sign-in from an unrecognized device requires verification"
+}
+
+// loginRiskSettings 返回带默认值的可疑登录配置。
+func loginRiskSettings() config.LoginRiskConfig {
+	cfg := config.GlobalConfig.LoginRisk
+	if cfg.NetworkTTL <= 0 {
+		cfg.NetworkTTL = 180
+	}
+	return cfg
+}
+
+func knownNetworksKey(userID uint64) string {
+	return fmt.Sprintf("rb:known:nets:%d", userID)
+}
+
+func stepUpKey(token string) string {
+	return fmt.Sprintf("rb:stepup:%s", token)
+}
+
+// networkOf maps an IP to its /24 (IPv4) or /48 (IPv6) network, a coarse
+// stand-in for "location" that needs no GeoIP database.
+func networkOf(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// assessLogin compares the client with the user's login history and returns
+// why the login looks unusual, and whether the client proved it is a trusted
+// device. X-Device is chosen by the client, so trust needs the device's
+// server-issued secret; the network rule applies to trusted devices as well.
+// The first login of an account is never flagged.
+func (s *UserService) assessLogin(user *model.User, client ClientInfo) ([]string, bool) {
+	known, err := s.dao.CountKnownDevices(user.ID)
+	if err != nil || known == 0 {
+		return nil, false
+	}
+	var (
+		reasons []string
+		trusted bool
+	)
+	device, err := s.dao.GetKnownDevice(user.ID, client.Device)
+	if err != nil {
+		reasons = append(reasons, RiskNewDevice)
+	} else {
+		trusted = deviceTrustValid(device, client.DeviceTrust)
+	}
+	if network := networkOf(client.IP); network != "" {
+		networks, _ := s.Session.Store().SMembers(knownNetworksKey(user.ID))
+		if len(networks) > 0 && !slices.Contains(networks, network) {
+			reasons = append(reasons, RiskNewNetwork)
+		}
+	}
+	return reasons, trusted
+}
+
+// deviceTrustValid reports whether secret is the trust secret issued to device.
+func deviceTrustValid(device *model.KnownDevice, secret string) bool {
+	if !device.Trusted || device.TrustHash == "" || secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashDeviceTrust(secret)), []byte(device.TrustHash)) == 1
+}
+
+func hashDeviceTrust(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueDeviceTrust marks a known device trusted and returns the secret the
+// device presents as X-Device-Trust on later logins. Only its hash is stored;
+// issuing a new secret invalidates the previous one.
+func (s *UserService) issueDeviceTrust(userID uint64, device string) (string, error) {
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	ok, err := s.dao.SetDeviceTrust(userID, device, hashDeviceTrust(secret))
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrDeviceNotFound
+	}
+	return secret, nil
+}
+
+// rememberLogin adds the device and network of a successful login to the
+// user's history. Failures only weaken detection, so they are logged.
+func (s *UserService) rememberLogin(userID uint64, client ClientInfo) {
+	if err := s.dao.SeeKnownDevice(userID, client.Device, client.IP, client.UserAgent, time.Now()); err != nil {
+		log.Printf("remember device user=%d device=%s failed: %v", userID, client.Device, err)
+	}
+	if network := networkOf(client.IP); network != "" {
+		ttl := time.Duration(loginRiskSettings().NetworkTTL) * 24 * time.Hour
+		_ = s.Session.Store().SAdd(knownNetworksKey(userID), network, ttl)
+	}
+}
+
+// notifySuspiciousLogin tells the user about an unusual login off the request path.
+func (s *UserService) notifySuspiciousLogin(user *model.User, client ClientInfo, reasons []string) {
+	RecordAudit(model.AuditEvent{
+		Event:  audit.EventSuspiciousLogin,
+		UserID: user.ID,
+		Reason: strings.Join(reasons, ","),
+	}, client, nil)
+	msg := fmt.Sprintf("你的账号正在新的设备或网络登录（设备 %s，IP %s，%s）。如非本人操作，请立即修改密码。",
+		client.Device, client.IP, time.Now().Format("2006-01-02 15:04"))
+	go func() {
+		if err := s.Notifier.Notify(user, msg); err != nil {
+			log.Printf("notify suspicious login user=%d failed: %v", user.ID, err)
+		}
+	}()
+}
+
+// stepUpRequired reports whether a flagged login must be confirmed by SMS.
+// An SMS login already proved control of the mobile, and accounts without a
+// verified mobile have nowhere to receive the code.
+func stepUpRequired(user *model.User, smsVerified bool) bool {
+	return loginRiskSettings().StepUp && !smsVerified && user.MobileVerified
+}
+
+// startStepUp parks the login and texts a confirmation code to the user.
+func (s *UserService) startStepUp(user *model.User, client ClientInfo, reasons []string) error {
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(mfaChallenge{UserID: user.ID, pendingClient: newPendingClient(client)})
+	if err != nil {
+		return err
+	}
+	if err := s.OTP.SendCode(OTPPurposeStepUp, user.Mobile, client.IP); err != nil {
+		return err
+	}
+	ttl := challengeTTL()
+	if err := s.Session.Store().Set(stepUpKey(token), string(data), ttl); err != nil {
+		return err
+	}
+	return &StepUpRequiredError{Token: token, ExpiresIn: int64(ttl.Seconds()), Reasons: reasons}
+}
+
+// StepUpResult is the outcome of a confirmed suspicious login. DeviceTrust is
+// set when the device was trusted along the way.
+type StepUpResult struct {
+	AccessToken  string
+	RefreshToken string
+	DeviceTrust  string
+}
+
+// CompleteStepUp exchanges a parked suspicious login plus the SMS code for a
+// token pair. With trust the device is marked trusted and receives the secret
+// that proves it on later logins.
+func (s *UserService) CompleteStepUp(token, code string, trust bool) (*StepUpResult, error) {
+	store := s.Session.Store()
+	raw, err := store.Get(stepUpKey(token))
+	if err != nil {
+		return nil, ErrStepUpChallenge
+	}
+	var ch mfaChallenge
+	if err := json.Unmarshal([]byte(raw), &ch); err != nil {
+		return nil, ErrStepUpChallenge
+	}
+	client := ch.client()
+	entry := model.AuditEvent{Event: audit.EventLoginStepUp, UserID: ch.UserID}
+
+	user, err := s.dao.GetByID(ch.UserID)
+	if err != nil {
+		return nil, ErrStepUpChallenge
+	}
+	// 错误次数由验证码本身限制，超过后验证码作废，需重新登录
+	if err := s.OTP.VerifyCode(OTPPurposeStepUp, user.Mobile, code); err != nil {
+		RecordAudit(entry, client, err)
+		return nil, err
+	}
+	_ = store.Del(stepUpKey(token))
+
+	access, refresh, err := s.issueSession(user, client)
+	RecordAudit(entry, client, err)
+	if err != nil {
+		return nil, err
+	}
+	result := &StepUpResult{AccessToken: access, RefreshToken: refresh}
+	if trust {
+		// 信任失败不影响本次登录，只是下次仍需验证
+		if result.DeviceTrust, err = s.issueDeviceTrust(user.ID, client.Device); err != nil {
+			log.Printf("trust device user=%d device=%s failed: %v", user.ID, client.Device, err)
+		}
+	}
+	return result, nil
+}
+
+// ListKnownDevices returns the devices the user has signed in from.
+func (s *UserService) ListKnownDevices(userID uint) ([]model.KnownDevice, error) {
+	return s.dao.ListKnownDevices(uint64(userID))
+}
+
+// TrustDevice marks the caller's current device as trusted and returns its
+// trust secret. The secret has to reach the device it names, so a session may
+// only trust its own device.
+func (s *UserService) TrustDevice(userID uint, currentDevice, device string) (string, error) {
+	if device != currentDevice {
+		return "", ErrTrustOtherDevice
+	}
+	return s.issueDeviceTrust(uint64(userID), device)
+}
+
+// UntrustDevice revokes a device's trust and its secret.
+func (s *UserService) UntrustDevice(userID uint, device string) error {
+	ok, err := s.dao.SetDeviceTrust(uint64(userID), device, "")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
+
+// ForgetDevice drops a device from the history, so the next login from it is
+// treated as coming from a new device.
+func (s *UserService) ForgetDevice(userID uint, device string) error {
+	ok, err := s.dao.DeleteKnownDevice(uint64(userID), device)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
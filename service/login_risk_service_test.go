@@ -0,0 +1,187 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"redbook/config"
+	"redbook/model"
+)
+
+func TestNetworkOf(t *testing.T) {
+	cases := map[string]string{
+		"203.0.113.77":        "203.0.113.0/24",
+		"::ffff:203.0.113.9":  "203.0.113.0/24",
+		"2001:db8:abcd:12::1": "2001:db8:abcd::/48",
+		"not-an-ip":           "",
+	}
+	for ip, want := range cases {
+		if got := networkOf(ip); got != want {
+			t.Errorf("networkOf(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+// recordingNotifier 收集可疑登录提醒；提醒在后台发送，测试通过 channel 等待。
+type recordingNotifier struct {
+	sent chan string
+}
+
+func (n *recordingNotifier) Notify(_ *model.User, message string) error {
+	n.sent <- message
+	return nil
+}
+
+// expect 等待一条提醒，want 为 false 时断言没有提醒。
+func (n *recordingNotifier) expect(t *testing.T, want bool) {
+	t.Helper()
+	select {
+	case msg := <-n.sent:
+		if !want {
+			t.Fatalf("unexpected notice: %s", msg)
+		}
+	case <-time.After(200 * time.Millisecond):
+		if want {
+			t.Fatal("suspicious login not notified")
+		}
+	}
+}
+
+// newRiskTestService 开启短信确认，并以 phone@10.0.0.1 完成账号的首次登录。
+func newRiskTestService(t *testing.T) (*UserService, *model.User, *recordingSMS, *recordingNotifier, *fakeClock) {
+	t.Helper()
+	s, db := newDBTestService(t)
+	config.GlobalConfig.LoginRisk.StepUp = true
+	user := createTestUser(t, db, "sara", "13800000080", "Secret#2024")
+	warmTokenVersion(t, s, uint(user.ID))
+	rec := useRecordingSMS(s)
+	notices := &recordingNotifier{sent: make(chan string, 4)}
+	s.Notifier = notices
+	clock := useFakeClock(s)
+
+	if _, _, err := s.Login("sara", "Secret#2024", ClientInfo{Device: "phone", IP: "10.0.0.1"}); err != nil {
+		t.Fatalf("first login: %v", err)
+	}
+	notices.expect(t, false)
+	return s, user, rec, notices, clock
+}
+
+// expectStepUp 断言登录被挂起等待短信确认，并返回挂起原因与票据。
+func expectStepUp(t *testing.T, err error) *StepUpRequiredError {
+	t.Helper()
+	var stepUp *StepUpRequiredError
+	if !errors.As(err, &stepUp) {
+		t.Fatalf("expected step-up, got %v", err)
+	}
+	return stepUp
+}
+
+func TestAssessLoginFlagsNewDeviceAndNetwork(t *testing.T) {
+	s, user, _, _, _ := newRiskTestService(t)
+
+	cases := []struct {
+		name   string
+		client ClientInfo
+		want   []string
+	}{
+		{"known", ClientInfo{Device: "phone", IP: "10.0.0.77"}, nil},
+		{"new device", ClientInfo{Device: "laptop", IP: "10.0.0.2"}, []string{RiskNewDevice}},
+		{"new network", ClientInfo{Device: "phone", IP: "203.0.113.5"}, []string{RiskNewNetwork}},
+		{"both", ClientInfo{Device: "laptop", IP: "203.0.113.5"}, []string{RiskNewDevice, RiskNewNetwork}},
+	}
+	for _, tc := range cases {
+		reasons, trusted := s.assessLogin(user, tc.client)
+		if !slices.Equal(reasons, tc.want) || trusted {
+			t.Errorf("%s: reasons %v trusted %v, want %v", tc.name, reasons, trusted, tc.want)
+		}
+	}
+}
+
+func TestSuspiciousLoginNotifiesAndRequiresStepUp(t *testing.T) {
+	s, _, rec, notices, _ := newRiskTestService(t)
+	client := ClientInfo{Device: "laptop", IP: "203.0.113.5"}
+
+	_, _, err := s.Login("sara", "Secret#2024", client)
+	stepUp := expectStepUp(t, err)
+	if !slices.Equal(stepUp.Reasons, []string{RiskNewDevice, RiskNewNetwork}) {
+		t.Fatalf("reasons = %v", stepUp.Reasons)
+	}
+	notices.expect(t, true)
+
+	code := rec.lastCode(t, "13800000080")
+	if _, err := s.CompleteStepUp(stepUp.Token, wrongCode(code), false); !errors.Is(err, ErrOTPInvalid) {
+		t.Fatalf("wrong code: %v", err)
+	}
+	result, err := s.CompleteStepUp(stepUp.Token, code, false)
+	if err != nil {
+		t.Fatalf("step-up: %v", err)
+	}
+	if result.AccessToken == "" || result.RefreshToken == "" || result.DeviceTrust != "" {
+		t.Fatalf("result = %+v", result)
+	}
+	// 票据只能使用一次
+	if _, err := s.CompleteStepUp(stepUp.Token, code, false); !errors.Is(err, ErrStepUpChallenge) {
+		t.Fatalf("reused step-up token: %v", err)
+	}
+
+	// 确认后设备与网络都已记住
+	if _, _, err := s.Login("sara", "Secret#2024", client); err != nil {
+		t.Fatalf("login from remembered device: %v", err)
+	}
+	notices.expect(t, false)
+}
+
+func TestTrustedDeviceNeedsIssuedSecret(t *testing.T) {
+	s, user, rec, notices, clock := newRiskTestService(t)
+	laptop := ClientInfo{Device: "laptop", IP: "10.0.0.2"}
+
+	_, _, err := s.Login("sara", "Secret#2024", laptop)
+	stepUp := expectStepUp(t, err)
+	notices.expect(t, true)
+	result, err := s.CompleteStepUp(stepUp.Token, rec.lastCode(t, "13800000080"), true)
+	if err != nil {
+		t.Fatalf("step-up: %v", err)
+	}
+	if result.DeviceTrust == "" {
+		t.Fatal("trusting the device should issue a secret")
+	}
+	clock.Advance(2 * time.Minute)
+
+	// 只报设备名不足以冒充可信设备
+	away := ClientInfo{Device: "laptop", IP: "203.0.113.5"}
+	for _, secret := range []string{"", "forged"} {
+		away.DeviceTrust = secret
+		_, _, err := s.Login("sara", "Secret#2024", away)
+		expectStepUp(t, err)
+		notices.expect(t, true)
+		clock.Advance(2 * time.Minute)
+	}
+
+	// 携带凭证免去短信确认，但陌生网络仍会提醒
+	away.DeviceTrust = result.DeviceTrust
+	if _, _, err := s.Login("sara", "Secret#2024", away); err != nil {
+		t.Fatalf("trusted login: %v", err)
+	}
+	notices.expect(t, true)
+
+	// 只能信任当前设备；取消可信后凭证失效
+	if _, err := s.TrustDevice(uint(user.ID), "phone", "laptop"); !errors.Is(err, ErrTrustOtherDevice) {
+		t.Fatalf("trust other device: %v", err)
+	}
+	if err := s.UntrustDevice(uint(user.ID), "laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if reasons, trusted := s.assessLogin(user, away); trusted || len(reasons) != 0 {
+		t.Fatalf("after untrust: reasons %v trusted %v", reasons, trusted)
+	}
+	secret, err := s.TrustDevice(uint(user.ID), "laptop", "laptop")
+	if err != nil || secret == result.DeviceTrust {
+		t.Fatalf("re-trust = %q, %v", secret, err)
+	}
+	away.DeviceTrust = secret
+	if _, trusted := s.assessLogin(user, away); !trusted {
+		t.Fatal("new secret should be trusted")
+	}
+}
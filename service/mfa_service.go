@@ -0,0 +1,364 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"redbook/config"
+	"redbook/internal/audit"
+	"redbook/internal/auth"
+	"redbook/internal/metrics"
+	"redbook/model"
+	"redbook/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	recoveryCodeCount    = 10
+	mfaEnrollTTL         = 10 * time.Minute
+	mfaMaxAttempts       = 5
+	defaultChallengeTTL  = 5 * time.Minute
+	defaultMFAIssuer     = "Redbook"
+	totpUsedStepCacheTTL = 2 * time.Minute
+	// totpStepCASAttempts 限制记录已用时间步时 compare-and-set 的重试次数
+	totpStepCASAttempts = 5
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication already enabled")
+	ErrMFANotEnabled     = errors.New("two-factor authentication not enabled")
+	ErrMFANotEnrolling   = errors.New("no pending two-factor enrollment")
+	ErrMFACodeInvalid    = errors.New("two-factor code invalid")
+	ErrMFAChallenge      = errors.New("two-factor challenge invalid or expired")
+)
+
+// MFARequiredError is returned by the login methods when the account has 2FA
+// enabled. The client completes the login by submitting Token together with a
+// TOTP or recovery code.
+type MFARequiredError struct {
+	Token     string
+	ExpiresIn int64
+}
+
+func (e *MFARequiredError) Error() string {
+	return "two-factor authentication required"
+}
+
+// pendingClient is the part of ClientInfo kept while a login waits for a
+// second step, so the session finally issued describes the original client.
+type pendingClient struct {
+	Device     string `json:"device"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	DeviceName string `json:"device_name,omitempty"`
+	Platform   string `json:"platform,omitempty"`
+	AppVersion string `json:"app_version,omitempty"`
+	// JKT 首次认证时 DPoP 证明的公钥指纹，完成登录后签发的 token 绑定该公钥
+	JKT string `json:"jkt,omitempty"`
+}
+
+func newPendingClient(c ClientInfo) pendingClient {
+	return pendingClient{
+		Device:     c.Device,
+		IP:         c.IP,
+		UserAgent:  c.UserAgent,
+		DeviceName: c.DeviceName,
+		Platform:   c.Platform,
+		AppVersion: c.AppVersion,
+		JKT:        c.DPoPKey,
+	}
+}
+
+func (p pendingClient) client() ClientInfo {
+	return ClientInfo{
+		Device:     p.Device,
+		IP:         p.IP,
+		UserAgent:  p.UserAgent,
+		DeviceName: p.DeviceName,
+		Platform:   p.Platform,
+		AppVersion: p.AppVersion,
+		DPoPKey:    p.JKT,
+	}
+}
+
+// mfaChallenge is the pending login parked in the store until the second factor arrives.
+type mfaChallenge struct {
+	UserID uint64 `json:"user_id"`
+	// ExpiresAt 挑战的到期时间（Unix 秒），校验失败放回时沿用剩余有效期
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	pendingClient
+}
+
+// MFAEnrollment is returned when a user starts enrolling an authenticator.
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// completeLogin finishes a first-factor login: accounts with 2FA get a challenge
+// instead of tokens, suspicious logins may need SMS confirmation, everyone else
+// gets a session straight away. smsVerified tells that the first factor was an
+// SMS code, which already proves control of the mobile.
+func (s *UserService) completeLogin(user *model.User, client ClientInfo, smsVerified bool) (string, string, error) {
+	reasons, trusted := s.assessLogin(user, client)
+	if len(reasons) > 0 {
+		s.notifySuspiciousLogin(user, client, reasons)
+	}
+	if !user.TOTPEnabled {
+		// 可信设备仍会收到提醒，但已凭信任凭证证明身份，无需再短信确认
+		if len(reasons) > 0 && !trusted && stepUpRequired(user, smsVerified) {
+			return "", "", s.startStepUp(user, client, reasons)
+		}
+		return s.issueSession(user, client)
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	ttl := challengeTTL()
+	data, err := json.Marshal(mfaChallenge{UserID: user.ID, ExpiresAt: time.Now().Add(ttl).Unix(), pendingClient: newPendingClient(client)})
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.Session.Store().Set(mfaChallengeKey(token), string(data), ttl); err != nil {
+		return "", "", err
+	}
+	return "", "", &MFARequiredError{Token: token, ExpiresIn: int64(ttl.Seconds())}
+}
+
+// CompleteMFALogin exchanges a login challenge plus a TOTP or recovery code for
+// a token pair. The session is bound to the device that passed the first factor.
+// The challenge is taken out of the store before the code is checked, so two
+// concurrent submissions can never both complete the login; a wrong code puts
+// it back until the attempts run out.
+func (s *UserService) CompleteMFALogin(challengeToken, code, recoveryCode string) (string, string, error) {
+	store := s.Session.Store()
+	key := mfaChallengeKey(challengeToken)
+	raw, err := store.GetDel(key)
+	if err != nil {
+		return "", "", ErrMFAChallenge
+	}
+	var ch mfaChallenge
+	if err := json.Unmarshal([]byte(raw), &ch); err != nil {
+		return "", "", ErrMFAChallenge
+	}
+
+	attempts, err := store.Incr(key+":try", challengeTTL())
+	if err != nil {
+		return "", "", err
+	}
+	if attempts > mfaMaxAttempts {
+		_ = store.Del(key + ":try")
+		metrics.IncSecurityEvent("mfa_attempts_exceeded")
+		return "", "", ErrMFAChallenge
+	}
+
+	client := ch.client()
+	entry := model.AuditEvent{Event: audit.EventLoginMFA, UserID: ch.UserID}
+	user, err := s.dao.GetByID(ch.UserID)
+	if err != nil || !user.TOTPEnabled {
+		RecordAudit(entry, client, ErrMFAChallenge)
+		return "", "", ErrMFAChallenge
+	}
+	// 挑战内的尝试次数只限制单个挑战；重新输入密码可以换新挑战，所以错误的第二因素同样计入账号锁定
+	ids := accountIdentifiers(user)
+	for _, id := range ids {
+		if err := s.checkLockout(id); err != nil {
+			s.restoreChallenge(key, raw, ch)
+			RecordAudit(entry, client, err)
+			return "", "", err
+		}
+	}
+	if err := s.verifySecondFactor(user, code, recoveryCode); err != nil {
+		s.restoreChallenge(key, raw, ch)
+		locked := false
+		if errors.Is(err, ErrMFACodeInvalid) {
+			for _, id := range ids {
+				locked = s.recordLoginFailure(id) || locked
+			}
+		}
+		auditLoginFailure(entry, client, err, locked)
+		return "", "", err
+	}
+
+	_ = store.Del(key + ":try")
+	s.clearLoginFailures(ids...)
+	access, refresh, err := s.issueSession(user, client)
+	RecordAudit(entry, client, err)
+	return access, refresh, err
+}
+
+// restoreChallenge parks a challenge again after a failed attempt for the rest
+// of its lifetime. Challenges written before ExpiresAt existed get a full TTL.
+func (s *UserService) restoreChallenge(key, raw string, ch mfaChallenge) {
+	ttl := challengeTTL()
+	if ch.ExpiresAt > 0 {
+		ttl = time.Until(time.Unix(ch.ExpiresAt, 0))
+	}
+	if ttl <= 0 {
+		return
+	}
+	_, _ = s.Session.Store().CompareAndSet(key, "", raw, ttl)
+}
+
+// EnrollTOTP generates a secret that stays pending until ConfirmTOTP succeeds.
+func (s *UserService) EnrollTOTP(userID uint) (*MFAEnrollment, error) {
+	user, err := s.dao.GetByID(uint64(userID))
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Session.Store().Set(mfaPendingKey(userID), secret, mfaEnrollTTL); err != nil {
+		return nil, err
+	}
+	return &MFAEnrollment{Secret: secret, URI: auth.TOTPURI(mfaIssuer(), user.Username, secret)}, nil
+}
+
+// ConfirmTOTP enables 2FA once the user proves the authenticator works and
+// returns the one-time recovery codes. They are shown only this once.
+func (s *UserService) ConfirmTOTP(userID uint, code string) ([]string, error) {
+	store := s.Session.Store()
+	secret, err := store.Get(mfaPendingKey(userID))
+	if err != nil {
+		return nil, ErrMFANotEnrolling
+	}
+	step, ok := auth.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, ErrMFACodeInvalid
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.dao.EnableTOTP(uint64(userID), secret, hashes); err != nil {
+		return nil, err
+	}
+	_ = store.Del(mfaPendingKey(userID))
+	_ = store.Set(totpUsedStepKey(userID), strconv.FormatInt(step, 10), totpUsedStepCacheTTL)
+	return codes, nil
+}
+
+// DisableTOTP turns 2FA off after re-checking a TOTP or recovery code.
+func (s *UserService) DisableTOTP(userID uint, code, recoveryCode string) error {
+	user, err := s.dao.GetByID(uint64(userID))
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrMFANotEnabled
+	}
+	if err := s.verifySecondFactor(user, code, recoveryCode); err != nil {
+		return err
+	}
+	return s.dao.DisableTOTP(user.ID)
+}
+
+// verifySecondFactor accepts either a TOTP code (never the same time step twice)
+// or an unused recovery code, which is consumed.
+func (s *UserService) verifySecondFactor(user *model.User, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		ok, err := s.dao.UseRecoveryCode(user.ID, hashRecoveryCode(recoveryCode))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			metrics.IncSecurityEvent("mfa_failed")
+			return ErrMFACodeInvalid
+		}
+		metrics.IncSecurityEvent("mfa_recovery_code_used")
+		return nil
+	}
+
+	step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		metrics.IncSecurityEvent("mfa_failed")
+		return ErrMFACodeInvalid
+	}
+	// 记录已用时间步与比较在同一次 compare-and-set 中完成，并发提交同一个码只有一个能通过
+	store := s.Session.Store()
+	key := totpUsedStepKey(uint(user.ID))
+	for i := 0; i < totpStepCASAttempts; i++ {
+		last, err := store.Get(key)
+		if err != nil && !errors.Is(err, auth.ErrNotFound) {
+			return err
+		}
+		if prev, _ := strconv.ParseInt(last, 10, 64); last != "" && step <= prev {
+			metrics.IncSecurityEvent("mfa_code_replayed")
+			return ErrMFACodeInvalid
+		}
+		ok, err := store.CompareAndSet(key, last, strconv.FormatInt(step, 10), totpUsedStepCacheTTL)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	metrics.IncSecurityEvent("mfa_code_replayed")
+	return ErrMFACodeInvalid
+}
+
+// newRecoveryCodes returns the plaintext codes for the user and their hashes for storage.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(enc.EncodeToString(b))
+		code := raw[:4] + "-" + raw[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalizes user input (case, dashes, spaces) before hashing.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+func challengeTTL() time.Duration {
+	if ttl := config.GlobalConfig.MFA.ChallengeTTL; ttl > 0 {
+		return time.Duration(ttl) * time.Second
+	}
+	return defaultChallengeTTL
+}
+
+func mfaIssuer() string {
+	if issuer := config.GlobalConfig.MFA.Issuer; issuer != "" {
+		return issuer
+	}
+	return defaultMFAIssuer
+}
+
+func mfaChallengeKey(token string) string {
+	return fmt.Sprintf("rb:mfa:challenge:%s", token)
+}
+
+func mfaPendingKey(userID uint) string {
+	return fmt.Sprintf("rb:mfa:pending:%d", userID)
+}
+
+func totpUsedStepKey(userID uint) string {
+	return fmt.Sprintf("rb:mfa:step:%d", userID)
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"redbook/internal/auth"
+	"redbook/model"
+
+	"gorm.io/gorm"
+)
+
+// enableTestMFA 为用户开启 2FA 并返回恢复码，测试用恢复码完成第二步。
+func enableTestMFA(t *testing.T, s *UserService, user *model.User) []string {
+	t.Helper()
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.dao.EnableTOTP(user.ID, secret, hashes); err != nil {
+		t.Fatal(err)
+	}
+	return codes
+}
+
+// startMFALogin 用密码登录并返回 2FA 挑战 token。
+func startMFALogin(t *testing.T, s *UserService, db *gorm.DB) (*model.User, []string, string) {
+	t.Helper()
+	user := createTestUser(t, db, "carol", "13800000010", "Secret#2024")
+	warmTokenVersion(t, s, uint(user.ID))
+	codes := enableTestMFA(t, s, user)
+	_, _, err := s.Login("carol", "Secret#2024", ClientInfo{Device: "phone", IP: "10.0.0.1"})
+	var mfa *MFARequiredError
+	if !errors.As(err, &mfa) {
+		t.Fatalf("login = %v, want MFA challenge", err)
+	}
+	return user, codes, mfa.Token
+}
+
+func TestCompleteMFALoginConsumesChallenge(t *testing.T) {
+	s, db := newDBTestService(t)
+	_, codes, challenge := startMFALogin(t, s, db)
+
+	if _, _, err := s.CompleteMFALogin(challenge, "", codes[0]); err != nil {
+		t.Fatalf("complete with a recovery code: %v", err)
+	}
+	// 同一挑战不能再换取第二个会话
+	if _, _, err := s.CompleteMFALogin(challenge, "", codes[1]); !errors.Is(err, ErrMFAChallenge) {
+		t.Fatalf("reused challenge = %v", err)
+	}
+}
+
+func TestCompleteMFALoginWrongCodeKeepsChallenge(t *testing.T) {
+	s, db := newDBTestService(t)
+	clock := useFakeClock(s)
+	_, codes, challenge := startMFALogin(t, s, db)
+
+	if _, _, err := s.CompleteMFALogin(challenge, "", "WRONGCODE"); !errors.Is(err, ErrMFACodeInvalid) {
+		t.Fatalf("wrong code = %v", err)
+	}
+	// 错误的第二因素同样触发递增延迟，延迟内挑战仍保留
+	var throttled *LoginThrottledError
+	if _, _, err := s.CompleteMFALogin(challenge, "", codes[0]); !errors.As(err, &throttled) {
+		t.Fatalf("retry inside the backoff delay = %v", err)
+	}
+	clock.Advance(time.Minute)
+	if _, _, err := s.CompleteMFALogin(challenge, "", codes[0]); err != nil {
+		t.Fatalf("challenge should survive a wrong code: %v", err)
+	}
+}
+
+func TestCompleteMFALoginAttemptsExhausted(t *testing.T) {
+	s, db := newDBTestService(t)
+	clock := useFakeClock(s)
+	_, codes, challenge := startMFALogin(t, s, db)
+
+	for i := 0; i < mfaMaxAttempts; i++ {
+		if _, _, err := s.CompleteMFALogin(challenge, "", "WRONGCODE"); !errors.Is(err, ErrMFACodeInvalid) {
+			t.Fatalf("attempt %d = %v", i+1, err)
+		}
+		clock.Advance(30 * time.Second)
+	}
+	if _, _, err := s.CompleteMFALogin(challenge, "", codes[0]); !errors.Is(err, ErrMFAChallenge) {
+		t.Fatalf("challenge should be burnt after %d failures, got %v", mfaMaxAttempts, err)
+	}
+}
+
+func TestMFAFailuresCountTowardsAccountLockout(t *testing.T) {
+	s, db := newDBTestService(t)
+	clock := useFakeClock(s)
+	user, codes, _ := startMFALogin(t, s, db)
+	// 失败累积后会要求验证码，这里视为已由限流中间件校验
+	client := ClientInfo{Device: "phone", CaptchaPassed: true}
+
+	// 每轮都用正确的密码换新挑战，再提交错误的 TOTP：密码正确不能清零失败计数
+	for i := int64(0); i < lockoutSettings().Threshold; i++ {
+		_, _, err := s.Login("carol", "Secret#2024", client)
+		var mfa *MFARequiredError
+		if !errors.As(err, &mfa) {
+			t.Fatalf("round %d: login = %v", i+1, err)
+		}
+		if _, _, err := s.CompleteMFALogin(mfa.Token, "000000", ""); !errors.Is(err, ErrMFACodeInvalid) {
+			t.Fatalf("round %d: wrong code = %v", i+1, err)
+		}
+		clock.Advance(time.Minute)
+	}
+
+	var throttled *LoginThrottledError
+	if _, _, err := s.Login("carol", "Secret#2024", client); !errors.As(err, &throttled) || !throttled.Locked {
+		t.Fatalf("login after repeated 2FA failures = %v, want account locked", err)
+	}
+	if err := s.checkLockout(mobileIdentifier(user.Mobile)); err == nil {
+		t.Fatal("SMS login should be locked as well")
+	}
+
+	// 解锁后完成第二因素，失败计数清零
+	if err := s.UnlockAccount(uint(user.ID)); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := s.Login("carol", "Secret#2024", client)
+	var mfa *MFARequiredError
+	if !errors.As(err, &mfa) {
+		t.Fatalf("login after unlock = %v", err)
+	}
+	if _, _, err := s.CompleteMFALogin(mfa.Token, "", codes[0]); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Session.Store().Get(lockFailKey(usernameIdentifier("carol"))); err == nil {
+		t.Fatalf("failure counter = %s after a complete login", n)
+	}
+}
+
+func TestTOTPCodeAcceptedOnceUnderConcurrency(t *testing.T) {
+	s, db := newDBTestService(t)
+	user, _, _ := startMFALogin(t, s, db)
+	user, err := s.dao.GetByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := auth.TOTPCode(user.TOTPSecret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一次提交检查通过、写入已用时间步之前，第二次提交同一个码
+	store := useFaultyStore(s)
+	var first error
+	store.beforeCAS = func(key string) {
+		if key != totpUsedStepKey(uint(user.ID)) {
+			return
+		}
+		store.beforeCAS = nil
+		first = s.verifySecondFactor(user, code, "")
+	}
+	second := s.verifySecondFactor(user, code, "")
+	if first != nil || !errors.Is(second, ErrMFACodeInvalid) {
+		t.Fatalf("same code accepted twice: first %v, second %v", first, second)
+	}
+}
@@ -0,0 +1,496 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"redbook/config"
+	"redbook/dao"
+	"redbook/internal/auth"
+	"redbook/model"
+	"redbook/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultOAuthCodeTTL = time.Minute
+	// oauthDevicePrefix 标记 OAuth 授权产生的会话，每个用户在每个客户端下只有一个会话。
+	oauthDevicePrefix = "oauth:"
+	pkceMethodS256    = "S256"
+)
+
+var defaultOAuthScopes = []string{"profile"}
+
+// OAuthError mirrors the error responses of RFC 6749 §4.1.2.1 / §5.2.
+type OAuthError struct {
+	Code        string
+	Description string
+	Status      int
+	// RedirectTo 非空时应把错误通过重定向返回给客户端，而不是直接展示。
+	RedirectTo string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *OAuthError {
+	status := http.StatusBadRequest
+	if code == "invalid_client" {
+		status = http.StatusUnauthorized
+	}
+	return &OAuthError{Code: code, Description: description, Status: status}
+}
+
+// AuthorizeRequest carries the parameters of the authorization endpoint.
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// AuthorizeResult tells the caller either to ask the user for consent or where
+// to send the browser next.
+type AuthorizeResult struct {
+	ConsentRequired bool     `json:"consent_required"`
+	ClientName      string   `json:"client_name,omitempty"`
+	Scopes          []string `json:"scopes,omitempty"`
+	RedirectTo      string   `json:"redirect_to,omitempty"`
+}
+
+// TokenRequest carries the parameters of the token endpoint.
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	ClientID     string
+	ClientSecret string
+}
+
+// TokenResponse is the RFC 6749 §5.1 success body.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// authorizationCode is what an issued code points to until it is redeemed.
+type authorizationCode struct {
+	ClientID      string `json:"client_id"`
+	UserID        uint   `json:"user_id"`
+	RedirectURI   string `json:"redirect_uri"`
+	Scope         string `json:"scope"`
+	CodeChallenge string `json:"code_challenge"`
+}
+
+// OAuthService implements the authorization-code + PKCE flow on top of the
+// regular session machinery, so OAuth tokens rotate and revoke like any other.
+type OAuthService struct {
+	dao   *dao.OAuthDAO
+	users *UserService
+}
+
+func NewOAuthService(dao *dao.OAuthDAO, users *UserService) *OAuthService {
+	return &OAuthService{dao: dao, users: users}
+}
+
+// SeedClients upserts the clients declared in config (usually our own apps).
+func (o *OAuthService) SeedClients(clients []config.OAuthClientConfig) error {
+	for _, c := range clients {
+		client := &model.OAuthClient{
+			ClientID:     c.ClientID,
+			Name:         c.Name,
+			RedirectURIs: strings.Join(c.RedirectURIs, " "),
+			Scopes:       strings.Join(c.Scopes, " "),
+			FirstParty:   c.FirstParty,
+		}
+		if c.ClientSecret != "" {
+			client.SecretHash = hashClientSecret(c.ClientSecret)
+		}
+		if err := o.dao.UpsertClient(client); err != nil {
+			return fmt.Errorf("seed oauth client %s: %w", c.ClientID, err)
+		}
+	}
+	return nil
+}
+
+// RegisterClient creates a third-party client owned by the user. The secret of a
+// confidential client is returned only once.
+func (o *OAuthService) RegisterClient(ownerID uint, name string, redirectURIs, scopes []string, public bool) (*model.OAuthClient, string, error) {
+	for _, uri := range redirectURIs {
+		if !validRedirectURI(uri) {
+			return nil, "", oauthError("invalid_redirect_uri", "redirect uri must be an absolute URI without fragment: "+uri)
+		}
+	}
+	if len(scopes) == 0 {
+		scopes = defaultOAuthScopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(supportedScopes(), scope) {
+			return nil, "", oauthError("invalid_scope", "unsupported scope: "+scope)
+		}
+	}
+
+	suffix, err := utils.RandomToken(12)
+	if err != nil {
+		return nil, "", err
+	}
+	client := &model.OAuthClient{
+		ClientID:     "rbc_" + suffix,
+		Name:         name,
+		RedirectURIs: strings.Join(redirectURIs, " "),
+		Scopes:       strings.Join(scopes, " "),
+		OwnerID:      uint64(ownerID),
+	}
+	var secret string
+	if !public {
+		if secret, err = utils.RandomToken(32); err != nil {
+			return nil, "", err
+		}
+		client.SecretHash = hashClientSecret(secret)
+	}
+	if err := o.dao.CreateClient(client); err != nil {
+		return nil, "", err
+	}
+	return client, secret, nil
+}
+
+// Authorize validates an authorization request for the signed-in user. With a
+// nil decision it only checks whether consent is needed; otherwise it records
+// the user's answer. A code is issued once consent exists (or the client is
+// first-party).
+func (o *OAuthService) Authorize(userID uint, req AuthorizeRequest, decision *bool) (*AuthorizeResult, error) {
+	client, err := o.dao.GetClient(req.ClientID)
+	if err != nil {
+		return nil, oauthError("invalid_client", "unknown client")
+	}
+	// redirect_uri 校验失败时绝不能重定向，防止开放跳转
+	if !slices.Contains(strings.Fields(client.RedirectURIs), req.RedirectURI) {
+		return nil, oauthError("invalid_request", "redirect_uri not registered for client")
+	}
+
+	if req.ResponseType != "code" {
+		return nil, redirectError(req, "unsupported_response_type", "only response_type=code is supported")
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != pkceMethodS256 {
+		return nil, redirectError(req, "invalid_request", "PKCE with code_challenge_method=S256 is required")
+	}
+	scopes, err := resolveScopes(client, req.Scope)
+	if err != nil {
+		return nil, redirectError(req, "invalid_scope", err.Error())
+	}
+
+	if decision == nil {
+		if !client.FirstParty && !o.hasConsent(userID, client.ClientID, scopes) {
+			return &AuthorizeResult{ConsentRequired: true, ClientName: client.Name, Scopes: scopes}, nil
+		}
+	} else if !*decision {
+		return nil, redirectError(req, "access_denied", "the user denied the request")
+	} else if !client.FirstParty {
+		if err := o.grantConsent(userID, client.ClientID, scopes); err != nil {
+			return nil, err
+		}
+	}
+
+	code, err := o.issueCode(authorizationCode{
+		ClientID:      client.ClientID,
+		UserID:        userID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         strings.Join(scopes, " "),
+		CodeChallenge: req.CodeChallenge,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &AuthorizeResult{RedirectTo: withQuery(req.RedirectURI, url.Values{"code": {code}, "state": {req.State}})}, nil
+}
+
+// Exchange implements the token endpoint for the authorization_code and
+// refresh_token grants.
+func (o *OAuthService) Exchange(req TokenRequest, client ClientInfo) (*TokenResponse, error) {
+	oc, err := o.AuthenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.GrantType {
+	case "authorization_code":
+		return o.exchangeCode(oc, req, client)
+	case "refresh_token":
+		return o.exchangeRefresh(oc, req, client)
+	default:
+		return nil, oauthError("unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+	}
+}
+
+// AuthenticateClient checks the client credentials. Public clients have no
+// secret and rely on PKCE instead.
+func (o *OAuthService) AuthenticateClient(clientID, secret string) (*model.OAuthClient, error) {
+	if clientID == "" {
+		return nil, oauthError("invalid_client", "client authentication required")
+	}
+	client, err := o.dao.GetClient(clientID)
+	if err != nil {
+		return nil, oauthError("invalid_client", "unknown client")
+	}
+	if client.SecretHash == "" {
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(hashClientSecret(secret))) != 1 {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+	return client, nil
+}
+
+// authenticateConfidential only accepts clients that hold a secret, as required
+// for the introspection and revocation endpoints.
+func (o *OAuthService) authenticateConfidential(clientID, secret string) (*model.OAuthClient, error) {
+	client, err := o.AuthenticateClient(clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+	if client.SecretHash == "" {
+		return nil, oauthError("invalid_client", "confidential client credentials required")
+	}
+	return client, nil
+}
+
+func (o *OAuthService) exchangeCode(oc *model.OAuthClient, req TokenRequest, client ClientInfo) (*TokenResponse, error) {
+	raw, err := o.users.Session.Store().GetDel(oauthCodeKey(req.Code))
+	if err != nil {
+		return nil, oauthError("invalid_grant", "authorization code invalid or expired")
+	}
+	var code authorizationCode
+	if err := json.Unmarshal([]byte(raw), &code); err != nil {
+		return nil, oauthError("invalid_grant", "authorization code invalid or expired")
+	}
+	if code.ClientID != oc.ClientID || code.RedirectURI != req.RedirectURI {
+		return nil, oauthError("invalid_grant", "authorization code was issued to another client or redirect_uri")
+	}
+	if !verifyPKCE(req.CodeVerifier, code.CodeChallenge) {
+		return nil, oauthError("invalid_grant", "code_verifier does not match code_challenge")
+	}
+
+	user, err := o.users.dao.GetByID(uint64(code.UserID))
+	if err != nil {
+		return nil, oauthError("invalid_grant", "user no longer exists")
+	}
+	access, refresh, err := o.users.startSession(auth.TokenParams{
+		UserID:       code.UserID,
+		Device:       oauthDevicePrefix + oc.ClientID,
+		TokenVersion: user.TokenVersion,
+		ClientID:     oc.ClientID,
+		Scope:        code.Scope,
+	}, client)
+	if err != nil {
+		return nil, err
+	}
+	return tokenResponse(access, refresh)
+}
+
+func (o *OAuthService) exchangeRefresh(oc *model.OAuthClient, req TokenRequest, client ClientInfo) (*TokenResponse, error) {
+	claims, err := auth.ParseToken(req.RefreshToken)
+	if err != nil || claims.ClientID != oc.ClientID {
+		return nil, oauthError("invalid_grant", "refresh token invalid")
+	}
+	// 与一方登录共用同一套轮换与重放检测逻辑
+	client.Device = claims.Device
+	access, refresh, err := o.users.RotateRefreshToken(req.RefreshToken, client)
+	if err != nil {
+		return nil, oauthError("invalid_grant", err.Error())
+	}
+	return tokenResponse(access, refresh)
+}
+
+// ListConsents returns the third-party apps the user has authorized.
+func (o *OAuthService) ListConsents(userID uint) ([]model.OAuthConsent, error) {
+	return o.dao.ListConsents(uint64(userID))
+}
+
+// RevokeConsent withdraws the grant and signs the client's session out.
+func (o *OAuthService) RevokeConsent(userID uint, clientID string) error {
+	found, err := o.dao.DeleteConsent(uint64(userID), clientID)
+	if err != nil {
+		return err
+	}
+	if err := o.users.RevokeSession(userID, oauthDevicePrefix+clientID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+	if !found {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (o *OAuthService) hasConsent(userID uint, clientID string, scopes []string) bool {
+	consent, err := o.dao.GetConsent(uint64(userID), clientID)
+	if err != nil {
+		return false
+	}
+	granted := strings.Fields(consent.Scopes)
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// grantConsent merges the newly approved scopes into the stored consent.
+func (o *OAuthService) grantConsent(userID uint, clientID string, scopes []string) error {
+	merged := slices.Clone(scopes)
+	consent, err := o.dao.GetConsent(uint64(userID), clientID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if consent != nil {
+		for _, scope := range strings.Fields(consent.Scopes) {
+			if !slices.Contains(merged, scope) {
+				merged = append(merged, scope)
+			}
+		}
+	}
+	return o.dao.SaveConsent(&model.OAuthConsent{
+		UserID:   uint64(userID),
+		ClientID: clientID,
+		Scopes:   strings.Join(merged, " "),
+	})
+}
+
+func (o *OAuthService) issueCode(code authorizationCode) (string, error) {
+	value, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(code)
+	if err != nil {
+		return "", err
+	}
+	ttl := defaultOAuthCodeTTL
+	if cfg := config.GlobalConfig.OAuth.CodeTTL; cfg > 0 {
+		ttl = time.Duration(cfg) * time.Second
+	}
+	if err := o.users.Session.Store().Set(oauthCodeKey(value), string(data), ttl); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// resolveScopes defaults an empty request to the client's scopes and rejects
+// anything the client was not registered for.
+func resolveScopes(client *model.OAuthClient, requested string) ([]string, error) {
+	allowed := strings.Fields(client.Scopes)
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return allowed, nil
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(allowed, scope) || !slices.Contains(supportedScopes(), scope) {
+			return nil, fmt.Errorf("scope %q not allowed for client", scope)
+		}
+	}
+	return scopes, nil
+}
+
+func supportedScopes() []string {
+	if scopes := config.GlobalConfig.OAuth.Scopes; len(scopes) > 0 {
+		return scopes
+	}
+	return defaultOAuthScopes
+}
+
+// verifyPKCE checks BASE64URL(SHA256(verifier)) against the stored challenge (RFC 7636 §4.6).
+func verifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+func redirectError(req AuthorizeRequest, code, description string) *OAuthError {
+	e := oauthError(code, description)
+	params := url.Values{"error": {code}, "error_description": {description}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	e.RedirectTo = withQuery(req.RedirectURI, params)
+	return e
+}
+
+func withQuery(rawURL string, params url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	for k, vs := range params {
+		for _, v := range vs {
+			if v != "" {
+				q.Set(k, v)
+			}
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.IsAbs() && u.Fragment == ""
+}
+
+// tokenResponse builds the token endpoint body from the claims of the access
+// token just issued. A token bound to a DPoP key (cnf.jkt) is reported with
+// token_type DPoP, as RFC 9449 §5 requires, so the client knows to send it
+// with the DPoP scheme and a proof. expires_in follows the token's own exp,
+// which the session's absolute lifetime may have pulled in.
+func tokenResponse(access, refresh string) (*TokenResponse, error) {
+	issued, err := auth.ParseToken(access)
+	if err != nil {
+		return nil, err
+	}
+	tokenType := "Bearer"
+	if issued.BoundKey() != "" {
+		tokenType = "DPoP"
+	}
+	var expiresIn int64
+	if issued.ExpiresAt != nil {
+		expiresIn = max(int64(time.Until(issued.ExpiresAt.Time).Round(time.Second).Seconds()), 0)
+	}
+	return &TokenResponse{
+		AccessToken:  access,
+		TokenType:    tokenType,
+		ExpiresIn:    expiresIn,
+		RefreshToken: refresh,
+		Scope:        issued.Scope,
+	}, nil
+}
+
+func hashClientSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func oauthCodeKey(code string) string {
+	return fmt.Sprintf("rb:oauth:code:%s", code)
+}
@@ -0,0 +1,324 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"redbook/dao"
+	"redbook/internal/auth"
+	"redbook/model"
+
+	"gorm.io/gorm"
+)
+
+const testRedirectURI = "https://app.example.com/callback"
+
+// testVerifier 为满足 RFC 7636 长度要求的 PKCE code_verifier。
+var testVerifier = strings.Repeat("v", 43)
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+type oauthFixture struct {
+	db     *gorm.DB
+	oauth  *OAuthService
+	users  *UserService
+	user   *model.User
+	client *model.OAuthClient
+	secret string
+}
+
+// newOAuthFixture 准备一个用户和一个已注册的机密客户端。
+func newOAuthFixture(t *testing.T) *oauthFixture {
+	t.Helper()
+	s, db := newDBTestService(t)
+	o := NewOAuthService(dao.NewOAuthDAO(db), s)
+	user := createTestUser(t, db, "dave", "13800000020", "Secret#2024")
+	warmTokenVersion(t, s, uint(user.ID))
+	client, secret, err := o.RegisterClient(uint(user.ID), "demo", []string{testRedirectURI}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &oauthFixture{db: db, oauth: o, users: s, user: user, client: client, secret: secret}
+}
+
+// authorize 以用户同意的方式走授权端点并返回授权码。
+func (f *oauthFixture) authorize(t *testing.T, verifier string) string {
+	t.Helper()
+	approve := true
+	res, err := f.oauth.Authorize(uint(f.user.ID), AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            f.client.ClientID,
+		RedirectURI:         testRedirectURI,
+		State:               "xyz",
+		CodeChallenge:       pkceChallenge(verifier),
+		CodeChallengeMethod: pkceMethodS256,
+	}, &approve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(res.RedirectTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Query().Get("state") != "xyz" {
+		t.Fatalf("state not echoed: %s", res.RedirectTo)
+	}
+	return u.Query().Get("code")
+}
+
+func (f *oauthFixture) exchange(code, redirectURI, verifier string) (*TokenResponse, error) {
+	return f.oauth.Exchange(TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  redirectURI,
+		CodeVerifier: verifier,
+		ClientID:     f.client.ClientID,
+		ClientSecret: f.secret,
+	}, ClientInfo{IP: "10.0.0.1"})
+}
+
+func oauthErrorCode(err error) string {
+	var oe *OAuthError
+	if errors.As(err, &oe) {
+		return oe.Code
+	}
+	return ""
+}
+
+func TestOAuthPKCEMismatchedVerifier(t *testing.T) {
+	f := newOAuthFixture(t)
+	code := f.authorize(t, testVerifier)
+
+	if _, err := f.exchange(code, testRedirectURI, strings.Repeat("w", 43)); oauthErrorCode(err) != "invalid_grant" {
+		t.Fatalf("mismatched verifier = %v", err)
+	}
+	// 校验失败的授权码同样作废，不能再用正确的 verifier 兑换
+	if _, err := f.exchange(code, testRedirectURI, testVerifier); oauthErrorCode(err) != "invalid_grant" {
+		t.Fatalf("code should be burnt after a failed exchange, got %v", err)
+	}
+}
+
+func TestOAuthAuthorizationCodeSingleUse(t *testing.T) {
+	f := newOAuthFixture(t)
+	code := f.authorize(t, testVerifier)
+
+	resp, err := f.exchange(code, testRedirectURI, testVerifier)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	claims, err := f.users.ValidateAccessToken(resp.AccessToken)
+	if err != nil || claims.ClientID != f.client.ClientID || claims.Scope != "profile" {
+		t.Fatalf("issued token = %+v, %v", claims, err)
+	}
+	if _, err := f.exchange(code, testRedirectURI, testVerifier); oauthErrorCode(err) != "invalid_grant" {
+		t.Fatalf("replayed code = %v", err)
+	}
+}
+
+func TestOAuthRedirectURIExactMatch(t *testing.T) {
+	f := newOAuthFixture(t)
+	approve := true
+	for _, uri := range []string{testRedirectURI + "/", testRedirectURI + "?next=/", "https://evil.example.com/callback"} {
+		_, err := f.oauth.Authorize(uint(f.user.ID), AuthorizeRequest{
+			ResponseType:        "code",
+			ClientID:            f.client.ClientID,
+			RedirectURI:         uri,
+			CodeChallenge:       pkceChallenge(testVerifier),
+			CodeChallengeMethod: pkceMethodS256,
+		}, &approve)
+		var oe *OAuthError
+		if !errors.As(err, &oe) || oe.Code != "invalid_request" {
+			t.Fatalf("authorize with %s = %v", uri, err)
+		}
+		// 未注册的 redirect_uri 绝不能作为错误重定向的目标
+		if oe.RedirectTo != "" {
+			t.Fatalf("error for %s redirects to %s", uri, oe.RedirectTo)
+		}
+	}
+
+	code := f.authorize(t, testVerifier)
+	if _, err := f.exchange(code, testRedirectURI+"/", testVerifier); oauthErrorCode(err) != "invalid_grant" {
+		t.Fatalf("exchange with another redirect_uri = %v", err)
+	}
+}
+
+func TestOAuthRefreshRotates(t *testing.T) {
+	f := newOAuthFixture(t)
+	resp, err := f.exchange(f.authorize(t, testVerifier), testRedirectURI, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh := func(token string) (*TokenResponse, error) {
+		return f.oauth.Exchange(TokenRequest{
+			GrantType:    "refresh_token",
+			RefreshToken: token,
+			ClientID:     f.client.ClientID,
+			ClientSecret: f.secret,
+		}, ClientInfo{})
+	}
+
+	rotated, err := refresh(resp.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if rotated.RefreshToken == resp.RefreshToken || rotated.Scope != "profile" {
+		t.Fatalf("rotated response = %+v", rotated)
+	}
+	// 重放旧 refresh 触发家族吊销，新的 refresh 也随之失效
+	if _, err := refresh(resp.RefreshToken); oauthErrorCode(err) != "invalid_grant" {
+		t.Fatalf("replayed refresh = %v", err)
+	}
+	if _, err := refresh(rotated.RefreshToken); oauthErrorCode(err) != "invalid_grant" {
+		t.Fatalf("refresh of a revoked family = %v", err)
+	}
+}
+
+func TestOAuthRefreshRejectsOtherClient(t *testing.T) {
+	f := newOAuthFixture(t)
+	resp, err := f.exchange(f.authorize(t, testVerifier), testRedirectURI, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, secret, err := f.oauth.RegisterClient(uint(f.user.ID), "other", []string{testRedirectURI}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.oauth.Exchange(TokenRequest{
+		GrantType:    "refresh_token",
+		RefreshToken: resp.RefreshToken,
+		ClientID:     other.ClientID,
+		ClientSecret: secret,
+	}, ClientInfo{})
+	if oauthErrorCode(err) != "invalid_grant" {
+		t.Fatalf("refresh by another client = %v", err)
+	}
+}
+
+func TestOAuthIntrospectAndRevoke(t *testing.T) {
+	f := newOAuthFixture(t)
+	resp, err := f.exchange(f.authorize(t, testVerifier), testRedirectURI, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := f.oauth.Introspect(f.client.ClientID, f.secret, resp.AccessToken, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Active || info.ClientID != f.client.ClientID || info.TokenType != "access_token" || !info.SessionActive {
+		t.Fatalf("introspection of a live token = %+v", info)
+	}
+	if info, _ := f.oauth.Introspect(f.client.ClientID, f.secret, resp.RefreshToken, "refresh_token"); !info.Active || info.TokenType != "refresh_token" {
+		t.Fatalf("introspection of the refresh token = %+v", info)
+	}
+	if _, err := f.oauth.Introspect(f.client.ClientID, "wrong", resp.AccessToken, ""); oauthErrorCode(err) != "invalid_client" {
+		t.Fatalf("introspection with a bad secret = %v", err)
+	}
+
+	if err := f.oauth.Revoke(f.client.ClientID, f.secret, resp.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := f.oauth.Introspect(f.client.ClientID, f.secret, resp.AccessToken, ""); info.Active {
+		t.Fatal("revoked access token still active")
+	}
+	if err := f.oauth.Revoke(f.client.ClientID, f.secret, resp.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := f.oauth.Introspect(f.client.ClientID, f.secret, resp.RefreshToken, "refresh_token"); info.Active {
+		t.Fatal("revoked refresh token still active")
+	}
+	// 未知 token 按已吊销处理
+	if err := f.oauth.Revoke(f.client.ClientID, f.secret, "garbage"); err != nil {
+		t.Fatalf("revoking an unknown token = %v", err)
+	}
+}
+
+func TestOAuthRevokeOnlyOwnTokens(t *testing.T) {
+	f := newOAuthFixture(t)
+	resp, err := f.exchange(f.authorize(t, testVerifier), testRedirectURI, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, secret, err := f.oauth.RegisterClient(uint(f.user.ID), "other", []string{testRedirectURI}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.oauth.Revoke(other.ClientID, secret, resp.AccessToken); oauthErrorCode(err) != "unauthorized_client" {
+		t.Fatalf("revoking another client's token = %v", err)
+	}
+}
+
+func TestOAuthIntrospectOnlyOwnTokens(t *testing.T) {
+	f := newOAuthFixture(t)
+	resp, err := f.exchange(f.authorize(t, testVerifier), testRedirectURI, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 任何用户都能注册机密客户端，不能借此查看其他客户端的 token
+	other, secret, err := f.oauth.RegisterClient(uint(f.user.ID), "other", []string{testRedirectURI}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{resp.AccessToken, resp.RefreshToken} {
+		info, err := f.oauth.Introspect(other.ClientID, secret, token, "")
+		if err != nil || info.Active || info.Sub != "" {
+			t.Fatalf("introspection by another client = %+v, %v", info, err)
+		}
+	}
+
+	// 一方客户端（资源服务器）可以查看任意 token
+	if err := f.db.Model(other).Update("first_party", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	if info, err := f.oauth.Introspect(other.ClientID, secret, resp.AccessToken, ""); err != nil || !info.Active {
+		t.Fatalf("introspection by a first-party client = %+v, %v", info, err)
+	}
+}
+
+func TestOAuthTokenTypeFollowsDPoPBinding(t *testing.T) {
+	f := newOAuthFixture(t)
+	if resp, err := f.exchange(f.authorize(t, testVerifier), testRedirectURI, testVerifier); err != nil || resp.TokenType != "Bearer" {
+		t.Fatalf("unbound exchange = %+v, %v", resp, err)
+	}
+
+	req := TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         f.authorize(t, testVerifier),
+		RedirectURI:  testRedirectURI,
+		CodeVerifier: testVerifier,
+		ClientID:     f.client.ClientID,
+		ClientSecret: f.secret,
+	}
+	bound := ClientInfo{IP: "10.0.0.1", DPoPKey: "key-a"}
+	resp, err := f.oauth.Exchange(req, bound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.TokenType != "DPoP" {
+		t.Fatalf("token_type = %q for a DPoP-bound token", resp.TokenType)
+	}
+	claims, err := auth.ParseToken(resp.AccessToken)
+	if err != nil || claims.BoundKey() != "key-a" {
+		t.Fatalf("access token binding = %v, %v", claims, err)
+	}
+
+	rotated, err := f.oauth.Exchange(TokenRequest{
+		GrantType:    "refresh_token",
+		RefreshToken: resp.RefreshToken,
+		ClientID:     f.client.ClientID,
+		ClientSecret: f.secret,
+	}, bound)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if rotated.TokenType != "DPoP" {
+		t.Fatalf("refreshed token_type = %q", rotated.TokenType)
+	}
+}
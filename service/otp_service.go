@@ -0,0 +1,167 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"redbook/config"
+	"redbook/internal/auth"
+	"redbook/internal/metrics"
+	"redbook/internal/sms"
+	"time"
+)
+
+// 验证码用途，不同用途的验证码互不通用。
+const (
+	OTPPurposeLogin  = "login"
+	OTPPurposeVerify = "verify"
+)
+
+var (
+	ErrOTPTooFrequent = errors.New("verification code requested too frequently")
+	ErrOTPLimited     = errors.New("verification code limit exceeded")
+	ErrOTPInvalid     = errors.New("verification code invalid or expired")
+)
+
+const otpCodeDigits = 6
+
+// OTPService sends and verifies SMS one-time codes kept in the session store.
+type OTPService struct {
+	store    auth.SessionStore
+	provider sms.SMSProvider
+}
+
+func NewOTPService(store auth.SessionStore, provider sms.SMSProvider) *OTPService {
+	return &OTPService{store: store, provider: provider}
+}
+
+// otpSettings 返回带默认值的短信配置。
+func otpSettings() config.SMSConfig {
+	cfg := config.GlobalConfig.SMS
+	if cfg.CodeTTL <= 0 {
+		cfg.CodeTTL = 300
+	}
+	if cfg.SendInterval <= 0 {
+		cfg.SendInterval = 60
+	}
+	if cfg.MobileDailyLimit <= 0 {
+		cfg.MobileDailyLimit = 10
+	}
+	if cfg.IPHourlyLimit <= 0 {
+		cfg.IPHourlyLimit = 20
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	return cfg
+}
+
+// SendCode issues a fresh code for the purpose/mobile pair, enforcing the
+// per-number cooldown and daily cap as well as the per-IP hourly cap.
+func (o *OTPService) SendCode(purpose, mobile, ip string) error {
+	if err := o.Reserve(purpose, mobile, ip); err != nil {
+		return err
+	}
+	return o.Issue(purpose, mobile)
+}
+
+// Reserve charges one send against the per-number cooldown and daily cap and
+// the per-IP hourly cap without sending anything. Flows that must not reveal
+// whether a number is registered reserve first and only Issue for known
+// numbers, so both cases hit the same limits.
+func (o *OTPService) Reserve(purpose, mobile, ip string) error {
+	cfg := otpSettings()
+
+	coolKey := fmt.Sprintf("rb:otp:cool:%s:%s", purpose, mobile)
+	if cooling, _ := o.store.Exists(coolKey); cooling {
+		metrics.IncSMS(purpose, "too_frequent")
+		return ErrOTPTooFrequent
+	}
+	daily, err := o.store.Incr(fmt.Sprintf("rb:otp:day:%s", mobile), 24*time.Hour)
+	if err != nil {
+		return err
+	}
+	hourly, err := o.store.Incr(fmt.Sprintf("rb:otp:ip:%s", ip), time.Hour)
+	if err != nil {
+		return err
+	}
+	if daily > cfg.MobileDailyLimit || hourly > cfg.IPHourlyLimit {
+		metrics.IncSMS(purpose, "limited")
+		return ErrOTPLimited
+	}
+	return o.store.Set(coolKey, "1", time.Duration(cfg.SendInterval)*time.Second)
+}
+
+// Issue stores a fresh code for the purpose/mobile pair and sends it. Callers
+// must Reserve first.
+func (o *OTPService) Issue(purpose, mobile string) error {
+	cfg := otpSettings()
+	code, err := randomDigits(otpCodeDigits)
+	if err != nil {
+		return err
+	}
+	ttl := time.Duration(cfg.CodeTTL) * time.Second
+	if err := o.store.Set(otpCodeKey(purpose, mobile), code, ttl); err != nil {
+		return err
+	}
+	_ = o.store.Del(otpAttemptKey(purpose, mobile))
+
+	msg := fmt.Sprintf("【Redbook】验证码 %s，%d 分钟内有效，请勿泄露。", code, cfg.CodeTTL/60)
+	if err := o.provider.Send(mobile, msg); err != nil {
+		metrics.IncSMS(purpose, "send_failed")
+		return err
+	}
+	metrics.IncSMS(purpose, "sent")
+	return nil
+}
+
+// VerifyCode checks and consumes a code. Too many wrong guesses burn the code.
+func (o *OTPService) VerifyCode(purpose, mobile, code string) error {
+	cfg := otpSettings()
+	key := otpCodeKey(purpose, mobile)
+	stored, err := o.store.Get(key)
+	if err != nil {
+		return ErrOTPInvalid
+	}
+
+	attempts, err := o.store.Incr(otpAttemptKey(purpose, mobile), time.Duration(cfg.CodeTTL)*time.Second)
+	if err != nil {
+		return err
+	}
+	if attempts > cfg.MaxAttempts {
+		_ = o.store.Del(key, otpAttemptKey(purpose, mobile))
+		metrics.IncSMS(purpose, "attempts_exceeded")
+		return ErrOTPInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(code)) != 1 {
+		metrics.IncSMS(purpose, "mismatch")
+		return ErrOTPInvalid
+	}
+
+	_ = o.store.Del(key, otpAttemptKey(purpose, mobile))
+	metrics.IncSMS(purpose, "verified")
+	return nil
+}
+
+func otpCodeKey(purpose, mobile string) string {
+	return fmt.Sprintf("rb:otp:%s:%s", purpose, mobile)
+}
+
+func otpAttemptKey(purpose, mobile string) string {
+	return fmt.Sprintf("rb:otp:try:%s:%s", purpose, mobile)
+}
+
+// randomDigits returns n uniformly random decimal digits.
+func randomDigits(n int) (string, error) {
+	buf := make([]byte, n)
+	for i := range buf {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		buf[i] = byte('0' + d.Int64())
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"redbook/internal/audit"
+	"redbook/internal/metrics"
+	"redbook/model"
+	"redbook/utils"
+	"strconv"
+	"time"
+)
+
+// OTPPurposeReset 找回密码验证码
+const OTPPurposeReset = "reset"
+
+var (
+	// ErrResetTokenInvalid is returned when a reset token is unknown, expired or already used.
+	ErrResetTokenInvalid = errors.New("reset token invalid or expired")
+	ErrPasswordMismatch  = errors.New("current password is incorrect")
+	ErrPasswordUnchanged = errors.New("new password must differ from the current one")
+)
+
+// resetTokenTTL 是验证码校验通过后设置新密码的时限。
+const resetTokenTTL = 10 * time.Minute
+
+func resetTokenKey(token string) string {
+	return fmt.Sprintf("rb:pwreset:%s", token)
+}
+
+// SendResetCode sends a password reset code to a verified mobile. The send
+// limits are charged before the account lookup and unknown or unverified
+// numbers are silently ignored, so the response reveals nothing.
+func (s *UserService) SendResetCode(mobile, ip string) error {
+	if err := s.OTP.Reserve(OTPPurposeReset, mobile, ip); err != nil {
+		return err
+	}
+	user, err := s.dao.FindByMobile(mobile)
+	if err != nil || !user.MobileVerified {
+		return nil
+	}
+	s.issueSilently(OTPPurposeReset, mobile)
+	return nil
+}
+
+// VerifyResetCode exchanges a valid reset code for a single-use reset token.
+func (s *UserService) VerifyResetCode(mobile, code string) (string, time.Duration, error) {
+	if err := s.OTP.VerifyCode(OTPPurposeReset, mobile, code); err != nil {
+		return "", 0, err
+	}
+	user, err := s.dao.FindByMobile(mobile)
+	if err != nil {
+		return "", 0, ErrOTPInvalid
+	}
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := s.Session.Store().Set(resetTokenKey(token), strconv.FormatUint(user.ID, 10), resetTokenTTL); err != nil {
+		return "", 0, err
+	}
+	return token, resetTokenTTL, nil
+}
+
+// ResetPassword consumes the reset token, stores the new password and signs
+// the user out of every device.
+func (s *UserService) ResetPassword(token, newPassword string, client ClientInfo) error {
+	// 先校验新密码再消费 token，弱密码不会让用户重新走一遍验证码
+	v, err := s.Session.Store().Get(resetTokenKey(token))
+	if err != nil {
+		return ErrResetTokenInvalid
+	}
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return ErrResetTokenInvalid
+	}
+	user, err := s.dao.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := s.Passwords.Check(newPassword, user.Username, user.Mobile); err != nil {
+		return err
+	}
+	if _, err := s.Session.Store().GetDel(resetTokenKey(token)); err != nil {
+		return ErrResetTokenInvalid
+	}
+	if err := s.setPassword(id, newPassword); err != nil {
+		return err
+	}
+	metrics.IncSecurityEvent("password_reset")
+	RecordAudit(model.AuditEvent{Event: audit.EventPasswordReset, UserID: id}, client, nil)
+	return s.LogoutAll(uint(id))
+}
+
+// ChangePassword replaces the password of a signed-in user after checking the
+// current one. Wrong current passwords count toward the account lockout like
+// failed logins, so a stolen session cannot be used to guess the password.
+// Personal access tokens are always revoked. With keepCurrent the calling
+// device stays signed in and every other session is revoked; otherwise the
+// user is signed out everywhere.
+func (s *UserService) ChangePassword(userID uint, device, oldPassword, newPassword string, keepCurrent bool) error {
+	user, err := s.dao.GetByID(uint64(userID))
+	if err != nil {
+		return err
+	}
+	ids := accountIdentifiers(user)
+	for _, id := range ids {
+		if err := s.checkLockout(id); err != nil {
+			return err
+		}
+	}
+	if !utils.CheckPasswordHash(oldPassword, user.Password) {
+		for _, id := range ids {
+			s.recordLoginFailure(id)
+		}
+		return ErrPasswordMismatch
+	}
+	s.clearLoginFailures(ids...)
+	if oldPassword == newPassword {
+		return ErrPasswordUnchanged
+	}
+	if err := s.Passwords.Check(newPassword, user.Username, user.Mobile); err != nil {
+		return err
+	}
+	if err := s.setPassword(user.ID, newPassword); err != nil {
+		return err
+	}
+	metrics.IncSecurityEvent("password_change")
+
+	// 个人访问 token 不属于任何设备会话，保留当前设备时也要一并吊销
+	if _, err := s.dao.DeleteAccessTokens(user.ID); err != nil {
+		return err
+	}
+	if keepCurrent {
+		// 吊销其他设备的 token 家族，其 access token 随之失效；当前设备不受影响
+		_, err = s.RevokeOtherSessions(userID, device)
+		return err
+	}
+	return s.LogoutAll(userID)
+}
+
+func (s *UserService) setPassword(userID uint64, password string) error {
+	hashed, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.dao.UpdatePassword(userID, hashed)
+}
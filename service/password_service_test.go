@@ -0,0 +1,253 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"redbook/internal/auth"
+	"redbook/model"
+)
+
+const testNewPassword = "N3w#Passphrase"
+
+func TestSendResetCodeSameLimitsForUnknownMobile(t *testing.T) {
+	s, db := newDBTestService(t)
+	sms := useRecordingSMS(s)
+	createTestUser(t, db, "erin", "13800000030", "Secret#2024")
+	unverified := createTestUser(t, db, "frank", "13800000031", "Secret#2024")
+	db.Model(unverified).Update("mobile_verified", false)
+
+	for _, mobile := range []string{"13800000030", "13800000031", "13800000032"} {
+		if err := s.SendResetCode(mobile, "10.0.0.1"); err != nil {
+			t.Fatalf("first send to %s: %v", mobile, err)
+		}
+		if err := s.SendResetCode(mobile, "10.0.0.1"); !errors.Is(err, ErrOTPTooFrequent) {
+			t.Fatalf("second send to %s = %v, want cooldown", mobile, err)
+		}
+	}
+	if len(sms.sent["13800000030"]) != 1 || len(sms.sent["13800000031"]) != 0 || len(sms.sent["13800000032"]) != 0 {
+		t.Fatalf("codes sent = %v, want only the verified mobile", sms.sent)
+	}
+}
+
+// requestResetToken 发送找回密码验证码并换取重置 token。
+func requestResetToken(t *testing.T, s *UserService, sms *recordingSMS, user *model.User) string {
+	t.Helper()
+	if err := s.SendResetCode(user.Mobile, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := s.VerifyResetCode(user.Mobile, sms.lastCode(t, user.Mobile))
+	if err != nil {
+		t.Fatalf("verify reset code: %v", err)
+	}
+	return token
+}
+
+func TestResetPasswordSignsOutEverywhere(t *testing.T) {
+	s, db := newDBTestService(t)
+	sms := useRecordingSMS(s)
+	user := createTestUser(t, db, "grace", "13800000033", "Secret#2024")
+	access, refresh := seedSession(t, s, uint(user.ID), "phone")
+	token := requestResetToken(t, s, sms, user)
+
+	if err := s.ResetPassword(token, testNewPassword, ClientInfo{}); err != nil {
+		t.Fatalf("reset password: %v", err)
+	}
+	// 重置会递增 token 版本，之前签发的 token 全部失效
+	if version, err := s.TokenVersion(uint(user.ID)); err != nil || version != 1 {
+		t.Fatalf("token version after reset = %d, %v", version, err)
+	}
+	if _, err := s.ValidateAccessToken(access); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("access token after reset = %v", err)
+	}
+	if _, _, err := s.RotateRefreshToken(refresh, ClientInfo{Device: "phone"}); err == nil {
+		t.Fatal("refresh token survived the reset")
+	}
+	if _, _, err := s.Login(user.Username, testNewPassword, ClientInfo{Device: "phone"}); err != nil {
+		t.Fatalf("login with the new password: %v", err)
+	}
+}
+
+func TestResetTokenSingleUse(t *testing.T) {
+	s, db := newDBTestService(t)
+	sms := useRecordingSMS(s)
+	user := createTestUser(t, db, "heidi", "13800000034", "Secret#2024")
+	token := requestResetToken(t, s, sms, user)
+
+	// 弱密码被拒绝时不消耗 token
+	if err := s.ResetPassword(token, "123", ClientInfo{}); err == nil {
+		t.Fatal("weak password accepted")
+	}
+	if err := s.ResetPassword(token, testNewPassword, ClientInfo{}); err != nil {
+		t.Fatalf("reset password: %v", err)
+	}
+	if err := s.ResetPassword(token, "An0ther#Passphrase", ClientInfo{}); !errors.Is(err, ErrResetTokenInvalid) {
+		t.Fatalf("reused reset token = %v", err)
+	}
+}
+
+func TestResetCodeRejectsBadAndReusedCodes(t *testing.T) {
+	s, db := newDBTestService(t)
+	sms := useRecordingSMS(s)
+	user := createTestUser(t, db, "ivan", "13800000035", "Secret#2024")
+	if err := s.SendResetCode(user.Mobile, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	code := sms.lastCode(t, user.Mobile)
+
+	if _, _, err := s.VerifyResetCode(user.Mobile, wrongCode(code)); !errors.Is(err, ErrOTPInvalid) {
+		t.Fatalf("wrong code = %v", err)
+	}
+	if _, _, err := s.VerifyResetCode(user.Mobile, code); err != nil {
+		t.Fatalf("correct code after a wrong guess: %v", err)
+	}
+	if _, _, err := s.VerifyResetCode(user.Mobile, code); !errors.Is(err, ErrOTPInvalid) {
+		t.Fatalf("reused code = %v", err)
+	}
+}
+
+func TestResetCodeAndTokenExpire(t *testing.T) {
+	s, db := newDBTestService(t)
+	sms := useRecordingSMS(s)
+	clock := useFakeClock(s)
+	user := createTestUser(t, db, "judy", "13800000036", "Secret#2024")
+
+	if err := s.SendResetCode(user.Mobile, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Duration(otpSettings().CodeTTL) * time.Second)
+	if _, _, err := s.VerifyResetCode(user.Mobile, sms.lastCode(t, user.Mobile)); !errors.Is(err, ErrOTPInvalid) {
+		t.Fatalf("expired code = %v", err)
+	}
+
+	token := requestResetToken(t, s, sms, user)
+	clock.Advance(resetTokenTTL)
+	if err := s.ResetPassword(token, testNewPassword, ClientInfo{}); !errors.Is(err, ErrResetTokenInvalid) {
+		t.Fatalf("expired reset token = %v", err)
+	}
+}
+
+func TestChangePasswordChecksCurrentPassword(t *testing.T) {
+	s, db := newDBTestService(t)
+	clock := useFakeClock(s)
+	user := createTestUser(t, db, "kim", "13800000037", "Secret#2024")
+	warmTokenVersion(t, s, uint(user.ID))
+
+	if err := s.ChangePassword(uint(user.ID), "phone", "wrong", testNewPassword, false); !errors.Is(err, ErrPasswordMismatch) {
+		t.Fatalf("wrong current password = %v", err)
+	}
+	// 错误的当前密码与登录失败一样触发递增延迟
+	var throttled *LoginThrottledError
+	if err := s.ChangePassword(uint(user.ID), "phone", "Secret#2024", testNewPassword, false); !errors.As(err, &throttled) {
+		t.Fatalf("retry inside the backoff delay = %v", err)
+	}
+	clock.Advance(time.Minute)
+	if err := s.ChangePassword(uint(user.ID), "phone", "Secret#2024", "Secret#2024", false); !errors.Is(err, ErrPasswordUnchanged) {
+		t.Fatalf("unchanged password = %v", err)
+	}
+	if err := s.ChangePassword(uint(user.ID), "phone", "Secret#2024", "123", false); err == nil {
+		t.Fatal("weak password accepted")
+	}
+	if _, _, err := s.Login(user.Username, "Secret#2024", ClientInfo{Device: "phone"}); err != nil {
+		t.Fatalf("rejected changes must keep the old password: %v", err)
+	}
+}
+
+func TestChangePasswordSignsOutEverywhere(t *testing.T) {
+	s, db := newDBTestService(t)
+	user := createTestUser(t, db, "leo", "13800000038", "Secret#2024")
+	current, _ := seedSession(t, s, uint(user.ID), "phone")
+	other, _ := seedSession(t, s, uint(user.ID), "laptop")
+
+	if err := s.ChangePassword(uint(user.ID), "phone", "Secret#2024", testNewPassword, false); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	for _, token := range []string{current, other} {
+		if _, err := s.ValidateAccessToken(token); !errors.Is(err, ErrTokenRevoked) {
+			t.Fatalf("token after change = %v", err)
+		}
+	}
+	if _, _, err := s.Login(user.Username, "Secret#2024", ClientInfo{Device: "phone"}); err == nil {
+		t.Fatal("old password still accepted")
+	}
+	s.clearLoginFailures(usernameIdentifier(user.Username))
+	if _, _, err := s.Login(user.Username, testNewPassword, ClientInfo{Device: "phone"}); err != nil {
+		t.Fatalf("login with the new password: %v", err)
+	}
+}
+
+func TestChangePasswordKeepsCurrentDevice(t *testing.T) {
+	s, db := newDBTestService(t)
+	user := createTestUser(t, db, "mia", "13800000039", "Secret#2024")
+	current, currentRefresh := seedSession(t, s, uint(user.ID), "phone")
+	other, otherRefresh := seedSession(t, s, uint(user.ID), "laptop")
+
+	if err := s.ChangePassword(uint(user.ID), "phone", "Secret#2024", testNewPassword, true); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	if _, err := s.ValidateAccessToken(current); err != nil {
+		t.Fatalf("current device signed out: %v", err)
+	}
+	if _, _, err := s.RotateRefreshToken(currentRefresh, ClientInfo{Device: "phone"}); err != nil {
+		t.Fatalf("current device refresh: %v", err)
+	}
+	if _, err := s.ValidateAccessToken(other); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("other device access token = %v", err)
+	}
+	if _, _, err := s.RotateRefreshToken(otherRefresh, ClientInfo{Device: "laptop"}); err == nil {
+		t.Fatal("other device refresh survived the change")
+	}
+}
+
+func TestChangePasswordLocksAfterRepeatedMismatches(t *testing.T) {
+	s, db := newDBTestService(t)
+	clock := useFakeClock(s)
+	user := createTestUser(t, db, "nia", "13800000040", "Secret#2024")
+
+	for i := int64(0); i < lockoutSettings().Threshold; i++ {
+		if err := s.ChangePassword(uint(user.ID), "phone", "guess", testNewPassword, true); !errors.Is(err, ErrPasswordMismatch) {
+			t.Fatalf("guess %d = %v", i+1, err)
+		}
+		clock.Advance(time.Minute)
+	}
+	var throttled *LoginThrottledError
+	if err := s.ChangePassword(uint(user.ID), "phone", "Secret#2024", testNewPassword, true); !errors.As(err, &throttled) || !throttled.Locked {
+		t.Fatalf("change after repeated mismatches = %v, want locked", err)
+	}
+}
+
+func TestChangePasswordRevokesAccessTokens(t *testing.T) {
+	for _, keepCurrent := range []bool{true, false} {
+		tokens, s, userID := newTestAccessTokens(t)
+		warmTokenVersion(t, s, userID)
+		_, plain, err := tokens.Create(userID, "ci", nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ChangePassword(userID, "phone", "Secret#2024", testNewPassword, keepCurrent); err != nil {
+			t.Fatalf("keepCurrent=%v: change password: %v", keepCurrent, err)
+		}
+		if _, err := tokens.Validate(plain, ""); !errors.Is(err, ErrAccessTokenInvalid) {
+			t.Fatalf("keepCurrent=%v: access token after change = %v", keepCurrent, err)
+		}
+		if list, _ := tokens.List(userID); len(list) != 0 {
+			t.Fatalf("keepCurrent=%v: %d access tokens left", keepCurrent, len(list))
+		}
+	}
+}
+
+// fakeClock 接管内存存储的时钟，测试据此越过各类 TTL。
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func useFakeClock(s *UserService) *fakeClock {
+	c := &fakeClock{now: time.Now()}
+	s.Session.Store().(*auth.MemoryStore).SetClock(c.Now)
+	return c
+}
@@ -0,0 +1,265 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"redbook/config"
+	"redbook/internal/audit"
+	"redbook/internal/auth"
+	"redbook/model"
+	"redbook/utils"
+	"time"
+)
+
+// 扫码登录票据状态
+const (
+	QRStatusPending   = "pending"
+	QRStatusScanned   = "scanned"
+	QRStatusConfirmed = "confirmed"
+	QRStatusRejected  = "rejected"
+	QRStatusExpired   = "expired"
+)
+
+var (
+	ErrQRTicketNotFound = errors.New("qr login ticket not found or expired")
+	ErrQRTicketState    = errors.New("qr login ticket cannot be used in its current state")
+)
+
+// QRLoginTicket is returned to the web client that starts a QR login. Ticket
+// goes into the QR code; PollToken stays with the web client and is the only
+// way to pick up the tokens, so a photo of the screen is not enough.
+type QRLoginTicket struct {
+	Ticket    string `json:"ticket"`
+	QRContent string `json:"qr_content"`
+	PollToken string `json:"poll_token"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+// QRLoginRequester describes the web client, shown on the mobile before it confirms.
+type QRLoginRequester struct {
+	Device     string `json:"device"`
+	DeviceName string `json:"device_name,omitempty"`
+	Platform   string `json:"platform,omitempty"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+}
+
+// QRLoginState is what a poll sees. Tokens are only set on the poll that picks
+// up a confirmed ticket.
+type QRLoginState struct {
+	Status       string `json:"status"`
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// qrTicket is the pending login parked in the store.
+type qrTicket struct {
+	Status   string `json:"status"`
+	PollHash string `json:"poll_hash"`
+	pendingClient
+	// UserID 扫码的移动端账号，确认后网页端登录为该账号
+	UserID    uint64 `json:"user_id,omitempty"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func qrLoginSettings() config.QRLoginConfig {
+	cfg := config.GlobalConfig.QRLogin
+	if cfg.TicketTTL <= 0 {
+		cfg.TicketTTL = 120
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 1000
+	}
+	return cfg
+}
+
+func qrTicketKey(ticket string) string {
+	return fmt.Sprintf("rb:qr:%s", ticket)
+}
+
+func hashPollToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateQRLogin starts a QR login for the web client.
+func (s *UserService) CreateQRLogin(client ClientInfo) (*QRLoginTicket, error) {
+	ticket, err := utils.RandomToken(24)
+	if err != nil {
+		return nil, err
+	}
+	pollToken, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	ttl := time.Duration(qrLoginSettings().TicketTTL) * time.Second
+	t := &qrTicket{
+		Status:        QRStatusPending,
+		PollHash:      hashPollToken(pollToken),
+		pendingClient: newPendingClient(client),
+		ExpiresAt:     time.Now().Add(ttl).Unix(),
+	}
+	if err := s.saveQRTicket(ticket, t); err != nil {
+		return nil, err
+	}
+	return &QRLoginTicket{
+		Ticket:    ticket,
+		QRContent: "redbook://qr-login?ticket=" + ticket,
+		PollToken: pollToken,
+		ExpiresIn: int64(ttl.Seconds()),
+	}, nil
+}
+
+// PollQRLogin reports the state of a ticket to the web client that created it.
+// The first poll after confirmation consumes the ticket and starts the new
+// device session; expired or unknown tickets read as expired.
+func (s *UserService) PollQRLogin(ticket, pollToken string) (*QRLoginState, error) {
+	t, err := s.loadQRTicket(ticket)
+	if errors.Is(err, ErrQRTicketNotFound) {
+		return &QRLoginState{Status: QRStatusExpired}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(t.PollHash), []byte(hashPollToken(pollToken))) != 1 {
+		return nil, ErrQRTicketNotFound
+	}
+	if t.Status != QRStatusConfirmed {
+		return &QRLoginState{Status: t.Status}, nil
+	}
+
+	// 只有第一个取到票据的轮询签发 token
+	raw, err := s.Session.Store().GetDel(qrTicketKey(ticket))
+	if err != nil {
+		return &QRLoginState{Status: QRStatusExpired}, nil
+	}
+	if err := json.Unmarshal([]byte(raw), t); err != nil {
+		return nil, err
+	}
+	client := t.client()
+	entry := model.AuditEvent{Event: audit.EventLoginQR, UserID: t.UserID}
+	user, err := s.dao.GetByID(t.UserID)
+	if err != nil {
+		RecordAudit(entry, client, err)
+		return nil, err
+	}
+	access, refresh, err := s.issueSession(user, client)
+	RecordAudit(entry, client, err)
+	if err != nil {
+		return nil, err
+	}
+	return &QRLoginState{Status: QRStatusConfirmed, AccessToken: access, RefreshToken: refresh}, nil
+}
+
+// ScanQRLogin marks a ticket as scanned by the signed-in mobile user and
+// returns the web client so the user can check it before confirming.
+func (s *UserService) ScanQRLogin(userID uint, ticket string) (*QRLoginRequester, error) {
+	t, err := s.transitionQRTicket(ticket, uint64(userID), QRStatusScanned, func(t *qrTicket) bool {
+		// 同一账号可重复扫码，其他账号不能抢占已扫码的票据
+		return t.Status == QRStatusPending || (t.Status == QRStatusScanned && t.UserID == uint64(userID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &QRLoginRequester{Device: t.Device, DeviceName: t.DeviceName, Platform: t.Platform, IP: t.IP, UserAgent: t.UserAgent}, nil
+}
+
+// ConfirmQRLogin approves a ticket the same user has scanned.
+func (s *UserService) ConfirmQRLogin(userID uint, ticket string) error {
+	return s.decideQRLogin(userID, ticket, QRStatusConfirmed)
+}
+
+// RejectQRLogin declines a ticket the same user has scanned.
+func (s *UserService) RejectQRLogin(userID uint, ticket string) error {
+	return s.decideQRLogin(userID, ticket, QRStatusRejected)
+}
+
+func (s *UserService) decideQRLogin(userID uint, ticket, status string) error {
+	_, err := s.transitionQRTicket(ticket, uint64(userID), status, func(t *qrTicket) bool {
+		return t.Status == QRStatusScanned && t.UserID == uint64(userID)
+	})
+	return err
+}
+
+// qrTicketCASAttempts bounds the compare-and-set retries of transitionQRTicket.
+const qrTicketCASAttempts = 5
+
+// transitionQRTicket moves a ticket to status on behalf of userID when allowed
+// accepts its current state. The write is a compare-and-set against the value
+// that was checked, so two devices racing to scan, or a confirm racing a
+// reject, cannot both win; the loser re-reads and is judged on the new state.
+func (s *UserService) transitionQRTicket(ticket string, userID uint64, status string, allowed func(*qrTicket) bool) (*qrTicket, error) {
+	store := s.Session.Store()
+	key := qrTicketKey(ticket)
+	for i := 0; i < qrTicketCASAttempts; i++ {
+		raw, err := store.Get(key)
+		if errors.Is(err, auth.ErrNotFound) {
+			return nil, ErrQRTicketNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		var t qrTicket
+		if err := json.Unmarshal([]byte(raw), &t); err != nil {
+			return nil, err
+		}
+		if !allowed(&t) {
+			return nil, ErrQRTicketState
+		}
+		t.Status = status
+		t.UserID = userID
+		ttl := time.Until(time.Unix(t.ExpiresAt, 0))
+		if ttl <= 0 {
+			return nil, ErrQRTicketNotFound
+		}
+		data, err := json.Marshal(&t)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := store.CompareAndSet(key, raw, string(data), ttl)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &t, nil
+		}
+	}
+	return nil, ErrQRTicketState
+}
+
+func (s *UserService) loadQRTicket(ticket string) (*qrTicket, error) {
+	raw, err := s.Session.Store().Get(qrTicketKey(ticket))
+	if errors.Is(err, auth.ErrNotFound) {
+		return nil, ErrQRTicketNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t qrTicket
+	if err := json.Unmarshal([]byte(raw), &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// saveQRTicket stores a new ticket for whatever lifetime it has left.
+func (s *UserService) saveQRTicket(ticket string, t *qrTicket) error {
+	ttl := time.Until(time.Unix(t.ExpiresAt, 0))
+	if ttl <= 0 {
+		return ErrQRTicketNotFound
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return s.Session.Store().Set(qrTicketKey(ticket), string(data), ttl)
+}
+
+// QRPollInterval is how often the SSE stream re-reads a ticket.
+func QRPollInterval() time.Duration {
+	return time.Duration(qrLoginSettings().PollInterval) * time.Millisecond
+}
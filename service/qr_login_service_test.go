@@ -0,0 +1,107 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestQRLoginTicketStates(t *testing.T) {
+	s := newTestService(t)
+	ticket, err := s.CreateQRLogin(ClientInfo{Device: "web", IP: "198.51.100.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, err := s.PollQRLogin(ticket.Ticket, ticket.PollToken); err != nil || state.Status != QRStatusPending {
+		t.Fatalf("poll = %+v, %v", state, err)
+	}
+	// 只看到二维码、没有 poll_token 的人无法轮询
+	if _, err := s.PollQRLogin(ticket.Ticket, "guess"); !errors.Is(err, ErrQRTicketNotFound) {
+		t.Fatalf("expected wrong poll token to be rejected, got %v", err)
+	}
+
+	if err := s.ConfirmQRLogin(5, ticket.Ticket); !errors.Is(err, ErrQRTicketState) {
+		t.Fatalf("confirm before scan = %v", err)
+	}
+	requester, err := s.ScanQRLogin(5, ticket.Ticket)
+	if err != nil || requester.Device != "web" {
+		t.Fatalf("scan = %+v, %v", requester, err)
+	}
+	if _, err := s.ScanQRLogin(6, ticket.Ticket); !errors.Is(err, ErrQRTicketState) {
+		t.Fatalf("second scanner = %v", err)
+	}
+	if err := s.RejectQRLogin(5, ticket.Ticket); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := s.PollQRLogin(ticket.Ticket, ticket.PollToken); state.Status != QRStatusRejected {
+		t.Fatalf("status after reject = %s", state.Status)
+	}
+	if state, _ := s.PollQRLogin("unknown", ticket.PollToken); state.Status != QRStatusExpired {
+		t.Fatalf("unknown ticket status = %s", state.Status)
+	}
+}
+
+func TestQRLoginConfirmedTicketIssuesTokensOnce(t *testing.T) {
+	s, db := newDBTestService(t)
+	user := createTestUser(t, db, "tina", "13800000090", "Secret#2024")
+	warmTokenVersion(t, s, uint(user.ID))
+	ticket, err := s.CreateQRLogin(ClientInfo{Device: "web", IP: "198.51.100.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ScanQRLogin(uint(user.ID), ticket.Ticket); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ConfirmQRLogin(uint(user.ID), ticket.Ticket); err != nil {
+		t.Fatal(err)
+	}
+	// 已确认的票据不能再被拒绝或重新扫码
+	if err := s.RejectQRLogin(uint(user.ID), ticket.Ticket); !errors.Is(err, ErrQRTicketState) {
+		t.Fatalf("reject after confirm = %v", err)
+	}
+
+	state, err := s.PollQRLogin(ticket.Ticket, ticket.PollToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != QRStatusConfirmed || state.AccessToken == "" || state.RefreshToken == "" {
+		t.Fatalf("poll after confirm = %+v", state)
+	}
+	claims, err := s.ValidateAccessToken(state.AccessToken)
+	if err != nil || claims.UserID != uint(user.ID) || claims.Device != "web" {
+		t.Fatalf("issued token = %+v, %v", claims, err)
+	}
+
+	// 票据只能领取一次
+	state, err = s.PollQRLogin(ticket.Ticket, ticket.PollToken)
+	if err != nil || state.Status != QRStatusExpired || state.AccessToken != "" {
+		t.Fatalf("second poll = %+v, %v", state, err)
+	}
+}
+
+func TestQRLoginConcurrentScansHaveOneWinner(t *testing.T) {
+	s := newTestService(t)
+	ticket, err := s.CreateQRLogin(ClientInfo{Device: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const scanners = 8
+	var (
+		wg   sync.WaitGroup
+		wins atomic.Int32
+	)
+	for i := 1; i <= scanners; i++ {
+		wg.Add(1)
+		go func(userID uint) {
+			defer wg.Done()
+			if _, err := s.ScanQRLogin(userID, ticket.Ticket); err == nil {
+				wins.Add(1)
+			}
+		}(uint(i))
+	}
+	wg.Wait()
+	if wins.Load() != 1 {
+		t.Fatalf("%d scanners claimed the ticket, want 1", wins.Load())
+	}
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"redbook/config"
+	"redbook/dao"
+	"redbook/internal/auth"
+	"redbook/model"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RoleAdmin 是 admin.user_ids 中的用户隐式拥有的角色。
+const RoleAdmin = "admin"
+
+// ErrRoleNotFound is returned when granting or revoking an undeclared role.
+var ErrRoleNotFound = errors.New("role not found")
+
+// permissionCacheTTL 权限缓存时长；授予 / 收回角色时主动失效。
+const permissionCacheTTL = 5 * time.Minute
+
+// permissionCacheKey 带上用户的权限缓存版本：授予 / 收回角色时递增版本，
+// 此前开始的缓存回填只会写到旧版本的 key 上，不会把已收回的权限重新缓存。
+func permissionCacheKey(userID uint, version string) string {
+	return fmt.Sprintf("rb:perm:%d:%s", userID, version)
+}
+
+func permissionVersionKey(userID uint) string {
+	return fmt.Sprintf("rb:perm:ver:%d", userID)
+}
+
+// RBACService resolves a user's roles and permissions. Effective permissions are
+// cached in the session store so permission checks do not hit MySQL.
+type RBACService struct {
+	dao   *dao.RoleDAO
+	users *dao.UserDAO
+	store auth.SessionStore
+}
+
+func NewRBACService(roles *dao.RoleDAO, users *dao.UserDAO, store auth.SessionStore) *RBACService {
+	return &RBACService{dao: roles, users: users, store: store}
+}
+
+// SeedRoles upserts the roles declared in config and replaces their permissions.
+func (r *RBACService) SeedRoles(roles []config.RoleConfig) error {
+	for _, role := range roles {
+		if err := r.dao.UpsertRole(role.Name, role.Description, role.Permissions); err != nil {
+			return fmt.Errorf("seed role %s: %w", role.Name, err)
+		}
+	}
+	return nil
+}
+
+// ListRoles returns every role with its permissions.
+func (r *RBACService) ListRoles() ([]model.Role, error) {
+	return r.dao.ListRoles()
+}
+
+// UserRoles returns the roles in effect for the user: the default role, the
+// bootstrap admin role and every granted role.
+func (r *RBACService) UserRoles(userID uint) ([]string, error) {
+	granted, err := r.dao.UserRoleNames(uint64(userID))
+	if err != nil {
+		return nil, err
+	}
+	var roles []string
+	if def := config.GlobalConfig.RBAC.DefaultRole; def != "" {
+		roles = append(roles, def)
+	}
+	if slices.Contains(config.GlobalConfig.Admin.UserIDs, uint64(userID)) {
+		roles = append(roles, RoleAdmin)
+	}
+	for _, name := range granted {
+		if !slices.Contains(roles, name) {
+			roles = append(roles, name)
+		}
+	}
+	return roles, nil
+}
+
+// Permissions returns the user's effective permissions, served from the cache
+// and recomputed from MySQL on a miss.
+func (r *RBACService) Permissions(userID uint) ([]string, error) {
+	// 版本须在查询 MySQL 之前读取
+	version, err := r.store.Get(permissionVersionKey(userID))
+	if errors.Is(err, auth.ErrNotFound) {
+		version = "0"
+	} else if err != nil {
+		return nil, err
+	}
+	if v, err := r.store.Get(permissionCacheKey(userID, version)); err == nil {
+		return strings.Fields(v), nil
+	}
+	roles, err := r.UserRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+	perms, err := r.dao.RolePermissions(roles)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(perms)
+	_ = r.store.Set(permissionCacheKey(userID, version), strings.Join(perms, " "), permissionCacheTTL)
+	return perms, nil
+}
+
+// HasPermission reports whether the user holds perm through any of their roles.
+func (r *RBACService) HasPermission(userID uint, perm string) (bool, error) {
+	perms, err := r.Permissions(userID)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(perms, perm), nil
+}
+
+// GrantRole gives the user a role. Granting a role twice is a no-op.
+func (r *RBACService) GrantRole(actorID, userID uint, roleName string) error {
+	role, err := r.lookup(userID, roleName)
+	if err != nil {
+		return err
+	}
+	if err := r.dao.GrantRole(uint64(userID), role.ID, uint64(actorID)); err != nil {
+		return err
+	}
+	r.invalidate(userID)
+	log.Printf("security: role %s granted to user=%d by user=%d", roleName, userID, actorID)
+	return nil
+}
+
+// RevokeRole takes a granted role away from the user and reports whether the
+// user actually had it.
+func (r *RBACService) RevokeRole(actorID, userID uint, roleName string) (bool, error) {
+	role, err := r.lookup(userID, roleName)
+	if err != nil {
+		return false, err
+	}
+	revoked, err := r.dao.RevokeRole(uint64(userID), role.ID)
+	if err != nil {
+		return false, err
+	}
+	r.invalidate(userID)
+	if revoked {
+		log.Printf("security: role %s revoked from user=%d by user=%d", roleName, userID, actorID)
+	}
+	return revoked, nil
+}
+
+// lookup checks that the user exists and resolves the role by name.
+func (r *RBACService) lookup(userID uint, roleName string) (*model.Role, error) {
+	if _, err := r.users.GetByID(uint64(userID)); err != nil {
+		return nil, err
+	}
+	role, err := r.dao.GetRole(roleName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRoleNotFound
+	}
+	return role, err
+}
+
+// invalidate moves the user to a new permission cache version; entries cached
+// under the old version are never read again and expire on their own.
+func (r *RBACService) invalidate(userID uint) {
+	if _, err := r.store.Incr(permissionVersionKey(userID), 0); err != nil {
+		log.Printf("invalidate permission cache for user %d failed: %v", userID, err)
+	}
+}
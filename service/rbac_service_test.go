@@ -0,0 +1,101 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"redbook/config"
+	"redbook/dao"
+	"redbook/internal/auth"
+	"redbook/model"
+
+	"gorm.io/gorm"
+)
+
+func TestRBACPermissionsServedFromCache(t *testing.T) {
+	newTestService(t)
+	store := auth.NewMemoryStore()
+	// dao 为 nil：命中缓存时不应访问 MySQL
+	rbac := NewRBACService(nil, nil, store)
+	if err := store.Set(permissionCacheKey(9, "0"), "notes:moderate notes:read", permissionCacheTTL); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := rbac.HasPermission(9, "notes:moderate"); err != nil || !ok {
+		t.Fatalf("HasPermission = %v, %v", ok, err)
+	}
+	if ok, _ := rbac.HasPermission(9, "roles:manage"); ok {
+		t.Fatal("unexpected permission")
+	}
+}
+
+// newTestRBAC 在 SQLite 上建立 RBAC 服务并同步一个 moderator 角色。
+func newTestRBAC(t *testing.T) (*RBACService, *gorm.DB, uint) {
+	t.Helper()
+	s, db := newDBTestService(t)
+	rbac := NewRBACService(dao.NewRoleDAO(db), dao.NewUserDAO(db), s.Session.Store())
+	if err := rbac.SeedRoles([]config.RoleConfig{{Name: "moderator", Permissions: []string{"notes:moderate", "notes:read"}}}); err != nil {
+		t.Fatal(err)
+	}
+	user := createTestUser(t, db, "olga", "13800000050", "Secret#2024")
+	return rbac, db, uint(user.ID)
+}
+
+func TestRBACGrantAndRevokeTakeEffectImmediately(t *testing.T) {
+	rbac, _, userID := newTestRBAC(t)
+
+	// 先缓存“无权限”的结果，授予后不能继续命中旧缓存
+	if ok, err := rbac.HasPermission(userID, "notes:moderate"); err != nil || ok {
+		t.Fatalf("before grant = %v, %v", ok, err)
+	}
+	if err := rbac.GrantRole(1, userID, "moderator"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := rbac.HasPermission(userID, "notes:moderate"); err != nil || !ok {
+		t.Fatalf("after grant = %v, %v", ok, err)
+	}
+
+	revoked, err := rbac.RevokeRole(1, userID, "moderator")
+	if err != nil || !revoked {
+		t.Fatalf("revoke = %v, %v", revoked, err)
+	}
+	if ok, err := rbac.HasPermission(userID, "notes:moderate"); err != nil || ok {
+		t.Fatalf("after revoke = %v, %v", ok, err)
+	}
+	if revoked, _ := rbac.RevokeRole(1, userID, "moderator"); revoked {
+		t.Fatal("revoking a role the user no longer has should report false")
+	}
+}
+
+func TestRBACStaleCacheFillIsIgnoredAfterRevoke(t *testing.T) {
+	rbac, _, userID := newTestRBAC(t)
+	if err := rbac.GrantRole(1, userID, "moderator"); err != nil {
+		t.Fatal(err)
+	}
+	version, _ := rbac.store.Get(permissionVersionKey(userID))
+
+	if _, err := rbac.RevokeRole(1, userID, "moderator"); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟收回之前开始的缓存回填在收回之后才写入
+	if err := rbac.store.Set(permissionCacheKey(userID, version), "notes:moderate notes:read", permissionCacheTTL); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := rbac.HasPermission(userID, "notes:moderate"); err != nil || ok {
+		t.Fatalf("revoked permission served from a stale fill = %v, %v", ok, err)
+	}
+}
+
+func TestRBACRoleLookupErrors(t *testing.T) {
+	rbac, db, userID := newTestRBAC(t)
+	if err := rbac.GrantRole(1, userID, "nope"); !errors.Is(err, ErrRoleNotFound) {
+		t.Fatalf("unknown role = %v", err)
+	}
+
+	// 数据库故障不能被当成角色不存在
+	if err := db.Migrator().DropTable(&model.Role{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rbac.GrantRole(1, userID, "moderator"); err == nil || errors.Is(err, ErrRoleNotFound) {
+		t.Fatalf("database failure = %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"redbook/config"
+	"redbook/internal/auth"
+	"strings"
+	"time"
+)
+
+const (
+	// lifetimeClassOAuth 为授权给第三方应用的会话单独配置有效期
+	lifetimeClassOAuth   = "oauth"
+	lifetimeClassDefault = "default"
+	// defaultAbsoluteLifetime 未配置绝对有效期时的兜底值
+	defaultAbsoluteLifetime = 30 * 24 * time.Hour
+)
+
+// ErrSessionExpired 会话已达到绝对有效期，即使一直活跃也必须重新登录。
+var ErrSessionExpired = &auth.Error{Code: "session_expired", Message: "session expired: sign in again"}
+
+// sessionLifetime returns the idle timeout and absolute lifetime configured for
+// a client type, falling back to the default entry and then to jwt.refresh_expire.
+func sessionLifetime(class string) (idle, absolute time.Duration) {
+	lifetimes := config.GlobalConfig.Session.Lifetimes
+	policy, ok := lifetimes[class]
+	if !ok {
+		policy = lifetimes[lifetimeClassDefault]
+	}
+	idle = time.Duration(config.GlobalConfig.JWT.RefreshExpire) * time.Second
+	if policy.IdleTimeout > 0 {
+		idle = time.Duration(policy.IdleTimeout) * time.Second
+	}
+	absolute = defaultAbsoluteLifetime
+	if policy.AbsoluteLifetime > 0 {
+		absolute = time.Duration(policy.AbsoluteLifetime) * time.Second
+	}
+	return idle, absolute
+}
+
+// lifetimeClass picks the lifetime policy of a session: OAuth grants have their
+// own, first-party logins follow their device class.
+func lifetimeClass(device, deviceClass string) string {
+	if strings.HasPrefix(device, oauthDevicePrefix) {
+		return lifetimeClassOAuth
+	}
+	return deviceClass
+}
+
+// applyLifetime starts the idle and absolute clocks of a session at now. The
+// values travel in every token of the family, so a rotation never needs to
+// look the policy up again and a policy change only affects new logins.
+func applyLifetime(p *auth.TokenParams, class string, now time.Time) {
+	idle, absolute := sessionLifetime(class)
+	p.AuthTime = now.Unix()
+	p.IdleTimeout = int64(idle / time.Second)
+	p.MaxExpiry = now.Add(absolute).Unix()
+}
+
+// sessionTTL 为会话记录与 refresh token 的存活时间：空闲超时，但不越过绝对到期时间。
+func sessionTTL(p auth.TokenParams, now time.Time) time.Duration {
+	ttl := time.Duration(config.GlobalConfig.JWT.RefreshExpire) * time.Second
+	if p.IdleTimeout > 0 {
+		ttl = time.Duration(p.IdleTimeout) * time.Second
+	}
+	if p.MaxExpiry > 0 {
+		ttl = min(ttl, time.Unix(p.MaxExpiry, 0).Sub(now))
+	}
+	return ttl
+}
+
+// revocationTTL is how long a revoked family or eviction marker must be kept:
+// as long as the longest-lived token any session may still hold.
+func revocationTTL() time.Duration {
+	return auth.MaxTokenLifetime(config.GlobalConfig.JWT, config.GlobalConfig.Session.Lifetimes)
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"redbook/config"
+	"redbook/internal/auth"
+)
+
+func TestRefreshSlidesIdleTimeoutWithinAbsoluteLifetime(t *testing.T) {
+	s := newTestService(t)
+	config.GlobalConfig.Session = config.SessionConfig{Lifetimes: map[string]config.SessionLifetime{
+		DeviceClassWeb: {IdleTimeout: 600, AbsoluteLifetime: 300},
+	}}
+	warmTokenVersion(t, s, 25)
+	_, refresh, err := s.startSession(auth.TokenParams{UserID: 25, Device: "web-1"}, ClientInfo{DeviceClass: DeviceClassWeb})
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, _ := auth.ParseToken(refresh)
+	if login.IdleTimeout != 600 || login.MaxExpiry-login.AuthTime != 300 {
+		t.Fatalf("unexpected lifetime claims: idle %d, auth_time %d, max_exp %d", login.IdleTimeout, login.AuthTime, login.MaxExpiry)
+	}
+	// 空闲超时长于剩余的绝对有效期时，refresh 在绝对到期时间失效
+	if login.ExpiresAt.Unix() != login.MaxExpiry {
+		t.Fatalf("refresh exp %d should be capped at %d", login.ExpiresAt.Unix(), login.MaxExpiry)
+	}
+
+	_, rotated, err := s.RotateRefreshToken(refresh, ClientInfo{Device: "web-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, _ := auth.ParseToken(rotated)
+	if next.AuthTime != login.AuthTime || next.MaxExpiry != login.MaxExpiry {
+		t.Fatalf("rotation moved the absolute deadline: %+v", next)
+	}
+	sess, err := s.Session.GetSession(25, "web-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.IdleTimeout != 600 || sess.ExpiresAt.Unix() != login.MaxExpiry {
+		t.Fatalf("session record lifetime: idle %d, expires %v", sess.IdleTimeout, sess.ExpiresAt)
+	}
+
+	// 达到绝对有效期后即使会话仍然活跃也必须重新登录
+	params := next.Params()
+	params.MaxExpiry = time.Now().Add(-time.Second).Unix()
+	_, expired, err := auth.GenerateTokens(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Session.SaveSession(25, auth.SessionInfo{Device: "web-1", FamilyID: params.FamilyID}, expired, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.RotateRefreshToken(expired, ClientInfo{Device: "web-1"}); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("expected ErrSessionExpired, got %v", err)
+	}
+}
+
+func TestAccessTokenPastMaxExpiryReportsSessionExpired(t *testing.T) {
+	s := newTestService(t)
+	familyID, err := auth.NewFamilyID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, _ := seedSessionWith(t, s, auth.TokenParams{
+		UserID:    26,
+		Device:    "web-1",
+		FamilyID:  familyID,
+		AuthTime:  time.Now().Add(-time.Hour).Unix(),
+		MaxExpiry: time.Now().Add(-time.Second).Unix(),
+	})
+	_, err = s.ValidateAccessToken(access)
+	if !errors.Is(err, ErrSessionExpired) || auth.ErrorCode(err) != "session_expired" {
+		t.Fatalf("expected session_expired, got %v (code %q)", err, auth.ErrorCode(err))
+	}
+}
+
+func TestOAuthSessionsUseOAuthLifetime(t *testing.T) {
+	f := newOAuthFixture(t)
+	config.GlobalConfig.Session = config.SessionConfig{Lifetimes: map[string]config.SessionLifetime{
+		lifetimeClassDefault: {IdleTimeout: 7200, AbsoluteLifetime: 86400},
+		lifetimeClassOAuth:   {IdleTimeout: 900, AbsoluteLifetime: 30},
+	}}
+	resp, err := f.exchange(f.authorize(t, testVerifier), testRedirectURI, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh, err := auth.ParseToken(resp.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refresh.IdleTimeout != 900 || refresh.MaxExpiry-refresh.AuthTime != 30 {
+		t.Fatalf("oauth session lifetime: idle %d, absolute %d", refresh.IdleTimeout, refresh.MaxExpiry-refresh.AuthTime)
+	}
+	// 绝对有效期短于 access 有效期时，expires_in 按 token 实际的 exp 计算
+	if resp.ExpiresIn > 30 || resp.ExpiresIn < 29 {
+		t.Fatalf("expires_in = %d, want the 30s left in the session", resp.ExpiresIn)
+	}
+}
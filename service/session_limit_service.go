@@ -0,0 +1,125 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"redbook/config"
+	"redbook/internal/audit"
+	"redbook/internal/auth"
+	"redbook/internal/metrics"
+	"redbook/model"
+	"strings"
+)
+
+// 设备类型，按类型分别限制同时在线的会话数
+const (
+	DeviceClassMobile = "mobile"
+	DeviceClassTablet = "tablet"
+	DeviceClassWeb    = "web"
+)
+
+var (
+	// ErrSessionLimit 在 reject 策略下拒绝超出设备上限的新登录。
+	ErrSessionLimit = errors.New("too many devices signed in, sign out another device first")
+	// ErrSignedInElsewhere 返回给因其他设备登录而被挤下线的设备。
+	ErrSignedInElsewhere = &auth.Error{Code: "signed_in_elsewhere", Message: "signed in elsewhere: this device was signed out because the account signed in on another device"}
+)
+
+// DeviceClass derives the class of a client from its user agent. The class picks
+// both the per-class session cap and the session lifetime, so it is never taken
+// from a header the client sends only to describe itself: claiming "mobile" would
+// otherwise escape the web cap and earn the 30-day mobile lifetime. It is fixed
+// at login and kept by every later rotation.
+func DeviceClass(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet"):
+		return DeviceClassTablet
+	case strings.Contains(ua, "mobile") || strings.Contains(ua, "iphone") || strings.Contains(ua, "android"):
+		return DeviceClassMobile
+	default:
+		return DeviceClassWeb
+	}
+}
+
+func evictedFamilyKey(familyID string) string {
+	return fmt.Sprintf("rb:family:evicted:%s", familyID)
+}
+
+// enforceSessionLimit makes room for a new session of client.DeviceClass. Under
+// the evict_oldest policy the least recently used sessions of that class are
+// signed out; under reject the login fails. Signing in again on a device that
+// already has a session never counts against the limit.
+func (s *UserService) enforceSessionLimit(userID uint, client ClientInfo) error {
+	cfg := config.GlobalConfig.Session
+	limit := cfg.Limits[client.DeviceClass]
+	if limit <= 0 {
+		return nil
+	}
+	sessions, err := s.Session.ListSessions(userID)
+	if err != nil {
+		return err
+	}
+	// ListSessions 按最近使用时间倒序，末尾的会话最久未使用
+	var same []auth.SessionInfo
+	for _, sess := range sessions {
+		if sess.Device == client.Device || strings.HasPrefix(sess.Device, oauthDevicePrefix) {
+			continue
+		}
+		if sessionClass(sess) == client.DeviceClass {
+			same = append(same, sess)
+		}
+	}
+	if len(same) < limit {
+		return nil
+	}
+	if cfg.LimitPolicy == config.SessionLimitReject {
+		metrics.IncSecurityEvent("session_limit_rejected")
+		return ErrSessionLimit
+	}
+	for _, sess := range same[limit-1:] {
+		s.evictSession(userID, sess, client)
+	}
+	return nil
+}
+
+// evictSession signs a device out to make room for newcomer and remembers why,
+// so the evicted device is told it was signed in elsewhere.
+func (s *UserService) evictSession(userID uint, sess auth.SessionInfo, newcomer ClientInfo) {
+	ttl := revocationTTL()
+	if sess.FamilyID != "" {
+		_ = s.Session.Store().Set(evictedFamilyKey(sess.FamilyID), newcomer.Device, ttl)
+	}
+	if err := s.RevokeSession(userID, sess.Device); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		log.Printf("evict session user=%d device=%s failed: %v", userID, sess.Device, err)
+		return
+	}
+	metrics.IncSecurityEvent("session_evicted")
+	audit.Record(model.AuditEvent{
+		Event:     audit.EventSessionEvicted,
+		UserID:    uint64(userID),
+		Device:    sess.Device,
+		IP:        newcomer.IP,
+		UserAgent: newcomer.UserAgent,
+		Result:    audit.ResultSuccess,
+		Reason:    fmt.Sprintf("%s limit reached by login on %s", sessionClass(sess), newcomer.Device),
+	})
+}
+
+// revokedFamilyError explains why a revoked family's tokens stopped working.
+func (s *UserService) revokedFamilyError(familyID string, fallback error) error {
+	if evicted, _ := s.Session.Store().Exists(evictedFamilyKey(familyID)); evicted {
+		return ErrSignedInElsewhere
+	}
+	return fallback
+}
+
+// sessionClass returns the stored class, guessing from the user agent for
+// sessions created before classes were recorded.
+func sessionClass(sess auth.SessionInfo) string {
+	if sess.Class != "" {
+		return sess.Class
+	}
+	return DeviceClass(sess.UserAgent)
+}
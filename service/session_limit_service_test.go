@@ -0,0 +1,50 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"redbook/config"
+	"redbook/internal/auth"
+)
+
+func TestSessionLimitEvictsLeastRecentlyUsed(t *testing.T) {
+	s := newTestService(t)
+	config.GlobalConfig.Session = config.SessionConfig{Limits: map[string]int{DeviceClassWeb: 2}}
+	access := map[string]string{}
+	// 依次登录，web-a 最久未使用
+	for _, device := range []string{"web-a", "web-b", "phone"} {
+		var refresh string
+		access[device], refresh = seedSession(t, s, 21, device)
+		class := DeviceClassWeb
+		if device == "phone" {
+			class = DeviceClassMobile
+		}
+		claims, _ := auth.ParseToken(access[device])
+		if err := s.saveSession(claims.Params(), refresh, ClientInfo{Device: device, DeviceClass: class}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.enforceSessionLimit(21, ClientInfo{Device: "web-c", DeviceClass: DeviceClassWeb}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateAccessToken(access["web-a"]); !errors.Is(err, ErrSignedInElsewhere) {
+		t.Fatalf("evicted device got %v", err)
+	}
+	for _, device := range []string{"web-b", "phone"} {
+		if _, err := s.ValidateAccessToken(access[device]); err != nil {
+			t.Fatalf("%s should stay signed in: %v", device, err)
+		}
+	}
+
+	config.GlobalConfig.Session.LimitPolicy = config.SessionLimitReject
+	config.GlobalConfig.Session.Limits[DeviceClassMobile] = 1
+	if err := s.enforceSessionLimit(21, ClientInfo{Device: "phone-2", DeviceClass: DeviceClassMobile}); !errors.Is(err, ErrSessionLimit) {
+		t.Fatalf("expected ErrSessionLimit, got %v", err)
+	}
+	// 同一设备重新登录不占用名额
+	if err := s.enforceSessionLimit(21, ClientInfo{Device: "phone", DeviceClass: DeviceClassMobile}); err != nil {
+		t.Fatalf("re-login on the same device rejected: %v", err)
+	}
+}
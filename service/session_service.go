@@ -0,0 +1,95 @@
+package service
+
+import (
+	"errors"
+	"redbook/internal/auth"
+	"time"
+)
+
+// ErrSessionNotFound is returned when the device has no live session.
+var ErrSessionNotFound = errors.New("session not found")
+
+// saveSession stores the refresh token of a device session together with the
+// client metadata shown in the session list, on login and on every rotation.
+// The session's lifetime policy is taken from the token params.
+func (s *UserService) saveSession(params auth.TokenParams, refreshToken string, client ClientInfo, ttl time.Duration) error {
+	return s.Session.SaveSession(params.UserID, sessionInfo(params, client), refreshToken, ttl)
+}
+
+// rotateSession swaps current for refreshToken in the device session; it fails
+// with auth.ErrSessionRotated when current is no longer the stored token.
+func (s *UserService) rotateSession(params auth.TokenParams, current, refreshToken string, client ClientInfo, ttl time.Duration) error {
+	return s.Session.RotateSession(params.UserID, sessionInfo(params, client), current, refreshToken, ttl)
+}
+
+func sessionInfo(params auth.TokenParams, client ClientInfo) auth.SessionInfo {
+	info := auth.SessionInfo{
+		Device:     client.Device,
+		DeviceName: client.DeviceName,
+		Platform:   client.Platform,
+		AppVersion: client.AppVersion,
+		Class:      client.DeviceClass,
+		LastSeenIP: client.IP,
+		UserAgent:  client.UserAgent,
+		FamilyID:   params.FamilyID,
+		// 空闲超时与绝对到期时间同 token 中携带的一致
+		IdleTimeout: params.IdleTimeout,
+	}
+	if params.MaxExpiry > 0 {
+		info.ExpiresAt = time.Unix(params.MaxExpiry, 0)
+	}
+	return info
+}
+
+// ListSessions returns the active device sessions of the user.
+func (s *UserService) ListSessions(userID uint) ([]auth.SessionInfo, error) {
+	return s.Session.ListSessions(userID)
+}
+
+// RevokeSession signs a device out: its refresh token is dropped and its token
+// family revoked, so access tokens already issued to it stop working too.
+func (s *UserService) RevokeSession(userID uint, device string) error {
+	stored, err := s.Session.GetRefreshToken(userID, device)
+	if err != nil || stored == "" {
+		return ErrSessionNotFound
+	}
+	if claims, err := auth.ParseTokenAllowExpired(stored); err == nil && claims.FamilyID != "" {
+		ttl := revocationTTL()
+		if err := s.Session.RevokeFamily(claims.FamilyID, ttl); err != nil {
+			return err
+		}
+	}
+	return s.Session.DeleteRefreshToken(userID, device)
+}
+
+// RevokeOtherSessions signs out every device except keepDevice and returns how
+// many sessions were revoked.
+func (s *UserService) RevokeOtherSessions(userID uint, keepDevice string) (int, error) {
+	return s.revokeSessions(userID, func(device string) bool { return device == keepDevice })
+}
+
+// RevokeAllSessions signs out every device of the user.
+func (s *UserService) RevokeAllSessions(userID uint) (int, error) {
+	return s.revokeSessions(userID, func(string) bool { return false })
+}
+
+func (s *UserService) revokeSessions(userID uint, keep func(device string) bool) (int, error) {
+	sessions, err := s.Session.ListSessions(userID)
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, sess := range sessions {
+		if keep(sess.Device) {
+			continue
+		}
+		if err := s.RevokeSession(userID, sess.Device); err != nil {
+			if errors.Is(err, ErrSessionNotFound) {
+				continue
+			}
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
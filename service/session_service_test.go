@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"redbook/internal/auth"
+)
+
+func TestRevokeOtherSessions(t *testing.T) {
+	s := newTestService(t)
+	for _, device := range []string{"phone", "web", "tablet"} {
+		_, refresh := seedSession(t, s, 9, device)
+		// 轮换一次以写入会话元数据
+		if _, _, err := s.RotateRefreshToken(refresh, ClientInfo{Device: device}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revoked, err := s.RevokeOtherSessions(9, "phone")
+	if err != nil || revoked != 2 {
+		t.Fatalf("RevokeOtherSessions = %d, %v", revoked, err)
+	}
+	sessions, _ := s.ListSessions(9)
+	if len(sessions) != 1 || sessions[0].Device != "phone" {
+		t.Fatalf("unexpected sessions left: %+v", sessions)
+	}
+}
+
+func TestRevokeSessionSignsDeviceOut(t *testing.T) {
+	s := newTestService(t)
+	access, refresh := seedSession(t, s, 12, "web")
+	otherAccess, _ := seedSession(t, s, 12, "phone")
+
+	if err := s.RevokeSession(12, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateAccessToken(access); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("access token of a revoked session = %v", err)
+	}
+	if _, _, err := s.RotateRefreshToken(refresh, ClientInfo{Device: "web"}); err == nil {
+		t.Fatal("refresh token of a revoked session still rotates")
+	}
+	if _, err := s.ValidateAccessToken(otherAccess); err != nil {
+		t.Fatalf("other device signed out too: %v", err)
+	}
+	if err := s.RevokeSession(12, "web"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("second revoke = %v", err)
+	}
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	s := newTestService(t)
+	for _, device := range []string{"phone", "web"} {
+		seedSession(t, s, 14, device)
+	}
+	revoked, err := s.RevokeAllSessions(14)
+	if err != nil || revoked != 2 {
+		t.Fatalf("RevokeAllSessions = %d, %v", revoked, err)
+	}
+	if sessions, _ := s.ListSessions(14); len(sessions) != 0 {
+		t.Fatalf("sessions left: %+v", sessions)
+	}
+}
+
+func TestSessionRecordKeepsLoginDetailsAcrossRotation(t *testing.T) {
+	s := newTestService(t)
+	_, refresh := seedSession(t, s, 23, "phone")
+	claims, _ := auth.ParseToken(refresh)
+	login := ClientInfo{Device: "phone", DeviceName: "Alice's iPhone", Platform: "ios", AppVersion: "3.1.0", IP: "203.0.113.1", UserAgent: "Redbook/3.1.0"}
+	if err := s.saveSession(claims.Params(), refresh, login, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := s.RotateRefreshToken(refresh, ClientInfo{Device: "phone", IP: "198.51.100.9", AppVersion: "3.2.0"}); err != nil {
+		t.Fatal(err)
+	}
+	sess, err := s.Session.GetSession(23, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.LoginIP != "203.0.113.1" || sess.LastSeenIP != "198.51.100.9" {
+		t.Fatalf("login ip %q, last seen ip %q", sess.LoginIP, sess.LastSeenIP)
+	}
+	if sess.DeviceName != "Alice's iPhone" || sess.Platform != "ios" || sess.AppVersion != "3.2.0" {
+		t.Fatalf("unexpected client details: %+v", sess)
+	}
+	if !sess.LastRefreshedAt.After(sess.CreatedAt) {
+		t.Fatalf("last refreshed %v should be after created %v", sess.LastRefreshedAt, sess.CreatedAt)
+	}
+}
+
+// seedSession 模拟一次登录：开启 token 家族并保存 refresh。
+func seedSession(t *testing.T, s *UserService, userID uint, device string) (string, string) {
+	t.Helper()
+	return seedSessionWith(t, s, auth.TokenParams{UserID: userID, Device: device})
+}
+
+// seedSessionWith 同 seedSession，可指定 DPoP 绑定、有效期等 token 参数；FamilyID 为空时新建家族。
+func seedSessionWith(t *testing.T, s *UserService, p auth.TokenParams) (string, string) {
+	t.Helper()
+	if p.FamilyID == "" {
+		familyID, err := auth.NewFamilyID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.FamilyID = familyID
+	}
+	warmTokenVersion(t, s, p.UserID)
+	access, refresh, err := auth.GenerateTokens(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Session.SaveSession(p.UserID, auth.SessionInfo{Device: p.Device, FamilyID: p.FamilyID}, refresh, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Session.SaveFamily(p.FamilyID, p.UserID, p.Device, 0); err != nil {
+		t.Fatal(err)
+	}
+	return access, refresh
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"redbook/internal/audit"
+	"redbook/model"
+)
+
+// ErrMobileVerified is returned when the user asks to verify a number twice.
+var ErrMobileVerified = errors.New("mobile already verified")
+
+// SendLoginCode sends an SMS login code. The send limits are charged before the
+// account lookup and unknown numbers are silently ignored, so neither the
+// response nor the rate limiting reveals whether an account exists.
+func (s *UserService) SendLoginCode(mobile, ip string) error {
+	if err := s.OTP.Reserve(OTPPurposeLogin, mobile, ip); err != nil {
+		return err
+	}
+	if _, err := s.dao.FindByMobile(mobile); err != nil {
+		return nil
+	}
+	s.issueSilently(OTPPurposeLogin, mobile)
+	return nil
+}
+
+// issueSilently sends a code whose request must be answered the same way for
+// registered and unknown numbers; delivery failures are only logged.
+func (s *UserService) issueSilently(purpose, mobile string) {
+	if err := s.OTP.Issue(purpose, mobile); err != nil {
+		log.Printf("send %s code failed: %v", purpose, err)
+	}
+}
+
+// LoginBySMS verifies the login code and issues the same token pair as Login.
+// A successful code also proves ownership of the number.
+func (s *UserService) LoginBySMS(mobile, code string, client ClientInfo) (string, string, error) {
+	id := mobileIdentifier(mobile)
+	entry := model.AuditEvent{Event: audit.EventLoginSMS, Identifier: mobile}
+	if err := s.checkLockout(id); err != nil {
+		RecordAudit(entry, client, err)
+		return "", "", err
+	}
+	if err := s.requireLoginCaptcha(id, client); err != nil {
+		RecordAudit(entry, client, err)
+		return "", "", err
+	}
+	if err := s.OTP.VerifyCode(OTPPurposeLogin, mobile, code); err != nil {
+		if errors.Is(err, ErrOTPInvalid) {
+			s.recordLoginRisk(id, client)
+		}
+		locked := errors.Is(err, ErrOTPInvalid) && s.recordLoginFailure(id)
+		auditLoginFailure(entry, client, err, locked)
+		return "", "", err
+	}
+	user, err := s.dao.FindByMobile(mobile)
+	if err != nil {
+		entry.Reason = "unknown mobile"
+		RecordAudit(entry, client, ErrOTPInvalid)
+		return "", "", ErrOTPInvalid
+	}
+	entry.UserID = user.ID
+	if !user.TOTPEnabled {
+		s.clearLoginFailures(id)
+	}
+	s.markMobileVerified(user)
+	access, refresh, err := s.completeLogin(user, client, true)
+	RecordAudit(entry, client, err)
+	return access, refresh, err
+}
+
+// SendMobileVerifyCode sends a verification code to the user's own number.
+func (s *UserService) SendMobileVerifyCode(userID uint, ip string) error {
+	user, err := s.dao.GetByID(uint64(userID))
+	if err != nil {
+		return err
+	}
+	if user.MobileVerified {
+		return ErrMobileVerified
+	}
+	return s.OTP.SendCode(OTPPurposeVerify, user.Mobile, ip)
+}
+
+// VerifyMobile marks the user's number as verified once the code matches.
+func (s *UserService) VerifyMobile(userID uint, code string) error {
+	user, err := s.dao.GetByID(uint64(userID))
+	if err != nil {
+		return err
+	}
+	if user.MobileVerified {
+		return ErrMobileVerified
+	}
+	if err := s.OTP.VerifyCode(OTPPurposeVerify, user.Mobile, code); err != nil {
+		return err
+	}
+	return s.dao.MarkMobileVerified(user.ID)
+}
+
+func (s *UserService) markMobileVerified(user *model.User) {
+	if user.MobileVerified {
+		return
+	}
+	if err := s.dao.MarkMobileVerified(user.ID); err == nil {
+		user.MobileVerified = true
+	}
+}
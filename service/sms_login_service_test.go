@@ -0,0 +1,98 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestSendLoginCodeSameLimitsForUnknownMobile(t *testing.T) {
+	s, db := newDBTestService(t)
+	sms := useRecordingSMS(s)
+	createTestUser(t, db, "alice", "13800000001", "Secret#2024")
+
+	for _, mobile := range []string{"13800000001", "13800000002"} {
+		if err := s.SendLoginCode(mobile, "10.0.0.1"); err != nil {
+			t.Fatalf("first send to %s: %v", mobile, err)
+		}
+		// 冷却期内无论号码是否注册都被同样拒绝
+		if err := s.SendLoginCode(mobile, "10.0.0.1"); !errors.Is(err, ErrOTPTooFrequent) {
+			t.Fatalf("second send to %s = %v, want cooldown", mobile, err)
+		}
+	}
+	if len(sms.sent["13800000001"]) != 1 {
+		t.Fatalf("registered mobile got %d messages", len(sms.sent["13800000001"]))
+	}
+	if len(sms.sent["13800000002"]) != 0 {
+		t.Fatal("unknown mobile must not receive a code")
+	}
+}
+
+func TestLoginBySMS(t *testing.T) {
+	s, db := newDBTestService(t)
+	sms := useRecordingSMS(s)
+	user := createTestUser(t, db, "bob", "13800000003", "Secret#2024")
+	warmTokenVersion(t, s, uint(user.ID))
+
+	if err := s.SendLoginCode(user.Mobile, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	code := sms.lastCode(t, user.Mobile)
+	if _, _, err := s.LoginBySMS(user.Mobile, wrongCode(code), ClientInfo{Device: "phone"}); !errors.Is(err, ErrOTPInvalid) {
+		t.Fatalf("wrong code = %v", err)
+	}
+	// 跳过失败后的退避等待
+	s.clearLoginFailures(mobileIdentifier(user.Mobile))
+	access, _, err := s.LoginBySMS(user.Mobile, code, ClientInfo{Device: "phone"})
+	if err != nil {
+		t.Fatalf("login with the sent code: %v", err)
+	}
+	if claims, err := s.ValidateAccessToken(access); err != nil || uint64(claims.UserID) != user.ID {
+		t.Fatalf("issued token = %v, %v", claims, err)
+	}
+	// 验证码一次性
+	if _, _, err := s.LoginBySMS(user.Mobile, code, ClientInfo{Device: "phone"}); !errors.Is(err, ErrOTPInvalid) {
+		t.Fatalf("reused code = %v", err)
+	}
+}
+
+// recordingSMS 记录发出的短信，测试从中取出验证码。
+type recordingSMS struct {
+	sent map[string][]string
+}
+
+func (r *recordingSMS) Send(mobile, message string) error {
+	r.sent[mobile] = append(r.sent[mobile], message)
+	return nil
+}
+
+// lastCode 返回发往 mobile 的最近一条短信中的验证码。
+func (r *recordingSMS) lastCode(t *testing.T, mobile string) string {
+	t.Helper()
+	msgs := r.sent[mobile]
+	if len(msgs) == 0 {
+		t.Fatalf("no code sent to %s", mobile)
+	}
+	code := otpCodePattern.FindString(msgs[len(msgs)-1])
+	if code == "" {
+		t.Fatalf("no code in %q", msgs[len(msgs)-1])
+	}
+	return code
+}
+
+var otpCodePattern = regexp.MustCompile(`\d{6}`)
+
+// useRecordingSMS 让服务的验证码改发到内存记录。
+func useRecordingSMS(s *UserService) *recordingSMS {
+	rec := &recordingSMS{sent: map[string][]string{}}
+	s.OTP = NewOTPService(s.Session.Store(), rec)
+	return rec
+}
+
+// wrongCode 返回一个与 code 不同的六位验证码。
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
@@ -0,0 +1,232 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"redbook/internal/auth"
+	"strconv"
+	"time"
+)
+
+// tokenVersionCacheTTL bounds how long a cached version may be served; bumps
+// overwrite the cache immediately, so this only matters after cache loss.
+const tokenVersionCacheTTL = 10 * time.Minute
+
+// ErrTokenVersionLookup 读取 token 版本失败（数据库不可用等），调用方应返回 500 而非 401。
+var ErrTokenVersionLookup = fmt.Errorf("token version lookup failed: %w", auth.ErrUnavailable)
+
+// ErrRevocationLookup 读取黑名单或 token 家族状态失败；不能据此放行 token。
+var ErrRevocationLookup = fmt.Errorf("token revocation lookup failed: %w", auth.ErrUnavailable)
+
+func tokenVersionKey(userID uint) string {
+	return fmt.Sprintf("rb:tv:%d", userID)
+}
+
+// TokenVersion returns the user's current token version, served from the
+// session store and falling back to MySQL on a miss.
+func (s *UserService) TokenVersion(userID uint) (int64, error) {
+	if v, err := s.Session.Store().Get(tokenVersionKey(userID)); err == nil {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return parsed, nil
+		}
+	}
+	version, err := s.dao.GetTokenVersion(uint64(userID))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrTokenVersionLookup, err)
+	}
+	s.fillTokenVersionCache(userID, version)
+	return version, nil
+}
+
+// fillTokenVersionCache caches a version read from MySQL only while the cache
+// is empty: a BumpTokenVersion racing with the read may already have cached a
+// newer version, which must not be overwritten by the stale one.
+func (s *UserService) fillTokenVersionCache(userID uint, version int64) {
+	_, _ = s.Session.Store().CompareAndSet(tokenVersionKey(userID), "", strconv.FormatInt(version, 10), tokenVersionCacheTTL)
+}
+
+// BumpTokenVersion invalidates every token already issued to the user.
+func (s *UserService) BumpTokenVersion(userID uint) error {
+	version, err := s.dao.IncrTokenVersion(uint64(userID))
+	if err != nil {
+		return err
+	}
+	return s.raiseCachedTokenVersion(userID, version)
+}
+
+// tokenVersionCASAttempts bounds the compare-and-set retries of raiseCachedTokenVersion.
+const tokenVersionCASAttempts = 5
+
+// raiseCachedTokenVersion writes version to the cache unless a newer one is
+// already there, so concurrent bumps finishing out of order never move the
+// cached version backwards. When the cache keeps changing underneath, it is
+// dropped and the next read goes to MySQL.
+func (s *UserService) raiseCachedTokenVersion(userID uint, version int64) error {
+	store := s.Session.Store()
+	key := tokenVersionKey(userID)
+	value := strconv.FormatInt(version, 10)
+	for i := 0; i < tokenVersionCASAttempts; i++ {
+		cur, err := store.Get(key)
+		if err != nil && !errors.Is(err, auth.ErrNotFound) {
+			return err
+		}
+		if cached, perr := strconv.ParseInt(cur, 10, 64); err == nil && perr == nil && cached >= version {
+			return nil
+		}
+		if ok, err := store.CompareAndSet(key, cur, value, tokenVersionCacheTTL); err != nil || ok {
+			return err
+		}
+	}
+	return store.Del(key)
+}
+
+// LogoutAll signs the user out everywhere: the token version is bumped so
+// outstanding access tokens fail immediately, and every device session is dropped.
+func (s *UserService) LogoutAll(userID uint) error {
+	if err := s.BumpTokenVersion(userID); err != nil {
+		return err
+	}
+	_, err := s.RevokeAllSessions(userID)
+	return err
+}
+
+// ValidateAccessToken performs every check an access token must pass: blacklist,
+// signature and expiry, family revocation and the per-user token version.
+func (s *UserService) ValidateAccessToken(token string) (*auth.Claims, error) {
+	// 检查 token 是否在黑名单
+	in, err := s.Session.InBlackList(token)
+	if err != nil {
+		return nil, ErrRevocationLookup
+	}
+	if in {
+		return nil, ErrTokenRevoked
+	}
+
+	claims, err := auth.ParseToken(token)
+	if err != nil {
+		// access 的过期时间同样不越过会话的绝对到期时间
+		if expired, perr := auth.ParseTokenAllowExpired(token); perr == nil && expired.LifetimeExceeded(time.Now()) {
+			return nil, ErrSessionExpired
+		}
+		return nil, ErrTokenInvalid
+	}
+	if claims.TokenUse == auth.TokenUseRefresh {
+		return nil, ErrTokenInvalid
+	}
+
+	// 所属 token 家族被吊销（如检测到 refresh 重放）时，家族内 access token 一并失效
+	if claims.FamilyID != "" {
+		revoked, err := s.Session.FamilyRevoked(claims.FamilyID)
+		if err != nil {
+			return nil, ErrRevocationLookup
+		}
+		if revoked {
+			return nil, s.revokedFamilyError(claims.FamilyID, ErrTokenRevoked)
+		}
+	}
+
+	version, err := s.TokenVersion(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenVersion != version {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// ValidateRefreshToken reports whether a refresh token could still be rotated,
+// without rotating it. Used by token introspection.
+func (s *UserService) ValidateRefreshToken(token string) (*auth.Claims, error) {
+	claims, err := auth.ParseToken(token)
+	if err != nil || claims.TokenUse == auth.TokenUseAccess {
+		return nil, ErrTokenInvalid
+	}
+	if claims.FamilyID != "" {
+		revoked, err := s.Session.FamilyRevoked(claims.FamilyID)
+		if err != nil {
+			return nil, ErrRevocationLookup
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+	stored, err := s.Session.GetRefreshToken(claims.UserID, claims.Device)
+	if err != nil && !errors.Is(err, auth.ErrNotFound) {
+		return nil, ErrRevocationLookup
+	}
+	if stored != token {
+		return nil, ErrTokenRevoked
+	}
+	version, err := s.TokenVersion(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenVersion != version {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// Logout ends the device session of the presented token. An access token must
+// pass every access check; a refresh token must still be the current one of its
+// session, so a client whose access token has expired can still sign out.
+// DPoP-bound tokens need a proof for the bound key. The returned claims are
+// those of the presented token, also on failure when it could be parsed.
+func (s *UserService) Logout(token string, client ClientInfo) (*auth.Claims, error) {
+	presented, err := auth.ParseTokenAllowExpired(token)
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	var claims *auth.Claims
+	if presented.TokenUse == auth.TokenUseRefresh {
+		claims, err = s.ValidateRefreshToken(token)
+	} else {
+		claims, err = s.ValidateAccessToken(token)
+	}
+	if err != nil {
+		return presented, err
+	}
+	if jkt := claims.BoundKey(); jkt != "" && jkt != client.DPoPKey {
+		return claims, auth.ErrDPoPBinding
+	}
+
+	if ttl := time.Until(claims.ExpiresAt.Time); ttl > 0 {
+		if err := s.Session.AddBlackList(token, ttl); err != nil {
+			return claims, err
+		}
+	}
+	if claims.FamilyID != "" {
+		if err := s.Session.RevokeFamily(claims.FamilyID, revocationTTL()); err != nil {
+			return claims, err
+		}
+	}
+	// 同一设备上更新的登录属于另一个家族，不受旧 token 注销影响；未记录家族的旧会话照常删除
+	if sess, err := s.Session.GetSession(claims.UserID, claims.Device); err == nil && (sess.FamilyID == "" || sess.FamilyID == claims.FamilyID) {
+		return claims, s.Session.DeleteRefreshToken(claims.UserID, claims.Device)
+	}
+	return claims, nil
+}
+
+// RevokeToken invalidates a single token: an access token is blacklisted for
+// the rest of its lifetime, a refresh token ends its whole session and family.
+func (s *UserService) RevokeToken(token string, claims *auth.Claims) error {
+	if claims.TokenUse != auth.TokenUseRefresh {
+		ttl := time.Until(claims.ExpiresAt.Time)
+		if ttl <= 0 {
+			return nil
+		}
+		return s.Session.AddBlackList(token, ttl)
+	}
+
+	if claims.FamilyID != "" {
+		ttl := revocationTTL()
+		if err := s.Session.RevokeFamily(claims.FamilyID, ttl); err != nil {
+			return err
+		}
+	}
+	if stored, err := s.Session.GetRefreshToken(claims.UserID, claims.Device); err == nil && stored == token {
+		return s.Session.DeleteRefreshToken(claims.UserID, claims.Device)
+	}
+	return nil
+}
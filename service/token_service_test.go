@@ -0,0 +1,187 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"redbook/internal/auth"
+)
+
+func TestValidateAccessTokenRejectsStaleVersion(t *testing.T) {
+	s := newTestService(t)
+	access, _ := seedSession(t, s, 11, "phone")
+	if _, err := s.ValidateAccessToken(access); err != nil {
+		t.Fatalf("fresh token rejected: %v", err)
+	}
+
+	// 模拟 BumpTokenVersion 写回缓存后的状态
+	_ = s.Session.Store().Set(tokenVersionKey(11), "1", 0)
+	if _, err := s.ValidateAccessToken(access); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("expected stale version to be revoked, got %v", err)
+	}
+}
+
+func TestTokenVersionCacheNeverMovesBackwards(t *testing.T) {
+	s, db := newDBTestService(t)
+	user := createTestUser(t, db, "tv", "13800000011", "Str0ng!Passw0rd")
+	id := uint(user.ID)
+	if v, err := s.TokenVersion(id); err != nil || v != 0 {
+		t.Fatalf("TokenVersion = %d, %v", v, err)
+	}
+
+	// 读缓存未命中后从 MySQL 读到 0，回填前 BumpTokenVersion 已把 1 写入缓存
+	_ = s.Session.Store().Del(tokenVersionKey(id))
+	if err := s.BumpTokenVersion(id); err != nil {
+		t.Fatal(err)
+	}
+	s.fillTokenVersionCache(id, 0)
+	if v, err := s.TokenVersion(id); err != nil || v != 1 {
+		t.Fatalf("stale read overwrote the bumped version: %d, %v", v, err)
+	}
+
+	// 并发递增乱序完成时，较小的版本不能覆盖较大的
+	if err := s.raiseCachedTokenVersion(id, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.raiseCachedTokenVersion(id, 2); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.TokenVersion(id); v != 3 {
+		t.Fatalf("cached version = %d, want 3", v)
+	}
+}
+
+func TestTokenVersionLookupFailureIsNotUnauthorized(t *testing.T) {
+	s, db := newDBTestService(t)
+	user := createTestUser(t, db, "tv2", "13800000012", "Str0ng!Passw0rd")
+	access, _ := seedSession(t, s, uint(user.ID), "phone")
+	_ = s.Session.Store().Del(tokenVersionKey(uint(user.ID)))
+
+	sqlDB, _ := db.DB()
+	_ = sqlDB.Close()
+	_, err := s.ValidateAccessToken(access)
+	if !errors.Is(err, ErrTokenVersionLookup) || !errors.Is(err, auth.ErrUnavailable) {
+		t.Fatalf("expected a backend failure, got %v", err)
+	}
+}
+
+func TestRevocationLookupFailureIsNotAccepted(t *testing.T) {
+	s := newTestService(t)
+	access, refresh := seedSession(t, s, 33, "phone")
+	store := useFaultyStore(s)
+
+	// 黑名单或家族状态读不到时既不能放行，也不能当成 401
+	for _, prefix := range []string{"rb:black:", "rb:family:revoked:"} {
+		store.fail = func(key string) error {
+			if strings.HasPrefix(key, prefix) {
+				return errStoreDown
+			}
+			return nil
+		}
+		if _, err := s.ValidateAccessToken(access); !errors.Is(err, ErrRevocationLookup) || !errors.Is(err, auth.ErrUnavailable) {
+			t.Fatalf("%s failure: access token = %v", prefix, err)
+		}
+	}
+	if _, err := s.ValidateRefreshToken(refresh); !errors.Is(err, auth.ErrUnavailable) {
+		t.Fatalf("family lookup failure: refresh token = %v", err)
+	}
+	store.fail = nil
+	if _, err := s.ValidateAccessToken(access); err != nil {
+		t.Fatalf("access token after recovery: %v", err)
+	}
+}
+
+func TestTokenUseSeparation(t *testing.T) {
+	s := newTestService(t)
+	access, refresh := seedSession(t, s, 31, "phone")
+
+	// 受保护接口只接受 access token
+	if _, err := s.ValidateAccessToken(refresh); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("refresh token accepted as access token: %v", err)
+	}
+	// 刷新接口只接受 refresh token
+	if _, _, err := s.RotateRefreshToken(access, ClientInfo{Device: "phone"}); err == nil {
+		t.Fatal("access token accepted by refresh")
+	}
+	if _, err := s.ValidateRefreshToken(access); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("access token accepted as refresh token: %v", err)
+	}
+	if _, err := s.ValidateAccessToken(access); err != nil {
+		t.Fatalf("access token rejected: %v", err)
+	}
+	if _, _, err := s.RotateRefreshToken(refresh, ClientInfo{Device: "phone"}); err != nil {
+		t.Fatalf("refresh token rejected: %v", err)
+	}
+}
+
+func TestLogoutWithAccessToken(t *testing.T) {
+	s := newTestService(t)
+	access, refresh := seedSession(t, s, 32, "phone")
+
+	claims, err := s.Logout(access, ClientInfo{})
+	if err != nil || claims.UserID != 32 {
+		t.Fatalf("logout = %v, %v", claims, err)
+	}
+	if _, err := s.ValidateAccessToken(access); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("access token after logout = %v", err)
+	}
+	if _, _, err := s.RotateRefreshToken(refresh, ClientInfo{Device: "phone"}); err == nil {
+		t.Fatal("refresh token survived logout")
+	}
+	if _, err := s.Logout(access, ClientInfo{}); err == nil {
+		t.Fatal("second logout with the same token should fail")
+	}
+}
+
+func TestLogoutWithRefreshToken(t *testing.T) {
+	s := newTestService(t)
+	access, refresh := seedSession(t, s, 33, "phone")
+
+	if _, err := s.Logout(refresh, ClientInfo{}); err != nil {
+		t.Fatalf("logout with refresh token: %v", err)
+	}
+	// 注销吊销整个家族，同一会话的 access token 随之失效
+	if _, err := s.ValidateAccessToken(access); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("access token after refresh logout = %v", err)
+	}
+	if sessions, _ := s.ListSessions(33); len(sessions) != 0 {
+		t.Fatalf("session survived logout: %v", sessions)
+	}
+	if _, err := s.Logout(refresh, ClientInfo{}); err == nil {
+		t.Fatal("refresh token usable after logout")
+	}
+}
+
+func TestLogoutKeepsNewerSessionOnSameDevice(t *testing.T) {
+	s := newTestService(t)
+	oldAccess, _ := seedSession(t, s, 34, "phone")
+	_, current := seedSession(t, s, 34, "phone")
+
+	if _, err := s.Logout(oldAccess, ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.RotateRefreshToken(current, ClientInfo{Device: "phone"}); err != nil {
+		t.Fatalf("newer login was signed out by an old token: %v", err)
+	}
+}
+
+func TestLogoutDPoPBoundTokenRequiresKey(t *testing.T) {
+	s := newTestService(t)
+	access, _ := seedSessionWith(t, s, auth.TokenParams{UserID: 35, Device: "phone", JKT: "key-a"})
+
+	if _, err := s.Logout(access, ClientInfo{}); !errors.Is(err, auth.ErrDPoPBinding) {
+		t.Fatalf("logout without proof = %v", err)
+	}
+	if _, err := s.Logout(access, ClientInfo{DPoPKey: "key-a"}); err != nil {
+		t.Fatalf("logout with the bound key: %v", err)
+	}
+}
+
+// warmTokenVersion 预热 token 版本缓存，避免访问 MySQL。
+func warmTokenVersion(t *testing.T, s *UserService, userID uint) {
+	t.Helper()
+	if err := s.Session.Store().Set(tokenVersionKey(userID), "0", 0); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,36 +2,71 @@ package service
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"redbook/config"
 	"redbook/dao"
+	"redbook/internal/audit"
 	"redbook/internal/auth"
+	"redbook/internal/captcha"
+	"redbook/internal/metrics"
+	"redbook/internal/notify"
+	"redbook/internal/sms"
+	"redbook/internal/validator"
 	"redbook/model"
 	"redbook/utils"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/go-sql-driver/mysql"
 	"gorm.io/gorm"
 )
 
-var ErrUserExists = errors.New("user already exists")
+var (
+	ErrUserExists = errors.New("user already exists")
+	// ErrRefreshReused 表示已轮换的 refresh token 被再次使用，整个 token 家族已被吊销。
+	ErrRefreshReused = errors.New("refresh token reuse detected")
+	// ErrTokenInvalid / ErrTokenRevoked 由 access token 校验返回。
+	ErrTokenInvalid = errors.New("invalid token")
+	ErrTokenRevoked = errors.New("token revoked")
+)
 
 // UserService bundles the DAO, session storage and authentication helpers.
 type UserService struct {
 	dao     *dao.UserDAO
 	Session *auth.SessionManager // 使用 internal/auth 中的 SessionManager
+	OTP     *OTPService
+	// Passwords 新密码的强度策略；泄露密码库由 main 按配置加载
+	Passwords *validator.PasswordPolicy
+	// Notifier 新设备 / 陌生网络登录提醒，默认写日志，main 按配置替换
+	Notifier notify.Notifier
+	// Captcha 风险阈值触发后登录 / 注册所需的图形验证码
+	Captcha *captcha.Manager
+	// DPoP 校验 token 持有证明，jti 与 nonce 记录在会话存储中
+	DPoP *auth.DPoPVerifier
 }
 
 // NewUserService 创建一个新的 UserService 实例
-func NewUserService(dao *dao.UserDAO, rdb *redis.Client) *UserService {
+func NewUserService(dao *dao.UserDAO, store auth.SessionStore, provider sms.SMSProvider) *UserService {
 	return &UserService{
-		dao:     dao,
-		Session: auth.NewSessionManager(rdb), // 初始化 auth.SessionManager
+		dao:       dao,
+		Session:   auth.NewSessionManager(store), // 初始化 auth.SessionManager
+		OTP:       NewOTPService(store, provider),
+		Passwords: validator.NewPasswordPolicy(config.GlobalConfig.Password.Policy),
+		Notifier:  notify.LogNotifier{},
+		Captcha:   captcha.NewManager(store, config.GlobalConfig.Captcha),
+		DPoP:      auth.NewDPoPVerifier(store, config.GlobalConfig.DPoP),
 	}
 }
 
-// Register persists a freshly created user after hashing the password.
-func (s *UserService) Register(user *model.User) error {
+// Register persists a freshly created user after hashing the password. Clients
+// that register often from one IP must solve a CAPTCHA first.
+func (s *UserService) Register(user *model.User, client ClientInfo) error {
+	if err := s.requireRegisterCaptcha(client); err != nil {
+		return err
+	}
+	if err := s.Passwords.Check(user.Password, user.Username, user.Mobile); err != nil {
+		return err
+	}
 	hashed, err := utils.HashPassword(user.Password)
 	if err != nil {
 		return err
@@ -50,65 +85,286 @@ func (s *UserService) Register(user *model.User) error {
 	return nil
 }
 
+// GetProfile returns the user record for profile endpoints.
+func (s *UserService) GetProfile(userID uint) (*model.User, error) {
+	return s.dao.GetByID(uint64(userID))
+}
+
+// ClientInfo describes the client a device session is issued to.
+type ClientInfo struct {
+	Device    string
+	IP        string
+	UserAgent string
+	// CaptchaID / CaptchaAnswer 客户端提交的图形验证码，仅在风险阈值触发后校验
+	CaptchaID     string
+	CaptchaAnswer string
+	// CaptchaPassed 表示限流中间件已校验过验证码
+	CaptchaPassed bool
+	// DPoPKey 请求所附 DPoP 证明的公钥指纹（已由中间件校验），签发的 token 绑定该公钥
+	DPoPKey string
+	// DeviceClass 设备类型 mobile / tablet / web，登录时由服务端按 UA 判定并记录在会话中，刷新时沿用
+	DeviceClass string
+	// DeviceName / Platform / AppVersion 客户端上报的设备名、系统平台与应用版本，记录在会话中
+	DeviceName string
+	Platform   string
+	AppVersion string
+	// DeviceTrust 可信设备出示的信任凭证（X-Device-Trust），由服务端在标记可信时签发
+	DeviceTrust string
+}
+
 // Login handles username/password authentication and issues a token pair.
-func (s *UserService) Login(username, password, device string) (string, string, error) {
+func (s *UserService) Login(username, password string, client ClientInfo) (string, string, error) {
+	id := usernameIdentifier(username)
+	entry := model.AuditEvent{Event: audit.EventLoginPassword, Identifier: username}
+	if err := s.checkLockout(id); err != nil {
+		RecordAudit(entry, client, err)
+		return "", "", err
+	}
+	if err := s.requireLoginCaptcha(id, client); err != nil {
+		RecordAudit(entry, client, err)
+		return "", "", err
+	}
+
 	user, err := s.dao.GetByUsername(username)
 	if err != nil || user.ID == 0 {
-		return "", "", errors.New("用户名或密码错误")
+		entry.Reason = "unknown user"
+		s.recordLoginRisk(id, client)
+		auditLoginFailure(entry, client, ErrInvalidCredentials, s.recordLoginFailure(id))
+		return "", "", ErrInvalidCredentials
 	}
+	entry.UserID = user.ID
 
 	// 校验密码
 	if !utils.CheckPasswordHash(password, user.Password) {
-		return "", "", errors.New("用户名或密码错误")
+		entry.Reason = "wrong password"
+		s.recordLoginRisk(id, client)
+		auditLoginFailure(entry, client, ErrInvalidCredentials, s.recordLoginFailure(id))
+		return "", "", ErrInvalidCredentials
 	}
 
+	// 开启 2FA 的账号在第二因素通过后才清除失败计数，否则每次输对密码都会重置 TOTP 的尝试次数
+	if !user.TOTPEnabled {
+		s.clearLoginFailures(id)
+	}
+	s.upgradePasswordHash(user, password)
+	access, refresh, err := s.completeLogin(user, client, false)
+	RecordAudit(entry, client, err)
+	return access, refresh, err
+}
+
+// upgradePasswordHash re-hashes a verified password when its stored hash uses an
+// old scheme or parameters. Failures are only logged; the login goes on.
+func (s *UserService) upgradePasswordHash(user *model.User, password string) {
+	if !utils.PasswordNeedsRehash(user.Password) {
+		return
+	}
+	hashed, err := utils.HashPassword(password)
+	if err == nil {
+		err = s.dao.UpdatePassword(user.ID, hashed)
+	}
+	if err != nil {
+		log.Printf("upgrade password hash for user %d failed: %v", user.ID, err)
+		return
+	}
+	user.Password = hashed
+}
+
+// issueSession starts a new token family for the user on the client's device and
+// returns the first token pair. Every login method ends here.
+func (s *UserService) issueSession(user *model.User, client ClientInfo) (string, string, error) {
+	client.DeviceClass = DeviceClass(client.UserAgent)
+	if err := s.enforceSessionLimit(uint(user.ID), client); err != nil {
+		return "", "", err
+	}
+	access, refresh, err := s.startSession(auth.TokenParams{
+		UserID:       uint(user.ID),
+		Device:       client.Device,
+		TokenVersion: user.TokenVersion,
+	}, client)
+	if err != nil {
+		return "", "", err
+	}
+	s.rememberLogin(user.ID, client)
+	return access, refresh, nil
+}
+
+// startSession opens a token family for the given params, persists the refresh
+// token and records the device metadata.
+func (s *UserService) startSession(params auth.TokenParams, client ClientInfo) (string, string, error) {
+	// 每次登录开启一个新的 token 家族
+	familyID, err := auth.NewFamilyID()
+	if err != nil {
+		return "", "", err
+	}
+	params.FamilyID = familyID
+	params.JKT = client.DPoPKey
+	// 登录时按客户端类型确定空闲超时与绝对有效期
+	now := time.Now()
+	applyLifetime(&params, lifetimeClass(params.Device, client.DeviceClass), now)
+
 	// 使用 SessionManager 存储 Refresh Token 和生成 Token
-	accessToken, refreshToken, err := auth.GenerateTokens(uint(user.ID), device)
+	accessToken, refreshToken, err := auth.GenerateTokens(params)
 	if err != nil {
 		return "", "", err
 	}
 
 	// 保存 Refresh Token 到 Redis
-	ttl := time.Duration(config.GlobalConfig.JWT.RefreshExpire) * time.Second
-	s.Session.SaveRefreshToken(uint(user.ID), device, refreshToken, ttl)
+	ttl := sessionTTL(params, now)
+	client.Device = params.Device
+	if err := s.saveSession(params, refreshToken, client, ttl); err != nil {
+		return "", "", err
+	}
+	if err := s.Session.SaveFamily(familyID, params.UserID, params.Device, ttl); err != nil {
+		return "", "", err
+	}
 
 	// 返回生成的 Access Token 和 Refresh Token
 	return accessToken, refreshToken, nil
 }
 
 // RotateRefreshToken 校验 refresh token、执行黑名单写入，并颁发新的 token 对。
-func (s *UserService) RotateRefreshToken(refreshToken, headerDevice string) (string, string, error) {
+func (s *UserService) RotateRefreshToken(refreshToken string, client ClientInfo) (string, string, error) {
+	access, refresh, err := s.rotateRefreshToken(refreshToken, client)
+	entry := model.AuditEvent{Event: audit.EventRefresh}
+	// 审计只需要归属账号，签名有效即可，不关心是否过期
+	if claims, perr := auth.ParseTokenAllowExpired(refreshToken); perr == nil {
+		entry.UserID = uint64(claims.UserID)
+		if client.Device == "" {
+			client.Device = claims.Device
+		}
+	}
+	RecordAudit(entry, client, err)
+	return access, refresh, err
+}
+
+func (s *UserService) rotateRefreshToken(refreshToken string, client ClientInfo) (string, string, error) {
 	if refreshToken == "" {
 		return "", "", errors.New("missing refresh token")
 	}
 
 	claims, err := auth.ParseToken(refreshToken)
 	if err != nil {
+		// refresh 的过期时间不会越过会话的绝对到期时间，过期时区分出"需要重新登录"
+		if expired, perr := auth.ParseTokenAllowExpired(refreshToken); perr == nil && expired.LifetimeExceeded(time.Now()) {
+			return "", "", ErrSessionExpired
+		}
+		return "", "", errors.New("refresh token invalid")
+	}
+	if claims.TokenUse == auth.TokenUseAccess {
 		return "", "", errors.New("refresh token invalid")
 	}
+	now := time.Now()
+	if claims.LifetimeExceeded(now) {
+		return "", "", ErrSessionExpired
+	}
 
 	// 可选：若客户端提供 X-Device，需与 Token claims 匹配。
-	if headerDevice != "" && headerDevice != claims.Device {
+	if client.Device != "" && client.Device != claims.Device {
 		return "", "", errors.New("device mismatch")
 	}
+	// 绑定了 DPoP 公钥的 refresh 只能由持有该私钥的客户端轮换
+	if jkt := claims.BoundKey(); jkt != "" && jkt != client.DPoPKey {
+		metrics.IncSecurityEvent("dpop_binding_mismatch")
+		return "", "", auth.ErrDPoPBinding
+	}
 
+	if claims.FamilyID != "" {
+		revoked, err := s.Session.FamilyRevoked(claims.FamilyID)
+		if err != nil {
+			return "", "", ErrRevocationLookup
+		}
+		if revoked {
+			return "", "", s.revokedFamilyError(claims.FamilyID, errors.New("refresh token revoked"))
+		}
+	}
+
+	// 存储故障不能当成"不是当前成员"，否则一次抖动就会吊销整个家族
 	stored, err := s.Session.GetRefreshToken(claims.UserID, claims.Device)
-	if err != nil || stored != refreshToken {
-		return "", "", errors.New("refresh token expired or rotated")
+	if err != nil && !errors.Is(err, auth.ErrNotFound) {
+		return "", "", fmt.Errorf("refresh token lookup failed: %w", auth.ErrUnavailable)
+	}
+	if stored != refreshToken {
+		return "", "", s.refreshMismatch(claims, stored)
+	}
+
+	// 用户递增过 token 版本（全端登出、改密等）后，旧 refresh 不可再轮换
+	version, err := s.TokenVersion(claims.UserID)
+	if err != nil {
+		return "", "", err
+	}
+	if claims.TokenVersion != version {
+		return "", "", ErrTokenRevoked
 	}
 
-	accessToken, newRefresh, err := auth.GenerateTokens(claims.UserID, claims.Device)
+	// 空闲超时从本次刷新起顺延，绝对到期时间沿用登录时的值
+	params := claims.Params()
+	if params.MaxExpiry == 0 {
+		// 引入会话有效期之前签发的 token：从本次轮换起套用其客户端类型的策略
+		class := ""
+		if sess, err := s.Session.GetSession(claims.UserID, claims.Device); err == nil {
+			class = sessionClass(*sess)
+		}
+		applyLifetime(&params, lifetimeClass(claims.Device, class), now)
+	}
+	accessToken, newRefresh, err := auth.GenerateTokens(params)
 	if err != nil {
 		return "", "", err
 	}
 
-	ttl := time.Duration(config.GlobalConfig.JWT.RefreshExpire) * time.Second
-	if err := s.Session.SaveRefreshToken(claims.UserID, claims.Device, newRefresh, ttl); err != nil {
+	ttl := sessionTTL(params, now)
+	client.Device = claims.Device
+	client.DeviceClass = "" // 沿用登录时记录的设备类型
+	// 只有会话仍保存着本次提交的 refresh 时才写入新 token；并发轮换同一 token 时落败的一方按重放处理
+	if err := s.rotateSession(params, refreshToken, newRefresh, client, ttl); err != nil {
+		if errors.Is(err, auth.ErrSessionRotated) {
+			current, _ := s.Session.GetRefreshToken(claims.UserID, claims.Device)
+			return "", "", s.refreshMismatch(claims, current)
+		}
 		return "", "", err
 	}
+	if claims.FamilyID != "" {
+		if err := s.Session.SaveFamily(claims.FamilyID, claims.UserID, claims.Device, ttl); err != nil {
+			return "", "", err
+		}
+	}
 
 	// 将旧 refresh token 加入黑名单，防止被重放。
 	_ = s.Session.AddBlackList(refreshToken, ttl)
 
 	return accessToken, newRefresh, nil
 }
+
+// refreshMismatch answers a refresh token that is not the one stored for its
+// device. While the family is still live this is a replay of a rotated token and
+// the whole family is revoked.
+func (s *UserService) refreshMismatch(claims *auth.Claims, stored string) error {
+	if claims.FamilyID != "" {
+		live, err := s.Session.FamilyExists(claims.FamilyID)
+		if err != nil {
+			return ErrRevocationLookup
+		}
+		if live {
+			s.revokeReusedFamily(claims, stored)
+			return ErrRefreshReused
+		}
+	}
+	return errors.New("refresh token expired or rotated")
+}
+
+// revokeReusedFamily revokes a family after one of its retired refresh tokens was
+// replayed. The device session is only dropped when it still belongs to that family,
+// so a newer login on the same device is left untouched.
+func (s *UserService) revokeReusedFamily(claims *auth.Claims, stored string) {
+	ttl := revocationTTL()
+	if err := s.Session.RevokeFamily(claims.FamilyID, ttl); err != nil {
+		log.Printf("revoke token family %s failed: %v", claims.FamilyID, err)
+	}
+	if stored != "" {
+		if current, err := auth.ParseTokenAllowExpired(stored); err == nil && current.FamilyID == claims.FamilyID {
+			_ = s.Session.DeleteRefreshToken(claims.UserID, claims.Device)
+		}
+	}
+	metrics.IncSecurityEvent("refresh_reuse")
+	log.Printf("security: refresh token reuse detected user=%d device=%s family=%s",
+		claims.UserID, claims.Device, claims.FamilyID)
+}
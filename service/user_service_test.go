@@ -0,0 +1,256 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"redbook/config"
+	"redbook/dao"
+	"redbook/internal/auth"
+	"redbook/internal/sms"
+	"redbook/model"
+	"redbook/utils"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestService(t *testing.T) *UserService {
+	t.Helper()
+	config.GlobalConfig = &config.Config{JWT: config.JWTConfig{
+		Secret:        "test-secret",
+		AccessExpire:  60,
+		RefreshExpire: 600,
+	}}
+	if _, err := auth.InitKeys(); err != nil {
+		t.Fatal(err)
+	}
+	return NewUserService(nil, auth.NewMemoryStore(), sms.NewLogProvider(""))
+}
+
+// newTestDB 打开进程内的 SQLite 数据库并迁移全部模型，每个测试各用一个独立的库。
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存库随连接消失，只保留一个连接
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	if err := db.AutoMigrate(&model.User{}, &model.RecoveryCode{}, &model.OAuthClient{}, &model.OAuthConsent{},
+		&model.Role{}, &model.Permission{}, &model.UserRole{}, &model.PersonalAccessToken{}, &model.AuditEvent{}, &model.KnownDevice{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newDBTestService 同 newTestService，但带有 SQLite 上的 UserDAO，用于需要读写用户表的流程。
+func newDBTestService(t *testing.T) (*UserService, *gorm.DB) {
+	t.Helper()
+	s := newTestService(t)
+	db := newTestDB(t)
+	s.dao = dao.NewUserDAO(db)
+	return s, db
+}
+
+// createTestUser 直接写入一个用户，密码为明文 password 的哈希。
+func createTestUser(t *testing.T, db *gorm.DB, username, mobile, password string) *model.User {
+	t.Helper()
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &model.User{Username: username, Mobile: mobile, MobileVerified: true, Nickname: username, Password: hash}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+var errStoreDown = errors.New("store down")
+
+// faultyStore 包装内存存储：fail 返回非 nil 时对应的读操作报错，beforeCAS 在
+// CompareAndSet 执行前调用，用于在读与写之间插入并发请求。
+type faultyStore struct {
+	auth.SessionStore
+	fail      func(key string) error
+	beforeCAS func(key string)
+}
+
+func (f *faultyStore) Get(key string) (string, error) {
+	if err := f.check(key); err != nil {
+		return "", err
+	}
+	return f.SessionStore.Get(key)
+}
+
+func (f *faultyStore) Exists(key string) (bool, error) {
+	if err := f.check(key); err != nil {
+		return false, err
+	}
+	return f.SessionStore.Exists(key)
+}
+
+func (f *faultyStore) CompareAndSet(key, old, value string, ttl time.Duration) (bool, error) {
+	if f.beforeCAS != nil {
+		f.beforeCAS(key)
+	}
+	return f.SessionStore.CompareAndSet(key, old, value, ttl)
+}
+
+func (f *faultyStore) check(key string) error {
+	if f.fail == nil {
+		return nil
+	}
+	return f.fail(key)
+}
+
+// useFaultyStore 将服务的会话存储换成包装后的 faultyStore。
+func useFaultyStore(s *UserService) *faultyStore {
+	f := &faultyStore{SessionStore: s.Session.Store()}
+	s.Session = auth.NewSessionManager(f)
+	return f
+}
+
+func TestRotateRefreshTokenReuseRevokesFamily(t *testing.T) {
+	s := newTestService(t)
+	access, refresh := seedSession(t, s, 7, "phone")
+	client := ClientInfo{Device: "phone"}
+
+	_, rotated, err := s.RotateRefreshToken(refresh, client)
+	if err != nil {
+		t.Fatalf("first rotation failed: %v", err)
+	}
+
+	// 重放已轮换的 refresh：整个家族被吊销
+	if _, _, err := s.RotateRefreshToken(refresh, client); !errors.Is(err, ErrRefreshReused) {
+		t.Fatalf("expected reuse detection, got %v", err)
+	}
+	if _, _, err := s.RotateRefreshToken(rotated, client); err == nil {
+		t.Fatal("current refresh token should be revoked with its family")
+	}
+	claims, err := auth.ParseToken(access)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := s.Session.FamilyRevoked(claims.FamilyID); !revoked {
+		t.Fatal("family should be revoked")
+	}
+	if sessions, _ := s.ListSessions(7); len(sessions) != 0 {
+		t.Fatalf("session should be removed, got %v", sessions)
+	}
+}
+
+func TestRotateDPoPBoundRefreshRequiresKey(t *testing.T) {
+	s := newTestService(t)
+	_, refresh := seedSessionWith(t, s, auth.TokenParams{UserID: 13, Device: "phone", JKT: "key-a"})
+
+	if _, _, err := s.RotateRefreshToken(refresh, ClientInfo{DPoPKey: "key-b"}); !errors.Is(err, auth.ErrDPoPBinding) {
+		t.Fatalf("expected binding error, got %v", err)
+	}
+	_, rotated, err := s.RotateRefreshToken(refresh, ClientInfo{DPoPKey: "key-a"})
+	if err != nil {
+		t.Fatalf("rotation with the bound key failed: %v", err)
+	}
+	// 轮换后的 token 仍绑定同一公钥
+	claims, err := auth.ParseToken(rotated)
+	if err != nil || claims.BoundKey() != "key-a" {
+		t.Fatalf("rotated token binding = %v, %v", claims, err)
+	}
+}
+
+func TestRefreshReuseRevokesFamilyAccessTokens(t *testing.T) {
+	s := newTestService(t)
+	access, refresh := seedSession(t, s, 8, "phone")
+	client := ClientInfo{Device: "phone"}
+	newAccess, _, err := s.RotateRefreshToken(refresh, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateAccessToken(newAccess); err != nil {
+		t.Fatalf("access token of a live family rejected: %v", err)
+	}
+
+	if _, _, err := s.RotateRefreshToken(refresh, client); !errors.Is(err, ErrRefreshReused) {
+		t.Fatalf("expected reuse detection, got %v", err)
+	}
+	// 家族内已签发的 access token 全部失效
+	for _, token := range []string{access, newAccess} {
+		if _, err := s.ValidateAccessToken(token); !errors.Is(err, ErrTokenRevoked) {
+			t.Fatalf("access token of a revoked family = %v", err)
+		}
+	}
+}
+
+func TestRefreshReuseKeepsNewerLoginOnSameDevice(t *testing.T) {
+	s := newTestService(t)
+	_, old := seedSession(t, s, 10, "phone")
+	if _, _, err := s.RotateRefreshToken(old, ClientInfo{Device: "phone"}); err != nil {
+		t.Fatal(err)
+	}
+	// 同一设备重新登录，开启新的家族
+	_, current := seedSession(t, s, 10, "phone")
+
+	if _, _, err := s.RotateRefreshToken(old, ClientInfo{Device: "phone"}); !errors.Is(err, ErrRefreshReused) {
+		t.Fatalf("expected reuse detection, got %v", err)
+	}
+	if _, _, err := s.RotateRefreshToken(current, ClientInfo{Device: "phone"}); err != nil {
+		t.Fatalf("newer login was revoked by a replay of the old family: %v", err)
+	}
+}
+
+func TestConcurrentRefreshRotatesOnce(t *testing.T) {
+	s := newTestService(t)
+	_, refresh := seedSession(t, s, 11, "phone")
+	client := ClientInfo{Device: "phone"}
+	store := useFaultyStore(s)
+
+	// 第一个请求通过校验、写入会话之前，第二个请求用同一 token 完成轮换
+	var winner error
+	store.beforeCAS = func(key string) {
+		if !strings.HasPrefix(key, "rb:session:") {
+			return
+		}
+		store.beforeCAS = nil
+		_, _, winner = s.RotateRefreshToken(refresh, client)
+	}
+	_, _, err := s.RotateRefreshToken(refresh, client)
+	if winner != nil {
+		t.Fatalf("first rotation failed: %v", winner)
+	}
+	if !errors.Is(err, ErrRefreshReused) {
+		t.Fatalf("losing rotation = %v, want reuse detection", err)
+	}
+	claims, _ := auth.ParseToken(refresh)
+	if revoked, _ := s.Session.FamilyRevoked(claims.FamilyID); !revoked {
+		t.Fatal("family should be revoked after a concurrent reuse")
+	}
+}
+
+func TestRefreshStoreFailureKeepsFamily(t *testing.T) {
+	s := newTestService(t)
+	_, refresh := seedSession(t, s, 12, "phone")
+	store := useFaultyStore(s)
+	store.fail = func(key string) error {
+		if strings.HasPrefix(key, "rb:session:") {
+			return errStoreDown
+		}
+		return nil
+	}
+
+	if _, _, err := s.RotateRefreshToken(refresh, ClientInfo{Device: "phone"}); !errors.Is(err, auth.ErrUnavailable) {
+		t.Fatalf("expected a backend failure, got %v", err)
+	}
+	store.fail = nil
+	if _, _, err := s.RotateRefreshToken(refresh, ClientInfo{Device: "phone"}); err != nil {
+		t.Fatalf("family revoked by a store failure: %v", err)
+	}
+}
@@ -1,13 +1,195 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"redbook/config"
+)
+
+// PasswordHasher is one password hashing scheme. Hashes are self-describing
+// strings, so a hasher can tell its own hashes apart and read their parameters.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, hash string) bool
+	// Owns reports whether hash was produced by this scheme.
+	Owns(hash string) bool
+	// Outdated reports whether an owned hash uses parameters other than the current ones.
+	Outdated(hash string) bool
+}
+
+// 当前用于生成新哈希的方案；校验时按哈希前缀在 passwordHashers 中选择。
+var (
+	currentHasher   PasswordHasher = NewArgon2idHasher(config.Argon2Config{})
+	passwordHashers                = []PasswordHasher{currentHasher, BcryptHasher{}}
+)
+
+// NewPasswordHasher builds the hasher selected in config.
+func NewPasswordHasher(cfg config.PasswordConfig) (PasswordHasher, error) {
+	switch cfg.Algorithm {
+	case "", config.PasswordArgon2id:
+		return NewArgon2idHasher(cfg.Argon2), nil
+	case config.PasswordBcrypt:
+		return BcryptHasher{Cost: cfg.BcryptCost}, nil
+	default:
+		return nil, fmt.Errorf("unsupported password algorithm %q", cfg.Algorithm)
+	}
+}
+
+// SetPasswordHasher replaces the scheme used for new hashes. Hashes of every
+// built-in scheme keep verifying.
+func SetPasswordHasher(h PasswordHasher) {
+	currentHasher = h
+	passwordHashers = []PasswordHasher{h, NewArgon2idHasher(config.Argon2Config{}), BcryptHasher{}}
+}
 
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+	return currentHasher.Hash(password)
 }
 
 func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	for _, h := range passwordHashers {
+		if h.Owns(hash) {
+			return h.Verify(password, hash)
+		}
+	}
+	return false
+}
+
+// PasswordNeedsRehash reports whether hash should be replaced by a fresh one
+// from the current hasher, because its scheme or parameters are out of date.
+func PasswordNeedsRehash(hash string) bool {
+	return !currentHasher.Owns(hash) || currentHasher.Outdated(hash)
+}
+
+// BcryptHasher produces $2a$ hashes. A zero Cost means bcrypt.DefaultCost.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (b BcryptHasher) cost() int {
+	if b.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return b.Cost
+}
+
+func (b BcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), b.cost())
+	return string(bytes), err
+}
+
+func (b BcryptHasher) Verify(password, hash string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (b BcryptHasher) Owns(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (b BcryptHasher) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < b.cost()
+}
+
+// Argon2idHasher produces PHC-format hashes:
+// $argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>
+type Argon2idHasher struct {
+	params argon2Params
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	saltLen     uint32
+	keyLen      uint32
+}
+
+const argon2Prefix = "$argon2id$"
+
+// NewArgon2idHasher fills unset parameters with the OWASP baseline
+// (64 MiB, 3 passes, 2 lanes, 16-byte salt, 32-byte key).
+func NewArgon2idHasher(cfg config.Argon2Config) *Argon2idHasher {
+	p := argon2Params{memory: 64 * 1024, iterations: 3, parallelism: 2, saltLen: 16, keyLen: 32}
+	if cfg.Memory > 0 {
+		p.memory = cfg.Memory
+	}
+	if cfg.Iterations > 0 {
+		p.iterations = cfg.Iterations
+	}
+	if cfg.Parallelism > 0 {
+		p.parallelism = cfg.Parallelism
+	}
+	if cfg.SaltLength > 0 {
+		p.saltLen = cfg.SaltLength
+	}
+	if cfg.KeyLength > 0 {
+		p.keyLen = cfg.KeyLength
+	}
+	return &Argon2idHasher{params: p}
+}
+
+func (a *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.params.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := a.params
+	key := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, p.keyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version,
+		p.memory, p.iterations, p.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a *Argon2idHasher) Verify(password, hash string) bool {
+	p, salt, key, err := decodeArgon2(hash)
+	if err != nil {
+		return false
+	}
+	other := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+func (a *Argon2idHasher) Owns(hash string) bool {
+	return strings.HasPrefix(hash, argon2Prefix)
+}
+
+func (a *Argon2idHasher) Outdated(hash string) bool {
+	p, _, key, err := decodeArgon2(hash)
+	if err != nil {
+		return true
+	}
+	return p.memory != a.params.memory || p.iterations != a.params.iterations ||
+		p.parallelism != a.params.parallelism || uint32(len(key)) != a.params.keyLen
+}
+
+func decodeArgon2(hash string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, fmt.Errorf("malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return p, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	return p, salt, key, nil
 }
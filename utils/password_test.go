@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"redbook/config"
+)
+
+func TestPasswordRehashFromBcrypt(t *testing.T) {
+	SetPasswordHasher(NewArgon2idHasher(config.Argon2Config{Memory: 1024, Iterations: 1, Parallelism: 1}))
+	defer SetPasswordHasher(NewArgon2idHasher(config.Argon2Config{}))
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPasswordHash("secret123", string(legacy)) {
+		t.Fatal("legacy bcrypt hash should still verify")
+	}
+	if !PasswordNeedsRehash(string(legacy)) {
+		t.Fatal("bcrypt hash should be upgraded to argon2id")
+	}
+
+	hash, err := HashPassword("secret123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("unexpected hash format %q", hash)
+	}
+	if !CheckPasswordHash("secret123", hash) || CheckPasswordHash("secret124", hash) {
+		t.Fatal("argon2id verification mismatch")
+	}
+	if PasswordNeedsRehash(hash) {
+		t.Fatal("fresh hash should not need rehash")
+	}
+
+	// 提高参数后，旧参数的哈希需要升级但仍可校验
+	SetPasswordHasher(NewArgon2idHasher(config.Argon2Config{Memory: 2048, Iterations: 1, Parallelism: 1}))
+	if !PasswordNeedsRehash(hash) || !CheckPasswordHash("secret123", hash) {
+		t.Fatal("hash with old parameters should verify and need rehash")
+	}
+}